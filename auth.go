@@ -0,0 +1,410 @@
+package main
+
+import (
+	poststore "SimpleRest/store"
+	"context"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Requests authenticate with an API key, sent as X-API-Key or as a bearer
+// token, or with a JWT bearer token signed with HS256 or EdDSA. Every route
+// requires it unless it was registered with allowAnonymous.
+
+// principal is the authenticated caller of a request.
+type principal struct {
+	Subject string         `json:"subject"`
+	Role    poststore.Role `json:"role"`
+	// Via is how it authenticated: api_key, admin_key or jwt.
+	Via string `json:"via"`
+}
+
+func (p principal) actor() poststore.Actor {
+	return poststore.Actor{Name: p.Subject, Role: p.Role}
+}
+
+type principalKey struct{}
+
+func withPrincipal(ctx context.Context, p principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// principalFrom returns the caller of the request ctx belongs to, false for
+// an anonymous one.
+func principalFrom(ctx context.Context) (principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(principal)
+	return p, ok
+}
+
+// actorFrom is who the policy checks req against, the zero Actor for an
+// anonymous request.
+func actorFrom(req *http.Request) poststore.Actor {
+	p, _ := principalFrom(req.Context())
+	return p.actor()
+}
+
+// apiKeyPrefix starts every API key, which reads sr_<id>_<secret>.
+const apiKeyPrefix = "sr_"
+
+// jwtLeeway is the clock skew tolerated when checking exp and nbf.
+const jwtLeeway = time.Minute
+
+// errBadCredentials is wrapped by every authentication failure caused by
+// the credentials themselves.
+var errBadCredentials = errors.New("invalid credentials")
+
+// jwtConfig are the keys and claims bearer tokens are checked against.
+type jwtConfig struct {
+	// secret verifies HS256 tokens, publicKey EdDSA (Ed25519) ones.
+	secret    []byte
+	publicKey ed25519.PublicKey
+	// issuer and audience, when set, must match the iss and aud claims.
+	issuer   string
+	audience string
+}
+
+type authenticator struct {
+	keys poststore.KeyStore
+	// adminKey is a static API key with admin rights, to bootstrap the
+	// keys kept in the store.
+	adminKey string
+	jwt      jwtConfig
+	// anonymous are the routes that serve requests without credentials.
+	anonymous map[*mux.Route]bool
+}
+
+func newAuthenticator(keys poststore.KeyStore, adminKey string, jwt jwtConfig) *authenticator {
+	return &authenticator{keys: keys, adminKey: adminKey, jwt: jwt, anonymous: map[*mux.Route]bool{}}
+}
+
+// allowAnonymous lets r serve requests without credentials. Requests with
+// credentials are still authenticated.
+func (a *authenticator) allowAnonymous(r *mux.Route) *mux.Route {
+	a.anonymous[r] = true
+	return r
+}
+
+// middleware authenticates every request routed by the router and puts
+// the principal in its context.
+func (a *authenticator) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		p, ok, err := a.authenticate(req)
+		switch {
+		case errors.Is(err, errBadCredentials):
+			unauthorized(w, `, error="invalid_token"`, err.Error())
+		case err != nil:
+			renderStoreError(w, err)
+		case ok:
+			requestLogFrom(req.Context()).principal = p.Subject
+			next.ServeHTTP(w, req.WithContext(withPrincipal(req.Context(), p)))
+		case a.anonymous[mux.CurrentRoute(req)]:
+			next.ServeHTTP(w, req)
+		default:
+			unauthorized(w, "", "authentication required, send an API key or a bearer token")
+		}
+	})
+}
+
+func unauthorized(w http.ResponseWriter, params, msg string) {
+	w.Header().Set("WWW-Authenticate", `Bearer realm="SimpleRest"`+params)
+	renderError(w, http.StatusUnauthorized, msg)
+}
+
+// adminOnly restricts h to admins.
+func adminOnly(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if err := actorFrom(req).CanAdminister(); err != nil {
+			renderStoreError(w, err)
+			return
+		}
+		h(w, req)
+	}
+}
+
+// authenticate checks the credentials of req, ok is false when it has
+// none.
+func (a *authenticator) authenticate(req *http.Request) (p principal, ok bool, err error) {
+	key := req.Header.Get("X-API-Key")
+	if key == "" {
+		auth := req.Header.Get("Authorization")
+		if auth == "" {
+			return principal{}, false, nil
+		}
+		const bearer = "bearer "
+		if len(auth) < len(bearer) || !strings.EqualFold(auth[:len(bearer)], bearer) {
+			return principal{}, false, fmt.Errorf("%w: expect a Bearer authorization", errBadCredentials)
+		}
+		token := strings.TrimSpace(auth[len(bearer):])
+		if !strings.HasPrefix(token, apiKeyPrefix) {
+			p, err := a.jwt.verify(token, time.Now())
+			return p, err == nil, err
+		}
+		key = token
+	}
+	p, err = a.apiKey(req.Context(), key)
+	return p, err == nil, err
+}
+
+// apiKey authenticates an API key.
+func (a *authenticator) apiKey(ctx context.Context, key string) (principal, error) {
+	if a.adminKey != "" && subtle.ConstantTimeCompare([]byte(key), []byte(a.adminKey)) == 1 {
+		return principal{Subject: "admin", Role: poststore.RoleAdmin, Via: "admin_key"}, nil
+	}
+	id, secret, ok := splitAPIKey(key)
+	if !ok {
+		return principal{}, fmt.Errorf("%w: malformed API key", errBadCredentials)
+	}
+	k, err := a.keys.GetAPIKey(ctx, id)
+	if errors.Is(err, poststore.ErrNotFound) {
+		return principal{}, fmt.Errorf("%w: unknown API key", errBadCredentials)
+	}
+	if err != nil {
+		return principal{}, err
+	}
+	hash := sha256.Sum256([]byte(secret))
+	if subtle.ConstantTimeCompare(hash[:], k.Hash) != 1 {
+		return principal{}, fmt.Errorf("%w: unknown API key", errBadCredentials)
+	}
+	return principal{Subject: k.Name, Role: k.Role, Via: "api_key"}, nil
+}
+
+func splitAPIKey(key string) (id, secret string, ok bool) {
+	if !strings.HasPrefix(key, apiKeyPrefix) {
+		return "", "", false
+	}
+	parts := strings.SplitN(key[len(apiKeyPrefix):], "_", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", false
+	}
+	return parts[0], parts[1], true
+}
+
+// audience is the aud claim, a string or an array of them.
+type audience []string
+
+func (a *audience) UnmarshalJSON(b []byte) error {
+	var one string
+	if err := json.Unmarshal(b, &one); err == nil {
+		*a = audience{one}
+		return nil
+	}
+	var many []string
+	err := json.Unmarshal(b, &many)
+	*a = many
+	return err
+}
+
+type jwtClaims struct {
+	Subject   string   `json:"sub"`
+	Issuer    string   `json:"iss"`
+	Audience  audience `json:"aud"`
+	ExpiresAt *float64 `json:"exp"`
+	NotBefore *float64 `json:"nbf"`
+	// Role defaults to writer.
+	Role string `json:"role"`
+}
+
+// verify checks the signature and claims of a compact JWT (RFC 7519). The
+// token must carry sub and exp.
+func (c jwtConfig) verify(token string, now time.Time) (principal, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return principal{}, fmt.Errorf("%w: malformed bearer token", errBadCredentials)
+	}
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return principal{}, err
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return principal{}, fmt.Errorf("%w: malformed token signature", errBadCredentials)
+	}
+
+	signed := []byte(parts[0] + "." + parts[1])
+	switch {
+	case header.Alg == "HS256" && c.secret != nil:
+		mac := hmac.New(sha256.New, c.secret)
+		mac.Write(signed)
+		if !hmac.Equal(sig, mac.Sum(nil)) {
+			return principal{}, fmt.Errorf("%w: bad token signature", errBadCredentials)
+		}
+	case header.Alg == "EdDSA" && c.publicKey != nil:
+		if !ed25519.Verify(c.publicKey, signed, sig) {
+			return principal{}, fmt.Errorf("%w: bad token signature", errBadCredentials)
+		}
+	default:
+		// never trust the token to pick its own verification, none least
+		return principal{}, fmt.Errorf("%w: token algorithm %q is not accepted", errBadCredentials, header.Alg)
+	}
+
+	var claims jwtClaims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return principal{}, err
+	}
+	switch {
+	case claims.Subject == "":
+		return principal{}, fmt.Errorf("%w: token has no sub", errBadCredentials)
+	case claims.ExpiresAt == nil:
+		return principal{}, fmt.Errorf("%w: token has no exp", errBadCredentials)
+	case now.Add(-jwtLeeway).After(numericDate(*claims.ExpiresAt)):
+		return principal{}, fmt.Errorf("%w: token expired", errBadCredentials)
+	case claims.NotBefore != nil && now.Add(jwtLeeway).Before(numericDate(*claims.NotBefore)):
+		return principal{}, fmt.Errorf("%w: token not valid yet", errBadCredentials)
+	case c.issuer != "" && claims.Issuer != c.issuer:
+		return principal{}, fmt.Errorf("%w: token issuer is not %s", errBadCredentials, c.issuer)
+	case c.audience != "" && !hasString(claims.Audience, c.audience):
+		return principal{}, fmt.Errorf("%w: token audience is not %s", errBadCredentials, c.audience)
+	}
+	if claims.Role == "" {
+		claims.Role = string(poststore.RoleWriter)
+	}
+	role, err := poststore.ParseRole(claims.Role)
+	if err != nil {
+		return principal{}, fmt.Errorf("%w: token role %q is unknown", errBadCredentials, claims.Role)
+	}
+	return principal{Subject: claims.Subject, Role: role, Via: "jwt"}, nil
+}
+
+func decodeSegment(seg string, v interface{}) error {
+	js, err := base64.RawURLEncoding.DecodeString(seg)
+	if err == nil {
+		err = json.Unmarshal(js, v)
+	}
+	if err != nil {
+		return fmt.Errorf("%w: malformed bearer token", errBadCredentials)
+	}
+	return nil
+}
+
+// numericDate converts seconds since the epoch, possibly fractional.
+func numericDate(secs float64) time.Time {
+	return time.Unix(0, int64(secs*float64(time.Second)))
+}
+
+func hasString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// loadEd25519Key reads a PEM encoded PKIX Ed25519 public key.
+func loadEd25519Key(path string) (ed25519.PublicKey, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s holds no PEM block", path)
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	pub, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("%s is not an Ed25519 public key", path)
+	}
+	return pub, nil
+}
+
+// requestKey is the JSON body of API key create requests. Role defaults to
+// writer.
+type requestKey struct {
+	Name string         `json:"name"`
+	Role poststore.Role `json:"role"`
+}
+
+// createdKey shows the key itself, which is only rendered on creation.
+type createdKey struct {
+	poststore.APIKey
+	Key string `json:"key"`
+}
+
+func (ps *postStore) whoamiHandler(w http.ResponseWriter, req *http.Request) {
+	p, _ := principalFrom(req.Context())
+	renderJSON(w, p)
+}
+
+func (ps *postStore) createKeyHandler(w http.ResponseWriter, req *http.Request) {
+	if _, ok := requireMediaType(w, req, "application/json"); !ok {
+		return
+	}
+	dec := json.NewDecoder(req.Body)
+	dec.DisallowUnknownFields()
+	var rk requestKey
+	if err := dec.Decode(&rk); err != nil {
+		renderError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	id := make([]byte, 8)
+	secret := make([]byte, 32)
+	if _, err := rand.Read(id); err != nil {
+		renderError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if _, err := rand.Read(secret); err != nil {
+		renderError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if rk.Role == "" {
+		rk.Role = poststore.RoleWriter
+	}
+	k := poststore.APIKey{ID: hex.EncodeToString(id), Name: rk.Name, Role: rk.Role}
+	plain := base64.RawURLEncoding.EncodeToString(secret)
+	hash := sha256.Sum256([]byte(plain))
+	k.Hash = hash[:]
+
+	k, err := ps.store.CreateAPIKey(req.Context(), k)
+	if err != nil {
+		renderStoreError(w, err)
+		return
+	}
+	renderJSON(w, createdKey{APIKey: k, Key: apiKeyPrefix + k.ID + "_" + plain})
+}
+
+func (ps *postStore) listKeysHandler(w http.ResponseWriter, req *http.Request) {
+	keys, err := ps.store.ListAPIKeys(req.Context())
+	if err != nil {
+		renderStoreError(w, err)
+		return
+	}
+	renderJSON(w, keys)
+}
+
+func (ps *postStore) getKeyHandler(w http.ResponseWriter, req *http.Request) {
+	k, err := ps.store.GetAPIKey(req.Context(), mux.Vars(req)["id"])
+	if err != nil {
+		renderStoreError(w, err)
+		return
+	}
+	renderJSON(w, k)
+}
+
+func (ps *postStore) deleteKeyHandler(w http.ResponseWriter, req *http.Request) {
+	if err := ps.store.DeleteAPIKey(req.Context(), mux.Vars(req)["id"]); err != nil {
+		renderStoreError(w, err)
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	poststore "SimpleRest/store"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+// token signs claims with the alg named in its header: HS256 with key as
+// the secret, EdDSA with key as an ed25519 private key, none without a
+// signature.
+func token(t *testing.T, alg string, key []byte, claims map[string]interface{}) string {
+	t.Helper()
+	segment := func(v interface{}) string {
+		b, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return base64.RawURLEncoding.EncodeToString(b)
+	}
+	signed := segment(map[string]string{"alg": alg, "typ": "JWT"}) + "." + segment(claims)
+	var sig []byte
+	switch alg {
+	case "HS256":
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(signed))
+		sig = mac.Sum(nil)
+	case "EdDSA":
+		sig = ed25519.Sign(ed25519.PrivateKey(key), []byte(signed))
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func TestVerifyJWT(t *testing.T) {
+	now := time.Date(2026, 11, 1, 12, 0, 0, 0, time.UTC)
+	at := func(d time.Duration) float64 { return float64(now.Add(d).Unix()) }
+	secret := []byte("hmac secret")
+	public, private, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hs := jwtConfig{secret: secret}
+	ed := jwtConfig{publicKey: public}
+	claims := func(kv ...interface{}) map[string]interface{} {
+		c := map[string]interface{}{"sub": "alice", "exp": at(time.Hour)}
+		for i := 0; i < len(kv); i += 2 {
+			if kv[i+1] == nil {
+				delete(c, kv[i].(string))
+			} else {
+				c[kv[i].(string)] = kv[i+1]
+			}
+		}
+		return c
+	}
+
+	tests := []struct {
+		name  string
+		conf  jwtConfig
+		token string
+		// want is the zero principal when the token is rejected
+		want principal
+	}{
+		{"HS256", hs, token(t, "HS256", secret, claims()), principal{"alice", poststore.RoleWriter, "jwt"}},
+		{"EdDSA", ed, token(t, "EdDSA", private, claims()), principal{"alice", poststore.RoleWriter, "jwt"}},
+		{"role", hs, token(t, "HS256", secret, claims("role", "admin")), principal{"alice", poststore.RoleAdmin, "jwt"}},
+		{"unknown role", hs, token(t, "HS256", secret, claims("role", "root")), principal{}},
+
+		// algorithms
+		{"none", hs, token(t, "none", nil, claims()), principal{}},
+		{"none without a key", jwtConfig{}, token(t, "none", nil, claims()), principal{}},
+		{"HS256 on the EdDSA key", ed, token(t, "HS256", public, claims()), principal{}},
+		{"EdDSA without a key", hs, token(t, "EdDSA", private, claims()), principal{}},
+		{"bad HS256 signature", hs, token(t, "HS256", []byte("other secret"), claims()), principal{}},
+		{"bad EdDSA signature", jwtConfig{publicKey: make(ed25519.PublicKey, ed25519.PublicKeySize)}, token(t, "EdDSA", private, claims()), principal{}},
+		{"malformed", hs, "a.b", principal{}},
+		{"malformed signature", hs, token(t, "HS256", secret, claims()) + "!", principal{}},
+
+		// claims
+		{"no sub", hs, token(t, "HS256", secret, claims("sub", nil)), principal{}},
+		{"no exp", hs, token(t, "HS256", secret, claims("exp", nil)), principal{}},
+		{"expired within the leeway", hs, token(t, "HS256", secret, claims("exp", at(-jwtLeeway))), principal{"alice", poststore.RoleWriter, "jwt"}},
+		{"expired past the leeway", hs, token(t, "HS256", secret, claims("exp", at(-jwtLeeway-time.Second))), principal{}},
+		{"not valid yet within the leeway", hs, token(t, "HS256", secret, claims("nbf", at(jwtLeeway))), principal{"alice", poststore.RoleWriter, "jwt"}},
+		{"not valid yet past the leeway", hs, token(t, "HS256", secret, claims("nbf", at(jwtLeeway+time.Second))), principal{}},
+		{"issuer", jwtConfig{secret: secret, issuer: "idp"}, token(t, "HS256", secret, claims("iss", "idp")), principal{"alice", poststore.RoleWriter, "jwt"}},
+		{"other issuer", jwtConfig{secret: secret, issuer: "idp"}, token(t, "HS256", secret, claims("iss", "other")), principal{}},
+		{"audience in a list", jwtConfig{secret: secret, audience: "api"}, token(t, "HS256", secret, claims("aud", []string{"web", "api"})), principal{"alice", poststore.RoleWriter, "jwt"}},
+		{"audience as a string", jwtConfig{secret: secret, audience: "api"}, token(t, "HS256", secret, claims("aud", "api")), principal{"alice", poststore.RoleWriter, "jwt"}},
+		{"other audience", jwtConfig{secret: secret, audience: "api"}, token(t, "HS256", secret, claims("aud", "web")), principal{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.conf.verify(tt.token, now)
+			if tt.want == (principal{}) {
+				if !errors.Is(err, errBadCredentials) {
+					t.Errorf("verify = %+v, %v, want bad credentials", got, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("verify = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSplitAPIKey(t *testing.T) {
+	tests := []struct {
+		key        string
+		id, secret string
+		ok         bool
+	}{
+		{"sr_alice_secret", "alice", "secret", true},
+		// the secret may hold underscores, the id may not
+		{"sr_alice_sec_ret", "alice", "sec_ret", true},
+		{"sr_alice", "", "", false},
+		{"sr__secret", "", "", false},
+		{"sr_alice_", "", "", false},
+		{"alice_secret", "", "", false},
+		{"SR_alice_secret", "", "", false},
+		{"", "", "", false},
+	}
+	for _, tt := range tests {
+		id, secret, ok := splitAPIKey(tt.key)
+		if id != tt.id || secret != tt.secret || ok != tt.ok {
+			t.Errorf("splitAPIKey(%q) = %q, %q, %v, want %q, %q, %v", tt.key, id, secret, ok, tt.id, tt.secret, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	poststore "SimpleRest/store"
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gorilla/mux"
+)
+
+// icalTime is the UTC DATE-TIME form of RFC 5545.
+const icalTime = "20060102T150405Z"
+
+// calendarPast is how long a post stays in the calendars after it was due.
+const calendarPast = 30 * 24 * time.Hour
+
+func (ps *postStore) calendarHandler(w http.ResponseWriter, req *http.Request) {
+	ps.renderCalendar(w, req, poststore.Filter{}, "SimpleRest posts")
+}
+
+func (ps *postStore) authorCalendarHandler(w http.ResponseWriter, req *http.Request) {
+	author := mux.Vars(req)["author"]
+	ps.renderCalendar(w, req, poststore.Filter{Author: author}, "Posts by "+author)
+}
+
+func (ps *postStore) tagCalendarHandler(w http.ResponseWriter, req *http.Request) {
+	tag := mux.Vars(req)["tag"]
+	ps.renderCalendar(w, req, poststore.Filter{Tags: []string{tag}}, "Posts tagged "+tag)
+}
+
+// calendarPosts returns the posts matching f that were due at most
+// calendarPast ago, soonest first, up to calendarSize of them.
+func (ps *postStore) calendarPosts(ctx context.Context, f poststore.Filter) ([]poststore.Posts, error) {
+	if from := time.Now().Add(-calendarPast); f.DueFrom.Before(from) {
+		f.DueFrom = from
+	}
+	var posts []poststore.Posts
+	o := ps.options()
+	opts := poststore.ListOptions{Sort: "due", Limit: o.maxPageSize}
+	for {
+		if left := o.calendarSize - len(posts); left < opts.Limit {
+			opts.Limit = left
+		}
+		page, err := ps.store.ListPosts(ctx, f, opts)
+		if err != nil {
+			return nil, err
+		}
+		posts = append(posts, page.Posts...)
+		if page.Next == "" || len(posts) >= o.calendarSize {
+			return posts, nil
+		}
+		opts.Cursor = page.Next
+	}
+}
+
+// renderCalendar writes the posts matching f, as chosen by calendarPosts,
+// as an RFC 5545 calendar with one VEVENT per post, or one VTODO with
+// ?component=vtodo. The ETag only depends on the ids and versions of the
+// posts, so subscribed clients polling with If-None-Match get a 304 until
+// a post changes.
+func (ps *postStore) renderCalendar(w http.ResponseWriter, req *http.Request, f poststore.Filter, name string) {
+	component := strings.ToUpper(req.URL.Query().Get("component"))
+	if component == "" {
+		component = "VEVENT"
+	}
+	if component != "VEVENT" && component != "VTODO" {
+		renderError(w, http.StatusBadRequest, "component must be vevent or vtodo")
+		return
+	}
+
+	posts, err := ps.calendarPosts(req.Context(), actorFrom(req).Visible(f))
+	if err != nil {
+		renderStoreError(w, err)
+		return
+	}
+
+	if listNotModified(w, req, listETag(component, posts)) {
+		return
+	}
+
+	// UIDs must not change with the host a client used, so they take the
+	// one of the base URL like feed links do
+	host := req.Host
+	if u, err := url.Parse(ps.absURL(req, "")); err == nil && u.Hostname() != "" {
+		host = u.Hostname()
+	}
+	stamp := time.Now().UTC().Format(icalTime)
+
+	var b bytes.Buffer
+	line := func(name, value string) { writeICalLine(&b, name+":"+value) }
+	line("BEGIN", "VCALENDAR")
+	line("VERSION", "2.0")
+	line("PRODID", "-//SimpleRest//Posts//EN")
+	line("CALSCALE", "GREGORIAN")
+	line("X-WR-CALNAME", icalEscape(name))
+	for _, p := range posts {
+		due := p.Due.UTC().Format(icalTime)
+		line("BEGIN", component)
+		line("UID", fmt.Sprintf("post-%d@%s", p.ID, host))
+		line("DTSTAMP", stamp)
+		line("SEQUENCE", fmt.Sprint(p.Version-1))
+		if component == "VEVENT" {
+			line("DTSTART", due)
+		} else {
+			line("DUE", due)
+		}
+		line("SUMMARY", icalEscape(summary(p.Text)))
+		line("DESCRIPTION", icalEscape(p.Text+"\n\n— "+p.Author))
+		if len(p.Tags) > 0 {
+			cats := make([]string, len(p.Tags))
+			for i, t := range p.Tags {
+				cats[i] = icalEscape(t)
+			}
+			line("CATEGORIES", strings.Join(cats, ","))
+		}
+		line("END", component)
+	}
+	line("END", "VCALENDAR")
+
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.Write(b.Bytes())
+}
+
+// summary is the first line of text, shortened for a calendar title.
+func summary(text string) string {
+	if i := strings.IndexByte(text, '\n'); i >= 0 {
+		text = text[:i]
+	}
+	const max = 80
+	if utf8.RuneCountInString(text) > max {
+		text = string([]rune(text)[:max-1]) + "…"
+	}
+	return text
+}
+
+// icalEscape escapes a TEXT value.
+func icalEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`, "\r", `\n`).Replace(s)
+}
+
+// writeICalLine writes a content line folded at 75 octets without
+// splitting a UTF-8 sequence, as RFC 5545 section 3.1 requires.
+func writeICalLine(b *bytes.Buffer, line string) {
+	limit := 75
+	for len(line) > limit {
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(line[cut]) {
+			cut--
+		}
+		b.WriteString(line[:cut])
+		b.WriteString("\r\n ")
+		line = line[cut:]
+		// the leading space of a continuation line counts
+		limit = 74
+	}
+	b.WriteString(line)
+	b.WriteString("\r\n")
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	poststore "SimpleRest/store"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// etag is the strong entity tag of a post. It changes with every update.
+func etag(p poststore.Posts) string {
+	return fmt.Sprintf(`"%d-%d"`, p.ID, p.Version)
+}
+
+// parseETags splits an If-Match or If-None-Match header into its entity
+// tags. weak keeps W/ tags (stripped of the prefix) for weak comparison,
+// otherwise they are dropped as they can never match strongly.
+func parseETags(header string, weak bool) []string {
+	var tags []string
+	for _, t := range strings.Split(header, ",") {
+		t = strings.TrimSpace(t)
+		if strings.HasPrefix(t, "W/") {
+			if !weak {
+				continue
+			}
+			t = t[2:]
+		}
+		if t != "" {
+			tags = append(tags, t)
+		}
+	}
+	return tags
+}
+
+func containsETag(tags []string, tag string) bool {
+	for _, t := range tags {
+		if t == "*" || t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// notModified reports whether the If-None-Match header of req matches p, in
+// which case it has written the 304 response.
+func notModified(w http.ResponseWriter, req *http.Request, p poststore.Posts) bool {
+	header := req.Header.Get("If-None-Match")
+	if header == "" || !containsETag(parseETags(header, true), etag(p)) {
+		return false
+	}
+	w.Header().Set("ETag", etag(p))
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+// listETag is a strong ETag for a representation rendered from posts in
+// kind (e.g. a feed format). It changes when any of the posts does.
+func listETag(kind string, posts []poststore.Posts) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n", kind)
+	for _, p := range posts {
+		fmt.Fprintf(h, "%d-%d\n", p.ID, p.Version)
+	}
+	return `"` + hex.EncodeToString(h.Sum(nil)[:16]) + `"`
+}
+
+// listNotModified sets tag as the ETag of the response and reports whether
+// the If-None-Match header of req matches it, in which case it has written
+// the 304 response.
+func listNotModified(w http.ResponseWriter, req *http.Request, tag string) bool {
+	w.Header().Set("ETag", tag)
+	w.Header().Set("Cache-Control", "no-cache")
+	if !containsETag(parseETags(req.Header.Get("If-None-Match"), true), tag) {
+		return false
+	}
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+// expectedVersion resolves the If-Match header of a write to post id into
+// the version the store must find, 0 meaning any. When it returns false the
+// response has already been written.
+func (ps *postStore) expectedVersion(w http.ResponseWriter, req *http.Request, id int) (int, bool) {
+	return ps.expectedVersionOf(w, req, id, ps.store.GetPost)
+}
+
+// expectedVersionOf is expectedVersion for the post get finds, such as a
+// post in the trash.
+func (ps *postStore) expectedVersionOf(w http.ResponseWriter, req *http.Request, id int, get func(context.Context, int) (poststore.Posts, error)) (int, bool) {
+	header := req.Header.Get("If-Match")
+	if header == "" {
+		if ps.options().requireIfMatch {
+			renderError(w, http.StatusPreconditionRequired, "If-Match is required, GET the post for its ETag")
+			return 0, false
+		}
+		return 0, true
+	}
+
+	current, err := get(req.Context(), id)
+	if err == nil && !actorFrom(req).CanRead(current) {
+		err = fmt.Errorf("post %d %w", id, poststore.ErrNotFound)
+	}
+	if err != nil {
+		renderStoreError(w, err)
+		return 0, false
+	}
+	if !containsETag(parseETags(header, false), etag(current)) {
+		renderError(w, http.StatusPreconditionFailed, fmt.Sprintf("post %d has ETag %s", id, etag(current)))
+		return 0, false
+	}
+	// the store checks the version again atomically with the write
+	return current.Version, true
+}
+
+// maxWriteAttempts bounds how often writePost reads and writes a post that
+// keeps changing in between.
+const maxWriteAttempts = 3
+
+// statusError is an error of a write that is answered with status rather
+// than with the status of a store error.
+type statusError struct {
+	status int
+	err    error
+}
+
+func (e *statusError) Error() string {
+	return e.err.Error()
+}
+
+// writePost reads post id with get and has write check it and store its
+// change at current.Version, which is version unless that is 0. Without
+// If-Match the change is made to whatever version is current: a concurrent
+// write between reading and storing it is retried rather than reported to
+// a client that did not ask for a precondition. It returns the post
+// written, or false when it has rendered an error.
+func (ps *postStore) writePost(w http.ResponseWriter, req *http.Request, id, version int, get func(context.Context, int) (poststore.Posts, error), write func(current poststore.Posts) (poststore.Posts, error)) (poststore.Posts, bool) {
+	for attempt := 1; ; attempt++ {
+		current, err := get(req.Context(), id)
+		if err == nil && !actorFrom(req).CanRead(current) {
+			err = fmt.Errorf("post %d %w", id, poststore.ErrNotFound)
+		}
+		if err != nil {
+			renderStoreError(w, err)
+			return poststore.Posts{}, false
+		}
+		if version != 0 && current.Version != version {
+			renderError(w, http.StatusPreconditionFailed, fmt.Sprintf("post %d has ETag %s", id, etag(current)))
+			return poststore.Posts{}, false
+		}
+
+		post, err := write(current)
+		if errors.Is(err, poststore.ErrPrecondition) && version == 0 && attempt < maxWriteAttempts {
+			continue
+		}
+		var se *statusError
+		switch {
+		case errors.As(err, &se):
+			renderError(w, se.status, se.Error())
+			return poststore.Posts{}, false
+		case err != nil:
+			renderStoreError(w, err)
+			return poststore.Posts{}, false
+		}
+		return post, true
+	}
+}
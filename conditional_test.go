@@ -0,0 +1,61 @@
+package main
+
+import (
+	poststore "SimpleRest/store"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestWritePost(t *testing.T) {
+	store := poststore.New()
+	post, err := store.CreatePost(context.Background(), "first", "alice", nil, time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC), false, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ps := NewPostServer(store)
+	stale := fmt.Errorf("post changed: %w", poststore.ErrPrecondition)
+
+	tests := []struct {
+		name    string
+		version int
+		// errs are returned by the attempts in turn, nil once they run out
+		errs     []error
+		attempts int
+		status   int
+	}{
+		{"first attempt", 0, nil, 1, http.StatusOK},
+		{"retried", 0, []error{stale, stale}, 3, http.StatusOK},
+		{"given up", 0, []error{stale, stale, stale}, maxWriteAttempts, http.StatusPreconditionFailed},
+		{"If-Match is not retried", post.Version, []error{stale}, 1, http.StatusPreconditionFailed},
+		{"stale If-Match", post.Version + 1, nil, 0, http.StatusPreconditionFailed},
+		{"status", 0, []error{&statusError{http.StatusConflict, errors.New("test failed")}}, 1, http.StatusConflict},
+		{"store error", 0, []error{poststore.ErrForbidden}, 1, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			attempts := 0
+			got, ok := ps.writePost(w, httptest.NewRequest("PUT", "/post/1/", nil), post.ID, tt.version, store.GetPost, func(current poststore.Posts) (poststore.Posts, error) {
+				attempts++
+				if attempts <= len(tt.errs) {
+					return poststore.Posts{}, tt.errs[attempts-1]
+				}
+				return current, nil
+			})
+			if attempts != tt.attempts {
+				t.Errorf("%d attempts, want %d", attempts, tt.attempts)
+			}
+			if ok != (tt.status == http.StatusOK) || (!ok && w.Code != tt.status) {
+				t.Errorf("writePost = %v with status %d, want %d", ok, w.Code, tt.status)
+			}
+			if ok && got.ID != post.ID {
+				t.Errorf("writePost returned post %d, want %d", got.ID, post.ID)
+			}
+		})
+	}
+}
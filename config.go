@@ -0,0 +1,596 @@
+package main
+
+import (
+	poststore "SimpleRest/store"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Every setting is a flag, an environment variable and a key of the config
+// file named by -config. A flag given on the command line wins over the
+// environment, which wins over the config file, which wins over the
+// default. The settings marked secret can also be read from a file named
+// by a setting of their own with a -file suffix, e.g. -admin-key-file or
+// ADMIN_KEY_FILE.
+//
+// The config file holds either a JSON object or lines of
+//
+//	# comment
+//	addr = "0.0.0.0:8080"
+//	db-max-conns = 20
+//
+// Keys are flag names, with - or _ between words.
+
+// config is the configuration of the server.
+type config struct {
+	Addr               string
+	MetricsAddr        string
+	TraceFile          string
+	TraceURL           string
+	LogLevel           string
+	Store              string
+	PG                 poststore.PgConfig
+	AutoMigrate        bool
+	DBWait             time.Duration
+	RequireIfMatch     bool
+	PageSize           int
+	MaxPageSize        int
+	FeedSize           int
+	CalendarSize       int
+	BaseURL            string
+	CORSOrigins        string
+	RateLimit          int
+	RateBurst          int
+	EventReplay        int
+	WebhookTimeout     time.Duration
+	WebhookMaxAttempts int
+	TrashRetention     time.Duration
+	AdminKey           string
+	JWTSecret          string
+	JWTPublicKey       string
+	JWTIssuer          string
+	JWTAudience        string
+	ReadTimeout        time.Duration
+	WriteTimeout       time.Duration
+	IdleTimeout        time.Duration
+	ShutdownDelay      time.Duration
+	ShutdownTimeout    time.Duration
+	File               string
+
+	// settings are in the order they were defined in.
+	settings []*setting
+	// args are the arguments left after the flags.
+	args []string
+}
+
+// setting is where one flag gets its value from.
+type setting struct {
+	flag *flag.Flag
+	env  string
+	// secret settings are redacted when printed and have a file setting,
+	// which names the file to read them from.
+	secret bool
+	file   *setting
+	isFile bool
+	// reload marks the settings a SIGHUP applies to the running server.
+	reload bool
+	// source is where the value came from, e.g. env PAGE_SIZE.
+	source string
+	rank   int
+}
+
+// ranks of the sources, a setting takes the value of the highest one.
+const (
+	fromDefault = iota
+	fromFile
+	fromEnv
+	fromFlag
+)
+
+// setting options
+const (
+	secret = 1 << iota
+	reloadable
+)
+
+// defineConfig defines the flags of a config on fs.
+func defineConfig(fs *flag.FlagSet) *config {
+	c := &config{}
+	fs.StringVar(&c.File, "config", "", "config file, JSON or key = value lines")
+	c.define(fs, "config", "CONFIG_FILE", 0)
+	fs.StringVar(&c.Addr, "addr", "localhost:8080", "address to listen on")
+	c.define(fs, "addr", "ADDR", 0)
+	fs.StringVar(&c.MetricsAddr, "metrics-addr", "", "separate address serving /metrics without credentials, /metrics is admin-only on -addr when empty")
+	c.define(fs, "metrics-addr", "METRICS_ADDR", 0)
+	fs.StringVar(&c.TraceFile, "trace-file", "", "file to append spans to as JSON lines, - for stdout")
+	c.define(fs, "trace-file", "TRACE_FILE", 0)
+	fs.StringVar(&c.TraceURL, "trace-otlp-url", "", "OTLP/HTTP traces endpoint to export spans to, e.g. http://localhost:4318/v1/traces")
+	c.define(fs, "trace-otlp-url", "TRACE_OTLP_URL", 0)
+	fs.StringVar(&c.LogLevel, "log-level", "info", "least severe log level: debug, info, warn or error; can be changed at /admin/log-level")
+	c.define(fs, "log-level", "LOG_LEVEL", reloadable)
+	fs.StringVar(&c.Store, "store", "postgres", "storage backend: memory or postgres")
+	c.define(fs, "store", "POST_STORE", 0)
+	fs.StringVar(&c.PG.DSN, "dsn", "", "Postgres connection string, PG* variables and PGPASSFILE are used when empty")
+	c.define(fs, "dsn", "DATABASE_URL", secret)
+	fs.IntVar(&c.PG.MaxConnections, "db-max-conns", 10, "Postgres pool size")
+	c.define(fs, "db-max-conns", "DB_MAX_CONNS", 0)
+	fs.DurationVar(&c.PG.AcquireTimeout, "db-acquire-timeout", 5*time.Second, "max wait for a pooled connection, 0 waits forever")
+	c.define(fs, "db-acquire-timeout", "DB_ACQUIRE_TIMEOUT", 0)
+	fs.BoolVar(&c.PG.StatementCache, "db-statement-cache", true, "prepare statements once per pooled connection")
+	c.define(fs, "db-statement-cache", "DB_STATEMENT_CACHE", 0)
+	fs.BoolVar(&c.PG.SimpleProtocol, "db-simple-protocol", false, "disable prepared statements, for PgBouncer")
+	c.define(fs, "db-simple-protocol", "DB_SIMPLE_PROTOCOL", 0)
+	fs.BoolVar(&c.PG.Listen, "db-listen", true, "LISTEN for the changes of every process sharing the database, needs a session connection")
+	c.define(fs, "db-listen", "DB_LISTEN", 0)
+	fs.BoolVar(&c.AutoMigrate, "auto-migrate", false, "apply pending Postgres migrations at startup")
+	c.define(fs, "auto-migrate", "AUTO_MIGRATE", 0)
+	fs.DurationVar(&c.DBWait, "db-wait", time.Minute, "how long to wait at startup for the database to accept connections")
+	c.define(fs, "db-wait", "DB_WAIT", 0)
+	fs.BoolVar(&c.RequireIfMatch, "require-if-match", false, "reject PUT, PATCH and DELETE of a post without If-Match")
+	c.define(fs, "require-if-match", "REQUIRE_IF_MATCH", reloadable)
+	fs.IntVar(&c.PageSize, "page-size", 50, "default limit of list endpoints")
+	c.define(fs, "page-size", "PAGE_SIZE", reloadable)
+	fs.IntVar(&c.MaxPageSize, "max-page-size", 500, "largest limit a client may ask for")
+	c.define(fs, "max-page-size", "MAX_PAGE_SIZE", reloadable)
+	fs.IntVar(&c.FeedSize, "feed-size", 20, "entries in Atom and JSON feeds")
+	c.define(fs, "feed-size", "FEED_SIZE", reloadable)
+	fs.IntVar(&c.CalendarSize, "calendar-size", 500, "most events in a calendar feed")
+	c.define(fs, "calendar-size", "CALENDAR_SIZE", reloadable)
+	fs.StringVar(&c.BaseURL, "base-url", "", "public URL of the service for absolute links, the request host when empty")
+	c.define(fs, "base-url", "BASE_URL", reloadable)
+	fs.StringVar(&c.CORSOrigins, "cors-origins", "", "comma separated origins allowed to call the API from a browser, * for any")
+	c.define(fs, "cors-origins", "CORS_ORIGINS", reloadable)
+	fs.IntVar(&c.RateLimit, "rate-limit", 0, "requests a minute each client address may make, 0 for no limit")
+	c.define(fs, "rate-limit", "RATE_LIMIT", reloadable)
+	fs.IntVar(&c.RateBurst, "rate-burst", 20, "requests a client address may make at once within the rate limit")
+	c.define(fs, "rate-burst", "RATE_BURST", reloadable)
+	fs.IntVar(&c.EventReplay, "event-replay", 1000, "events kept for /events clients resuming with Last-Event-ID")
+	c.define(fs, "event-replay", "EVENT_REPLAY", 0)
+	fs.DurationVar(&c.WebhookTimeout, "webhook-timeout", 10*time.Second, "time limit of one webhook delivery attempt")
+	c.define(fs, "webhook-timeout", "WEBHOOK_TIMEOUT", 0)
+	fs.IntVar(&c.WebhookMaxAttempts, "webhook-max-attempts", 8, "attempts before a webhook delivery is a dead letter")
+	c.define(fs, "webhook-max-attempts", "WEBHOOK_MAX_ATTEMPTS", 0)
+	fs.DurationVar(&c.TrashRetention, "trash-retention", 30*24*time.Hour, "time deleted posts stay in the trash before they are purged, 0 keeps them")
+	c.define(fs, "trash-retention", "TRASH_RETENTION", 0)
+	fs.StringVar(&c.AdminKey, "admin-key", "", "static API key with admin rights, to create the first keys")
+	c.define(fs, "admin-key", "ADMIN_KEY", secret)
+	fs.StringVar(&c.JWTSecret, "jwt-secret", "", "HMAC secret verifying HS256 bearer tokens")
+	c.define(fs, "jwt-secret", "JWT_SECRET", secret)
+	fs.StringVar(&c.JWTPublicKey, "jwt-public-key", "", "PEM file of the Ed25519 public key verifying EdDSA bearer tokens")
+	c.define(fs, "jwt-public-key", "JWT_PUBLIC_KEY", 0)
+	fs.StringVar(&c.JWTIssuer, "jwt-issuer", "", "required iss claim of bearer tokens")
+	c.define(fs, "jwt-issuer", "JWT_ISSUER", 0)
+	fs.StringVar(&c.JWTAudience, "jwt-audience", "", "required aud claim of bearer tokens")
+	c.define(fs, "jwt-audience", "JWT_AUDIENCE", 0)
+	fs.DurationVar(&c.ReadTimeout, "read-timeout", 15*time.Second, "time limit to read a request, headers and body")
+	c.define(fs, "read-timeout", "READ_TIMEOUT", 0)
+	fs.DurationVar(&c.WriteTimeout, "write-timeout", 30*time.Second, "time limit to write a response, event streams excepted")
+	c.define(fs, "write-timeout", "WRITE_TIMEOUT", 0)
+	fs.DurationVar(&c.IdleTimeout, "idle-timeout", 2*time.Minute, "time an idle keep-alive connection is kept open")
+	c.define(fs, "idle-timeout", "IDLE_TIMEOUT", 0)
+	fs.DurationVar(&c.ShutdownDelay, "shutdown-delay", 0, "time between failing /readyz and closing the listener on SIGTERM, for load balancers to notice")
+	c.define(fs, "shutdown-delay", "SHUTDOWN_DELAY", 0)
+	fs.DurationVar(&c.ShutdownTimeout, "shutdown-timeout", 25*time.Second, "time in-flight requests get to finish on SIGTERM before their connections are closed")
+	c.define(fs, "shutdown-timeout", "SHUTDOWN_TIMEOUT", 0)
+	return c
+}
+
+// define records where the flag name gets its value from. A secret gets a
+// name-file flag too.
+func (c *config) define(fs *flag.FlagSet, name, env string, opts int) {
+	s := &setting{flag: fs.Lookup(name), env: env, secret: opts&secret != 0, reload: opts&reloadable != 0}
+	if s.reload {
+		s.flag.Usage += ", reloaded on SIGHUP"
+	}
+	s.flag.Usage += fmt.Sprintf(" (env %s)", env)
+	c.settings = append(c.settings, s)
+	if s.secret {
+		fs.String(name+"-file", "", fmt.Sprintf("file holding -%s (env %s_FILE)", name, env))
+		s.file = &setting{flag: fs.Lookup(name + "-file"), env: env + "_FILE", reload: s.reload, isFile: true}
+		c.settings = append(c.settings, s.file)
+	}
+}
+
+func (c *config) lookup(name string) *setting {
+	for _, s := range c.settings {
+		if s.flag.Name == name {
+			return s
+		}
+	}
+	return nil
+}
+
+// configErrors are all that is wrong with a configuration.
+type configErrors []string
+
+func (e configErrors) Error() string {
+	return strings.Join(e, "; ")
+}
+
+func (e *configErrors) add(format string, args ...interface{}) {
+	*e = append(*e, fmt.Sprintf(format, args...))
+}
+
+// usage is the usage message of the command.
+func usage(fs *flag.FlagSet) func() {
+	return func() {
+		fmt.Fprintf(fs.Output(), "usage: %s [flags]\n       %s [flags] migrate up|down [n]|status\n       %s [flags] config print\n", os.Args[0], os.Args[0], os.Args[0])
+		fs.PrintDefaults()
+	}
+}
+
+// loadConfig resolves the configuration given by args, the environment
+// looked up with env and the config file. Errors parsing args are returned
+// as they are, after the usage message; the others as configErrors.
+func loadConfig(args []string, env func(string) (string, bool)) (*config, error) {
+	fs := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	c := defineConfig(fs)
+	fs.Usage = usage(fs)
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	c.args = fs.Args()
+	given := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { given[f.Name] = true })
+
+	var errs configErrors
+	for _, s := range c.settings {
+		s.source, s.rank = "default", fromDefault
+		if given[s.flag.Name] {
+			s.source, s.rank = "flag -"+s.flag.Name, fromFlag
+		} else if v, ok := env(s.env); ok {
+			s.source, s.rank = "env "+s.env, fromEnv
+			if err := s.flag.Value.Set(v); err != nil {
+				errs.add("%s: bad value %q: %v", s.source, v, err)
+			}
+		}
+	}
+	c.readFile(&errs)
+	for _, s := range c.settings {
+		if s.file != nil {
+			c.readSecret(s, &errs)
+		}
+	}
+	if len(errs) == 0 {
+		c.validate(&errs)
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return c, nil
+}
+
+// readFile sets the settings that the config file has and neither a flag
+// nor the environment do.
+func (c *config) readFile(errs *configErrors) {
+	if c.File == "" {
+		return
+	}
+	data, err := ioutil.ReadFile(c.File)
+	if err != nil {
+		errs.add("cannot read config file: %v", err)
+		return
+	}
+	var entries []configEntry
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '{' {
+		entries, err = parseJSONConfig(data)
+		if err != nil {
+			errs.add("%s: %v", c.File, err)
+			return
+		}
+	} else {
+		entries = parseConfigLines(c.File, data, errs)
+	}
+	seen := map[string]string{}
+	for _, e := range entries {
+		where := c.File
+		if e.line > 0 {
+			where = fmt.Sprintf("%s:%d", c.File, e.line)
+		}
+		name := strings.Replace(e.key, "_", "-", -1)
+		s := c.lookup(name)
+		switch {
+		case s == nil:
+			errs.add("%s: unknown setting %q%s", where, e.key, c.suggest(name))
+			continue
+		case name == "config":
+			errs.add("%s: config cannot name another config file", where)
+			continue
+		case seen[name] != "":
+			errs.add("%s: %s is already set at %s", where, name, seen[name])
+			continue
+		}
+		seen[name] = where
+		if s.rank > fromFile {
+			continue
+		}
+		s.source, s.rank = "config "+where, fromFile
+		if err := s.flag.Value.Set(e.value); err != nil {
+			errs.add("%s: bad value %q for %s: %v", where, e.value, name, err)
+		}
+	}
+}
+
+// suggest names the setting a mistyped name probably meant.
+func (c *config) suggest(name string) string {
+	squash := func(s string) string { return strings.Replace(s, "-", "", -1) }
+	for _, s := range c.settings {
+		if squash(s.flag.Name) == squash(name) || strings.Contains(s.flag.Name, name) || strings.Contains(name, s.flag.Name) {
+			return fmt.Sprintf(", did you mean %s?", s.flag.Name)
+		}
+	}
+	return ""
+}
+
+// readSecret sets the secret s from the file its file setting names, when
+// that comes from a higher source than s itself.
+func (c *config) readSecret(s *setting, errs *configErrors) {
+	f := s.file
+	switch {
+	case f.rank == fromDefault || f.rank < s.rank:
+		return
+	case f.rank == s.rank:
+		errs.add("%s and %s both set %s, keep one", s.source, f.source, s.flag.Name)
+		return
+	}
+	data, err := ioutil.ReadFile(f.flag.Value.String())
+	if err != nil {
+		errs.add("%s: cannot read %s: %v", f.source, s.flag.Name, err)
+		return
+	}
+	s.flag.Value.Set(strings.TrimRight(string(data), "\r\n"))
+	s.source, s.rank = f.source, f.rank
+}
+
+// configEntry is one key and value of a config file, with the line it is
+// on when the file is not JSON.
+type configEntry struct {
+	key, value string
+	line       int
+}
+
+func parseJSONConfig(data []byte) ([]configEntry, error) {
+	var obj map[string]interface{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&obj); err != nil {
+		return nil, err
+	}
+	var entries []configEntry
+	for key, v := range obj {
+		switch v := v.(type) {
+		case string:
+			entries = append(entries, configEntry{key: key, value: v})
+		case json.Number:
+			entries = append(entries, configEntry{key: key, value: v.String()})
+		case bool:
+			entries = append(entries, configEntry{key: key, value: strconv.FormatBool(v)})
+		default:
+			return nil, fmt.Errorf("%s must be a string, number or boolean", key)
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].key < entries[j].key })
+	return entries, nil
+}
+
+// parseConfigLines reads key = value lines. Values are bare up to a
+// comment, or quoted: "..." with Go escapes, '...' as they are.
+func parseConfigLines(path string, data []byte, errs *configErrors) []configEntry {
+	var entries []configEntry
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		key := strings.TrimSpace(line)
+		eq := strings.IndexByte(line, '=')
+		if eq >= 0 {
+			key = strings.TrimSpace(line[:eq])
+		}
+		if strings.HasPrefix(key, "[") {
+			errs.add("%s:%d: sections are not supported", path, i+1)
+			continue
+		}
+		if eq <= 0 || key == "" {
+			errs.add("%s:%d: want key = value", path, i+1)
+			continue
+		}
+		value, err := parseConfigValue(strings.TrimSpace(line[eq+1:]))
+		if err != nil {
+			errs.add("%s:%d: %v", path, i+1, err)
+			continue
+		}
+		entries = append(entries, configEntry{key: key, value: value, line: i + 1})
+	}
+	return entries
+}
+
+func parseConfigValue(v string) (string, error) {
+	if v == "" || (v[0] != '"' && v[0] != '\'') {
+		if i := strings.Index(v, "#"); i >= 0 {
+			v = v[:i]
+		}
+		return strings.TrimSpace(v), nil
+	}
+	end := -1
+	for i := 1; i < len(v); i++ {
+		if v[0] == '"' && v[i] == '\\' {
+			i++
+			continue
+		}
+		if v[i] == v[0] {
+			end = i
+			break
+		}
+	}
+	if end < 0 {
+		return "", fmt.Errorf("unterminated string %s", v)
+	}
+	if rest := strings.TrimSpace(v[end+1:]); rest != "" && rest[0] != '#' {
+		return "", fmt.Errorf("unexpected %q after string", rest)
+	}
+	if v[0] == '\'' {
+		return v[1:end], nil
+	}
+	s, err := strconv.Unquote(v[:end+1])
+	if err != nil {
+		return "", fmt.Errorf("bad string %s", v[:end+1])
+	}
+	return s, nil
+}
+
+// where names a setting and where its value came from, for errors.
+func (c *config) where(name string) string {
+	if s := c.lookup(name); s != nil && s.rank != fromDefault {
+		return fmt.Sprintf("%s (%s)", name, s.source)
+	}
+	return name
+}
+
+func (c *config) validate(errs *configErrors) {
+	if _, err := parseLevel(c.LogLevel); err != nil {
+		errs.add("%s: %v", c.where("log-level"), err)
+	}
+	if c.Store != "memory" && c.Store != "postgres" {
+		errs.add("%s: unknown store %q, expect memory or postgres", c.where("store"), c.Store)
+	}
+	if c.TraceFile != "" && c.TraceURL != "" {
+		errs.add("%s and %s are exclusive", c.where("trace-file"), c.where("trace-otlp-url"))
+	}
+	if c.PageSize <= 0 || c.MaxPageSize < c.PageSize {
+		errs.add("%s must be positive and no larger than %s", c.where("page-size"), c.where("max-page-size"))
+	}
+	if c.FeedSize <= 0 {
+		errs.add("%s must be positive", c.where("feed-size"))
+	}
+	if c.CalendarSize <= 0 {
+		errs.add("%s must be positive", c.where("calendar-size"))
+	}
+	for _, o := range parseOrigins(c.CORSOrigins) {
+		if u, err := url.Parse(o); o != "*" && (err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.Path != "" || u.RawQuery != "") {
+			errs.add("%s: %q is not an origin, expect scheme://host[:port] or *", c.where("cors-origins"), o)
+		}
+	}
+	if c.RateLimit < 0 {
+		errs.add("%s must not be negative", c.where("rate-limit"))
+	}
+	if c.RateBurst <= 0 {
+		errs.add("%s must be positive", c.where("rate-burst"))
+	}
+	if c.EventReplay < 0 {
+		errs.add("%s must not be negative", c.where("event-replay"))
+	}
+	if c.WebhookTimeout <= 0 {
+		errs.add("%s must be positive", c.where("webhook-timeout"))
+	}
+	if c.WebhookMaxAttempts <= 0 {
+		errs.add("%s must be positive", c.where("webhook-max-attempts"))
+	}
+	if c.PG.MaxConnections < 2 {
+		errs.add("%s must be at least 2", c.where("db-max-conns"))
+	}
+	for _, name := range []string{"db-acquire-timeout", "db-wait", "read-timeout", "write-timeout", "idle-timeout", "shutdown-delay", "trash-retention"} {
+		if c.lookup(name).flag.Value.(flag.Getter).Get().(time.Duration) < 0 {
+			errs.add("%s must not be negative", c.where(name))
+		}
+	}
+	if c.ShutdownTimeout <= 0 {
+		errs.add("%s must be positive", c.where("shutdown-timeout"))
+	}
+}
+
+// options are the settings of the handlers.
+func (c *config) options() options {
+	return options{
+		requireIfMatch: c.RequireIfMatch,
+		pageSize:       c.PageSize,
+		maxPageSize:    c.MaxPageSize,
+		feedSize:       c.FeedSize,
+		calendarSize:   c.CalendarSize,
+		baseURL:        c.BaseURL,
+		corsOrigins:    parseOrigins(c.CORSOrigins),
+		rateLimit:      c.RateLimit,
+		rateBurst:      c.RateBurst,
+	}
+}
+
+// reload takes the reloadable settings of next. It returns the names of
+// those that changed, and of the others that changed and need a restart.
+func (c *config) reload(next *config) (changed, restart []string) {
+	for i, s := range c.settings {
+		n := next.settings[i]
+		if n.flag.Value.String() == s.flag.Value.String() {
+			continue
+		}
+		if !s.reload {
+			restart = append(restart, s.flag.Name)
+			continue
+		}
+		s.flag.Value.Set(n.flag.Value.String())
+		s.source, s.rank = n.source, n.rank
+		changed = append(changed, s.flag.Name)
+	}
+	return changed, restart
+}
+
+// reloadConfig loads the configuration again, on SIGHUP, and applies the
+// reloadable settings to the running server. The environment of a process
+// does not change, so in practice this rereads the config file and the
+// files secrets are read from.
+func reloadConfig(cfg *config, ps *postStore) {
+	next, err := loadConfig(os.Args[1:], os.LookupEnv)
+	if err != nil {
+		logs.Error("config reload failed, keeping the running configuration", "error", err)
+		return
+	}
+	changed, restart := cfg.reload(next)
+	if len(restart) > 0 {
+		logs.Warn("config changes need a restart", "settings", restart)
+	}
+	if level, _ := parseLevel(cfg.LogLevel); contains(changed, "log-level") {
+		logs.SetLevel(level)
+	}
+	ps.setOptions(cfg.options())
+	logs.Info("config reloaded", "changed", changed)
+}
+
+func contains(list []string, s string) bool {
+	for _, x := range list {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
+
+// print writes the settings in the config file format, with the source of
+// each and secrets redacted. File settings of secrets are left out unless
+// they are used.
+func (c *config) print(w io.Writer) {
+	for _, s := range c.settings {
+		if s.flag.Name == "config" || (s.isFile && s.rank == fromDefault) {
+			continue
+		}
+		var v string
+		switch x := s.flag.Value.(flag.Getter).Get().(type) {
+		case bool, int:
+			v = fmt.Sprint(x)
+		default:
+			v = strconv.Quote(s.flag.Value.String())
+			if s.secret && s.flag.Value.String() != "" {
+				v = `"[redacted]"`
+			}
+		}
+		fmt.Fprintf(w, "%s = %s # %s\n", s.flag.Name, v, s.source)
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// configDir writes files, named by their keys, to a new directory and
+// returns its path, for the caller to remove.
+func configDir(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, data := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(data), 0600); err != nil {
+			os.RemoveAll(dir)
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestLoadConfig(t *testing.T) {
+	dir := configDir(t, map[string]string{
+		"lines.conf":   "# comment\npage-size = 60 # trailing comment\nadmin_key = 'file key'\n",
+		"json.conf":    `{"page_size": 60, "require-if-match": true, "base-url": "https://posts.example"}`,
+		"admin-key":    "secret key\n",
+		"unknown.conf": "pagesize = 60\n",
+		"twice.conf":   "page-size = 60\npage_size = 70\n",
+		"bad.conf":     "[server]\npage-size\nbase-url = \"open\n",
+	})
+	defer os.RemoveAll(dir)
+	path := func(name string) string { return filepath.Join(dir, name) }
+
+	tests := []struct {
+		name string
+		args []string
+		env  map[string]string
+		// want is checked against the page size, the admin key and where
+		// they came from, unless errs lists the errors expected
+		pageSize       int
+		pageSizeSource string
+		adminKey       string
+		adminKeySource string
+		errs           []string
+	}{
+		{"defaults", nil, nil, 50, "default", "", "default", nil},
+		{"file", []string{"-config", path("lines.conf")}, nil, 60, "config " + path("lines.conf") + ":2", "file key", "config " + path("lines.conf") + ":3", nil},
+		{"json file", []string{"-config", path("json.conf")}, nil, 60, "config " + path("json.conf"), "", "default", nil},
+		{"config file from env", nil, map[string]string{"CONFIG_FILE": path("lines.conf")}, 60, "config " + path("lines.conf") + ":2", "file key", "config " + path("lines.conf") + ":3", nil},
+		{"env over file", []string{"-config", path("lines.conf")}, map[string]string{"PAGE_SIZE": "70", "ADMIN_KEY": "env key"}, 70, "env PAGE_SIZE", "env key", "env ADMIN_KEY", nil},
+		{"flag over env", []string{"-page-size", "80", "-admin-key", "flag key"}, map[string]string{"PAGE_SIZE": "70", "ADMIN_KEY": "env key"}, 80, "flag -page-size", "flag key", "flag -admin-key", nil},
+
+		// secrets
+		{"secret file", nil, map[string]string{"ADMIN_KEY_FILE": path("admin-key")}, 50, "default", "secret key", "env ADMIN_KEY_FILE", nil},
+		{"secret file over a lower source", []string{"-config", path("lines.conf"), "-admin-key-file", path("admin-key")}, nil, 60, "config " + path("lines.conf") + ":2", "secret key", "flag -admin-key-file", nil},
+		{"secret over a lower file", []string{"-admin-key", "flag key"}, map[string]string{"ADMIN_KEY_FILE": path("admin-key")}, 50, "default", "flag key", "flag -admin-key", nil},
+		{"secret and its file", nil, map[string]string{"ADMIN_KEY": "env key", "ADMIN_KEY_FILE": path("admin-key")}, 0, "", "", "", []string{"env ADMIN_KEY and env ADMIN_KEY_FILE both set admin-key, keep one"}},
+		{"missing secret file", nil, map[string]string{"ADMIN_KEY_FILE": path("missing")}, 0, "", "", "", []string{"env ADMIN_KEY_FILE: cannot read admin-key:"}},
+
+		// validation
+		{"bad env value", nil, map[string]string{"PAGE_SIZE": "many"}, 0, "", "", "", []string{`env PAGE_SIZE: bad value "many"`}},
+		{"page size over the max", []string{"-page-size", "600"}, nil, 0, "", "", "", []string{"page-size (flag -page-size) must be positive and no larger than max-page-size"}},
+		{"every error", []string{"-store", "disk", "-rate-limit", "-1", "-cors-origins", "https://app.example, app.example"}, map[string]string{"LOG_LEVEL": "loud", "DB_MAX_CONNS": "1", "IDLE_TIMEOUT": "-1s"}, 0, "", "", "", []string{
+			`log-level (env LOG_LEVEL): `,
+			`store (flag -store): unknown store "disk", expect memory or postgres`,
+			`cors-origins (flag -cors-origins): "app.example" is not an origin, expect scheme://host[:port] or *`,
+			`rate-limit (flag -rate-limit) must not be negative`,
+			`db-max-conns (env DB_MAX_CONNS) must be at least 2`,
+			`idle-timeout (env IDLE_TIMEOUT) must not be negative`,
+		}},
+		{"unknown key", []string{"-config", path("unknown.conf")}, nil, 0, "", "", "", []string{path("unknown.conf") + `:1: unknown setting "pagesize", did you mean page-size?`}},
+		{"key set twice", []string{"-config", path("twice.conf")}, nil, 0, "", "", "", []string{path("twice.conf") + ":2: page-size is already set at " + path("twice.conf") + ":1"}},
+		{"bad lines", []string{"-config", path("bad.conf")}, nil, 0, "", "", "", []string{
+			path("bad.conf") + ":1: sections are not supported",
+			path("bad.conf") + ":2: want key = value",
+			path("bad.conf") + `:3: unterminated string "open`,
+		}},
+		{"missing file", []string{"-config", path("missing.conf")}, nil, 0, "", "", "", []string{"cannot read config file:"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := func(name string) (string, bool) {
+				v, ok := tt.env[name]
+				return v, ok
+			}
+			c, err := loadConfig(tt.args, env)
+			if tt.errs != nil {
+				errs, ok := err.(configErrors)
+				if !ok {
+					t.Fatalf("loadConfig = %v, want configErrors", err)
+				}
+				if len(errs) != len(tt.errs) {
+					t.Errorf("loadConfig has %d errors, want %d: %v", len(errs), len(tt.errs), err)
+				}
+				for _, want := range tt.errs {
+					if !strings.Contains(err.Error(), want) {
+						t.Errorf("loadConfig = %v, want %q among the errors", err, want)
+					}
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if c.PageSize != tt.pageSize || c.lookup("page-size").source != tt.pageSizeSource {
+				t.Errorf("page size %d from %s, want %d from %s", c.PageSize, c.lookup("page-size").source, tt.pageSize, tt.pageSizeSource)
+			}
+			if c.AdminKey != tt.adminKey || c.lookup("admin-key").source != tt.adminKeySource {
+				t.Errorf("admin key %q from %s, want %q from %s", c.AdminKey, c.lookup("admin-key").source, tt.adminKey, tt.adminKeySource)
+			}
+		})
+	}
+}
+
+func TestPrintConfig(t *testing.T) {
+	dir := configDir(t, map[string]string{"dsn": "postgres://u:p@db/posts\n"})
+	defer os.RemoveAll(dir)
+	env := map[string]string{"DATABASE_URL_FILE": filepath.Join(dir, "dsn"), "JWT_SECRET": "hmac secret"}
+	c, err := loadConfig([]string{"-page-size", "80", "-base-url", "https://posts.example"}, func(name string) (string, bool) {
+		v, ok := env[name]
+		return v, ok
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	c.print(&buf)
+	out := buf.String()
+
+	for _, want := range []string{
+		"page-size = 80 # flag -page-size\n",
+		"base-url = \"https://posts.example\" # flag -base-url\n",
+		"dsn = \"[redacted]\" # env DATABASE_URL_FILE\n",
+		"dsn-file = \"" + filepath.Join(dir, "dsn") + "\" # env DATABASE_URL_FILE\n",
+		"jwt-secret = \"[redacted]\" # env JWT_SECRET\n",
+		// an empty secret shows it is not set
+		"admin-key = \"\" # default\n",
+		"db-listen = true # default\n",
+		"rate-limit = 0 # default\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("config print has no line %q:\n%s", want, out)
+		}
+	}
+	for _, secret := range []string{"u:p@db", "hmac secret"} {
+		if strings.Contains(out, secret) {
+			t.Errorf("config print shows %q:\n%s", secret, out)
+		}
+	}
+	// file settings of secrets are only printed when they are used
+	if strings.Contains(out, "admin-key-file") || strings.Contains(out, "\nconfig =") {
+		t.Errorf("config print has unused settings:\n%s", out)
+	}
+}
+
+func TestReloadConfig(t *testing.T) {
+	load := func(args ...string) *config {
+		c, err := loadConfig(args, func(string) (string, bool) { return "", false })
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+	c := load("-rate-limit", "60")
+	changed, restart := c.reload(load("-rate-limit", "120", "-cors-origins", "*", "-addr", ":9090"))
+	if want := []string{"cors-origins", "rate-limit"}; !reflect.DeepEqual(changed, want) {
+		t.Errorf("reload changed %v, want %v", changed, want)
+	}
+	if want := []string{"addr"}; !reflect.DeepEqual(restart, want) {
+		t.Errorf("reload needs a restart for %v, want %v", restart, want)
+	}
+	o := c.options()
+	if o.rateLimit != 120 || !reflect.DeepEqual(o.corsOrigins, []string{"*"}) || c.Addr != "localhost:8080" {
+		t.Errorf("reloaded options are %+v and addr %s", o, c.Addr)
+	}
+}
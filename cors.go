@@ -0,0 +1,61 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// The methods and request headers a browser may send cross-origin, and
+// the response headers its scripts may read.
+const (
+	corsMethods       = "GET, POST, PUT, PATCH, DELETE"
+	corsHeaders       = "Authorization, Content-Type, If-Match, If-None-Match, Last-Event-ID, X-API-Key, traceparent"
+	corsExposeHeaders = "ETag, Link, Retry-After, X-Total-Count"
+)
+
+// cors lets the pages of the allowed origins call the API from a browser.
+// It answers preflight requests itself, before they reach the router,
+// which has no OPTIONS routes and would require credentials.
+func (ps *postStore) cors(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		origin := req.Header.Get("Origin")
+		allowed := ps.options().corsOrigins
+		if origin == "" || len(allowed) == 0 {
+			next.ServeHTTP(w, req)
+			return
+		}
+		h := w.Header()
+		h.Add("Vary", "Origin")
+		switch {
+		case contains(allowed, "*"):
+			h.Set("Access-Control-Allow-Origin", "*")
+		case contains(allowed, origin):
+			h.Set("Access-Control-Allow-Origin", origin)
+		default:
+			// the browser keeps the response from the page
+			next.ServeHTTP(w, req)
+			return
+		}
+
+		if req.Method == "OPTIONS" && req.Header.Get("Access-Control-Request-Method") != "" {
+			h.Set("Access-Control-Allow-Methods", corsMethods)
+			h.Set("Access-Control-Allow-Headers", corsHeaders)
+			h.Set("Access-Control-Max-Age", "600")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		h.Set("Access-Control-Expose-Headers", corsExposeHeaders)
+		next.ServeHTTP(w, req)
+	})
+}
+
+// parseOrigins splits the comma separated origins of -cors-origins.
+func parseOrigins(s string) []string {
+	var origins []string
+	for _, o := range strings.Split(s, ",") {
+		if o = strings.TrimSpace(o); o != "" {
+			origins = append(origins, strings.TrimSuffix(o, "/"))
+		}
+	}
+	return origins
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	poststore "SimpleRest/store"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCORS(t *testing.T) {
+	ps := NewPostServer(poststore.New())
+	h := ps.cors(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	}))
+	tests := []struct {
+		name    string
+		origins []string
+		method  string
+		origin  string
+		status  int
+		allow   string
+	}{
+		{"no origins", nil, "GET", "https://app.example", http.StatusTeapot, ""},
+		{"same origin", []string{"https://app.example"}, "GET", "", http.StatusTeapot, ""},
+		{"allowed", []string{"https://app.example"}, "GET", "https://app.example", http.StatusTeapot, "https://app.example"},
+		{"other origin", []string{"https://app.example"}, "GET", "https://evil.example", http.StatusTeapot, ""},
+		{"any", []string{"*"}, "GET", "https://evil.example", http.StatusTeapot, "*"},
+		{"preflight", []string{"https://app.example"}, "OPTIONS", "https://app.example", http.StatusNoContent, "https://app.example"},
+		{"preflight of another origin", []string{"https://app.example"}, "OPTIONS", "https://evil.example", http.StatusTeapot, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ps.setOptions(options{corsOrigins: tt.origins})
+			req := httptest.NewRequest(tt.method, "/post/", nil)
+			if tt.origin != "" {
+				req.Header.Set("Origin", tt.origin)
+			}
+			if tt.method == "OPTIONS" {
+				req.Header.Set("Access-Control-Request-Method", "PATCH")
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+			if w.Code != tt.status {
+				t.Errorf("status %d, want %d", w.Code, tt.status)
+			}
+			if got := w.Header().Get("Access-Control-Allow-Origin"); got != tt.allow {
+				t.Errorf("allowed origin %q, want %q", got, tt.allow)
+			}
+		})
+	}
+}
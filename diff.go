@@ -0,0 +1,167 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Operations of a diffOp.
+const (
+	diffEqual  = "equal"
+	diffDelete = "delete"
+	diffInsert = "insert"
+)
+
+// maxDiffEdits bounds the edits diff looks for, and so its memory, which
+// grows with their square. Texts further apart are one replacement.
+const maxDiffEdits = 1000
+
+// diffOp is a run of text both sides of a diff share, or that only the old
+// side (delete) or only the new side (insert) has.
+type diffOp struct {
+	Op   string `json:"op"`
+	Text string `json:"text"`
+}
+
+// splitLines splits s into its lines, each with its newline.
+func splitLines(s string) []string {
+	return strings.SplitAfter(s, "\n")
+}
+
+// splitWords splits s into its words and the spaces between them.
+func splitWords(s string) []string {
+	var tokens []string
+	start := 0
+	for i, r := range s {
+		if i > start && unicode.IsSpace(r) != isSpaceAt(s, start) {
+			tokens = append(tokens, s[start:i])
+			start = i
+		}
+	}
+	if start < len(s) {
+		tokens = append(tokens, s[start:])
+	}
+	return tokens
+}
+
+func isSpaceAt(s string, i int) bool {
+	r, _ := utf8.DecodeRuneInString(s[i:])
+	return unicode.IsSpace(r)
+}
+
+// diff returns the shortest edit turning the tokens of a into those of b,
+// as the runs of each operation in turn. The joined texts of the equal and
+// delete runs give a back, of the equal and insert runs b.
+func diff(a, b []string) []diffOp {
+	// what both ends share needs no search
+	pre := 0
+	for pre < len(a) && pre < len(b) && a[pre] == b[pre] {
+		pre++
+	}
+	suf := 0
+	for suf < len(a)-pre && suf < len(b)-pre && a[len(a)-1-suf] == b[len(b)-1-suf] {
+		suf++
+	}
+	var ops []diffOp
+	add := func(op string, tokens ...string) {
+		text := strings.Join(tokens, "")
+		if text == "" {
+			return
+		}
+		if n := len(ops); n > 0 && ops[n-1].Op == op {
+			ops[n-1].Text += text
+			return
+		}
+		ops = append(ops, diffOp{Op: op, Text: text})
+	}
+	add(diffEqual, a[:pre]...)
+	middle, ok := myers(a[pre:len(a)-suf], b[pre:len(b)-suf])
+	if !ok {
+		middle = []diffOp{{diffDelete, strings.Join(a[pre:len(a)-suf], "")}, {diffInsert, strings.Join(b[pre:len(b)-suf], "")}}
+	}
+	for _, op := range middle {
+		add(op.Op, op.Text)
+	}
+	add(diffEqual, a[len(a)-suf:]...)
+	if ops == nil {
+		ops = []diffOp{}
+	}
+	return ops
+}
+
+// myers finds the shortest edit turning a into b with the O(ND) algorithm
+// of Eugene Myers, one token per op. It gives up past maxDiffEdits.
+func myers(a, b []string) ([]diffOp, bool) {
+	n, m := len(a), len(b)
+	// v holds the furthest x reached on each diagonal k = x - y, read with
+	// at; trace keeps v before each round to walk the path back
+	var trace [][]int
+	v := []int{0, 0, 0}
+	for d := 0; ; d++ {
+		if d > maxDiffEdits {
+			return nil, false
+		}
+		trace = append(trace, v)
+		next := make([]int, 2*d+3)
+		done := false
+		for k := -d; k <= d && !done; k += 2 {
+			var x int
+			if k == -d || (k != d && at(v, k-1) < at(v, k+1)) {
+				x = at(v, k+1)
+			} else {
+				x = at(v, k-1) + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			next[k+d+1] = x
+			done = x >= n && y >= m
+		}
+		v = next
+		if done {
+			break
+		}
+	}
+
+	var ops []diffOp
+	x, y := n, m
+	for d := len(trace) - 1; d >= 0; d-- {
+		v := trace[d]
+		k := x - y
+		var prevK int
+		if k == -d || (k != d && at(v, k-1) < at(v, k+1)) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := at(v, prevK)
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			x--
+			y--
+			ops = append(ops, diffOp{diffEqual, a[x]})
+		}
+		if d > 0 {
+			if x == prevX {
+				y--
+				ops = append(ops, diffOp{diffInsert, b[y]})
+			} else {
+				x--
+				ops = append(ops, diffOp{diffDelete, a[x]})
+			}
+		}
+		x, y = prevX, prevY
+	}
+	for i, j := 0, len(ops)-1; i < j; i, j = i+1, j-1 {
+		ops[i], ops[j] = ops[j], ops[i]
+	}
+	return ops, true
+}
+
+// at reads diagonal k of v, which is centered on diagonal 0.
+func at(v []int, k int) int {
+	return v[k+len(v)/2]
+}
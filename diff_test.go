@@ -0,0 +1,160 @@
+package main
+
+import (
+	"math/rand"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	tokens := func(s string) []string {
+		if s == "" {
+			return nil
+		}
+		return strings.Split(s, " ")
+	}
+	many := func(prefix string, n int) string {
+		words := make([]string, n)
+		for i := range words {
+			words[i] = prefix + strconv.Itoa(i)
+		}
+		return strings.Join(words, " ")
+	}
+	tests := []struct {
+		name string
+		a, b string
+		want []diffOp
+	}{
+		{"both empty", "", "", []diffOp{}},
+		{"same", "a b", "a b", []diffOp{{diffEqual, "ab"}}},
+		{"insert all", "", "a b", []diffOp{{diffInsert, "ab"}}},
+		{"delete all", "a b", "", []diffOp{{diffDelete, "ab"}}},
+		{"insert in the middle", "a c", "a b c", []diffOp{{diffEqual, "a"}, {diffInsert, "b"}, {diffEqual, "c"}}},
+		{"delete in the middle", "a b c", "a c", []diffOp{{diffEqual, "a"}, {diffDelete, "b"}, {diffEqual, "c"}}},
+		{"replace between prefix and suffix", "a b c", "a x y c", []diffOp{{diffEqual, "a"}, {diffDelete, "b"}, {diffInsert, "xy"}, {diffEqual, "c"}}},
+		{"nothing shared", "a b", "c d", []diffOp{{diffDelete, "ab"}, {diffInsert, "cd"}}},
+		{"moved", "a b c", "c a b", []diffOp{{diffInsert, "c"}, {diffEqual, "ab"}, {diffDelete, "c"}}},
+		{
+			"too far apart",
+			"p " + many("a", maxDiffEdits) + " s",
+			"p " + many("b", maxDiffEdits) + " s",
+			[]diffOp{
+				{diffEqual, "p"},
+				{diffDelete, strings.Replace(many("a", maxDiffEdits), " ", "", -1)},
+				{diffInsert, strings.Replace(many("b", maxDiffEdits), " ", "", -1)},
+				{diffEqual, "s"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, b := tokens(tt.a), tokens(tt.b)
+			got := diff(a, b)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("diff = %v, want %v", got, tt.want)
+			}
+			checkSides(t, a, b, got)
+		})
+	}
+}
+
+// TestDiffShortest checks diff against the longest common subsequence of
+// random texts, which the shortest edit keeps.
+func TestDiffShortest(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	random := func() []string {
+		s := make([]string, r.Intn(12))
+		for i := range s {
+			s[i] = string(rune('a' + r.Intn(3)))
+		}
+		return s
+	}
+	for i := 0; i < 500; i++ {
+		a, b := random(), random()
+		ops := diff(a, b)
+		checkSides(t, a, b, ops)
+		kept := 0
+		for _, op := range ops {
+			if op.Op == diffEqual {
+				kept += len(op.Text)
+			}
+		}
+		if want := lcs(a, b); kept != want {
+			t.Errorf("diff(%q, %q) = %v keeps %d tokens, want %d", a, b, ops, kept, want)
+		}
+	}
+}
+
+// checkSides checks that the ops give back both texts, without empty or
+// repeated runs.
+func checkSides(t *testing.T, a, b []string, ops []diffOp) {
+	t.Helper()
+	var before, after strings.Builder
+	for i, op := range ops {
+		if op.Text == "" {
+			t.Errorf("op %d of %v is empty", i, ops)
+		}
+		if i > 0 && ops[i-1].Op == op.Op {
+			t.Errorf("ops %d and %d of %v are both %s", i-1, i, ops, op.Op)
+		}
+		switch op.Op {
+		case diffEqual:
+			before.WriteString(op.Text)
+			after.WriteString(op.Text)
+		case diffDelete:
+			before.WriteString(op.Text)
+		case diffInsert:
+			after.WriteString(op.Text)
+		default:
+			t.Errorf("op %d of %v is %q", i, ops, op.Op)
+		}
+	}
+	if got, want := before.String(), strings.Join(a, ""); got != want {
+		t.Errorf("old side is %q, want %q", got, want)
+	}
+	if got, want := after.String(), strings.Join(b, ""); got != want {
+		t.Errorf("new side is %q, want %q", got, want)
+	}
+}
+
+func lcs(a, b []string) int {
+	n := make([][]int, len(a)+1)
+	for i := range n {
+		n[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			switch {
+			case a[i] == b[j]:
+				n[i][j] = n[i+1][j+1] + 1
+			case n[i+1][j] > n[i][j+1]:
+				n[i][j] = n[i+1][j]
+			default:
+				n[i][j] = n[i][j+1]
+			}
+		}
+	}
+	return n[0][0]
+}
+
+func TestSplit(t *testing.T) {
+	tests := []struct {
+		split func(string) []string
+		s     string
+		want  []string
+	}{
+		{splitLines, "", []string{""}},
+		{splitLines, "a\nb", []string{"a\n", "b"}},
+		{splitLines, "a\nb\n", []string{"a\n", "b\n", ""}},
+		{splitWords, "", nil},
+		{splitWords, "deploy  on\tFriday ", []string{"deploy", "  ", "on", "\t", "Friday", " "}},
+		{splitWords, " é ü", []string{" ", "é", " ", "ü"}},
+	}
+	for _, tt := range tests {
+		if got := tt.split(tt.s); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("split(%q) = %q, want %q", tt.s, got, tt.want)
+		}
+	}
+}
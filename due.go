@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// The due views list posts due within a calendar period, combined with the
+// filter parameters of GET /post/. Periods start at midnight in the tz time
+// zone, which is looked up in the zoneinfo of the host.
+
+func (ps *postStore) dueHandler(w http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+	year, _ := strconv.Atoi(vars["year"])
+	month, _ := strconv.Atoi(vars["month"])
+	day, _ := strconv.Atoi(vars["day"])
+	ps.listDue(w, req, func(loc *time.Location) (time.Time, time.Time, error) {
+		from := time.Date(year, time.Month(month), day, 0, 0, 0, 0, loc)
+		if from.Month() != time.Month(month) || from.Day() != day {
+			return from, from, fmt.Errorf("expect /due/<year>/<month>/<day>, got %v", req.URL.Path)
+		}
+		return from, from.AddDate(0, 0, 1), nil
+	})
+}
+
+func (ps *postStore) dueMonthHandler(w http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+	year, _ := strconv.Atoi(vars["year"])
+	month, _ := strconv.Atoi(vars["month"])
+	ps.listDue(w, req, func(loc *time.Location) (time.Time, time.Time, error) {
+		if month < int(time.January) || month > int(time.December) {
+			return time.Time{}, time.Time{}, fmt.Errorf("expect /due/<year>/<month>/, got %v", req.URL.Path)
+		}
+		from := time.Date(year, time.Month(month), 1, 0, 0, 0, 0, loc)
+		return from, from.AddDate(0, 1, 0), nil
+	})
+}
+
+// dueWeekHandler lists an ISO 8601 week, Monday to Sunday.
+func (ps *postStore) dueWeekHandler(w http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+	year, _ := strconv.Atoi(vars["year"])
+	week, _ := strconv.Atoi(vars["week"])
+	ps.listDue(w, req, func(loc *time.Location) (time.Time, time.Time, error) {
+		// January 4th is always in week 1
+		jan4 := time.Date(year, time.January, 4, 0, 0, 0, 0, loc)
+		monday := jan4.AddDate(0, 0, -(int(jan4.Weekday())+6)%7)
+		from := monday.AddDate(0, 0, 7*(week-1))
+		if y, wk := from.ISOWeek(); y != year || wk != week {
+			return from, from, fmt.Errorf("%d has no ISO week %d", year, week)
+		}
+		return from, from.AddDate(0, 0, 7), nil
+	})
+}
+
+// dueRangeHandler lists the posts due between the from and to parameters,
+// either of which may be omitted.
+func (ps *postStore) dueRangeHandler(w http.ResponseWriter, req *http.Request) {
+	q := req.URL.Query()
+	ps.listDue(w, req, func(loc *time.Location) (time.Time, time.Time, error) {
+		from, err := parseTimeParam(q, "from", loc)
+		if err != nil {
+			return from, from, err
+		}
+		to, err := parseTimeParam(q, "to", loc)
+		if err == nil && !from.IsZero() && !to.IsZero() && !from.Before(to) {
+			err = fmt.Errorf("from must be before to")
+		}
+		return from, to, err
+	})
+}
+
+// overdueHandler lists the posts whose due time has passed.
+func (ps *postStore) overdueHandler(w http.ResponseWriter, req *http.Request) {
+	ps.listDue(w, req, func(*time.Location) (time.Time, time.Time, error) {
+		return time.Time{}, time.Now(), nil
+	})
+}
+
+// listDue lists the posts due in the period returned by period for the
+// request time zone, narrowed by the filter parameters of the request.
+func (ps *postStore) listDue(w http.ResponseWriter, req *http.Request, period func(*time.Location) (time.Time, time.Time, error)) {
+	q := req.URL.Query()
+	loc, err := location(q)
+	if err != nil {
+		renderError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	from, to, err := period(loc)
+	if err != nil {
+		renderError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	f, err := parseFilter(q)
+	if err != nil {
+		renderError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// intersect with due_after / due_before
+	if f.DueFrom.IsZero() || from.After(f.DueFrom) {
+		f.DueFrom = from
+	}
+	if f.DueTo.IsZero() || (!to.IsZero() && to.Before(f.DueTo)) {
+		f.DueTo = to
+	}
+	ps.listPosts(w, req, f)
+}
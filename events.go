@@ -0,0 +1,239 @@
+package main
+
+import (
+	poststore "SimpleRest/store"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// GET /events streams the changes published by the store as Server-Sent
+// Events. Event ids are "<epoch>-<seq>": seq counts the changes seen by this
+// process and epoch tells its runs apart, so a Last-Event-ID from another
+// run or older than the replay buffer is answered with a reset event, after
+// which the client has to list the posts again.
+
+const (
+	// eventRetry is the reconnection delay advertised to clients.
+	eventRetry = 3 * time.Second
+	// eventHeartbeat is how often an idle stream gets a comment, so that
+	// proxies do not time it out.
+	eventHeartbeat = 15 * time.Second
+	// eventBuffer is how many events a client may lag behind before it is
+	// disconnected to catch up from the replay buffer.
+	eventBuffer = 64
+)
+
+// event is one change as sent to clients.
+type event struct {
+	seq  uint64
+	typ  string
+	post poststore.Posts
+	data []byte
+}
+
+type eventClient struct {
+	filter poststore.Filter
+	ch     chan event
+}
+
+// eventHub numbers the changes of a store, keeps the latest of them for
+// replay and fans them out to the connected clients.
+type eventHub struct {
+	epoch string
+
+	mux     sync.Mutex
+	seq     uint64
+	replay  []event
+	size    int
+	clients map[*eventClient]struct{}
+	closed  bool
+}
+
+// newEventHub starts relaying the changes of bus, keeping the latest size
+// of them for replay.
+func newEventHub(bus *poststore.ChangeBus, size int) *eventHub {
+	h := &eventHub{
+		epoch:   strconv.FormatInt(time.Now().UnixNano(), 36),
+		size:    size,
+		clients: make(map[*eventClient]struct{}),
+	}
+	// a listener misses nothing, even when a delete of every post
+	// publishes more changes at once than any buffer would hold
+	bus.Listen(h.publish)
+	return h
+}
+
+// resize changes the number of events kept for replay.
+func (h *eventHub) resize(size int) {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	h.size = size
+	h.trim()
+}
+
+func (h *eventHub) trim() {
+	if len(h.replay) > h.size {
+		h.replay = h.replay[len(h.replay)-h.size:]
+	}
+}
+
+func (h *eventHub) id(seq uint64) string {
+	return fmt.Sprintf("%s-%d", h.epoch, seq)
+}
+
+func (h *eventHub) publish(c poststore.Change) {
+	data, err := json.Marshal(c.Post)
+	if err != nil {
+		logs.Error("cannot encode event", "event", c.Type, "post", c.Post.ID, "error", err)
+		return
+	}
+
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	h.seq++
+	ev := event{seq: h.seq, typ: c.Type, post: c.Post, data: data}
+	h.replay = append(h.replay, ev)
+	h.trim()
+	for client := range h.clients {
+		if !client.filter.Match(c.Post) {
+			continue
+		}
+		select {
+		case client.ch <- ev:
+		default:
+			// too slow, it reconnects and replays what it missed
+			delete(h.clients, client)
+			close(client.ch)
+		}
+	}
+}
+
+// subscribe registers a client for the events matching f after lastID,
+// empty for only new ones. backlog holds the buffered events it missed.
+// When some of them are no longer buffered, reset is the id of the newest
+// event for the client to resume from. It returns a nil client once the hub
+// is closed.
+func (h *eventHub) subscribe(f poststore.Filter, lastID string) (client *eventClient, backlog []event, reset string) {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	if h.closed {
+		return nil, nil, ""
+	}
+
+	if lastID != "" {
+		seq, ok := h.parseID(lastID)
+		oldest := h.seq - uint64(len(h.replay))
+		if !ok || seq > h.seq || seq < oldest {
+			reset = h.id(h.seq)
+		} else {
+			for _, ev := range h.replay[seq-oldest:] {
+				if f.Match(ev.post) {
+					backlog = append(backlog, ev)
+				}
+			}
+		}
+	}
+
+	client = &eventClient{filter: f, ch: make(chan event, eventBuffer)}
+	h.clients[client] = struct{}{}
+	return client, backlog, reset
+}
+
+// parseID returns the sequence number of an event id issued by this hub.
+func (h *eventHub) parseID(id string) (uint64, bool) {
+	i := strings.LastIndexByte(id, '-')
+	if i < 0 || id[:i] != h.epoch {
+		return 0, false
+	}
+	seq, err := strconv.ParseUint(id[i+1:], 10, 64)
+	return seq, err == nil
+}
+
+func (h *eventHub) unsubscribe(client *eventClient) {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	if _, ok := h.clients[client]; ok {
+		delete(h.clients, client)
+		close(client.ch)
+	}
+}
+
+// close ends every stream, so that server shutdown is not held up by them.
+func (h *eventHub) close() {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	h.closed = true
+	for client := range h.clients {
+		delete(h.clients, client)
+		close(client.ch)
+	}
+}
+
+func writeEvent(w io.Writer, id, typ string, data []byte) {
+	fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", id, typ, data)
+}
+
+func (ps *postStore) eventsHandler(w http.ResponseWriter, req *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		renderError(w, http.StatusInternalServerError, "streaming is not supported")
+		return
+	}
+	q := req.URL.Query()
+	f, err := parseFilter(q)
+	if err != nil {
+		renderError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	// EventSource sends the header on reconnects, the parameter lets a
+	// client resume a stream it opened itself
+	last := req.Header.Get("Last-Event-ID")
+	if last == "" {
+		last = q.Get("last_event_id")
+	}
+
+	client, backlog, reset := ps.events.subscribe(actorFrom(req).Visible(f), last)
+	if client == nil {
+		renderError(w, http.StatusServiceUnavailable, "server is shutting down")
+		return
+	}
+	defer ps.events.unsubscribe(client)
+	// the stream outlives the timeouts of every other response
+	keepStreaming(req)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "retry: %d\n\n", eventRetry.Milliseconds())
+	if reset != "" {
+		writeEvent(w, reset, "reset", []byte("{}"))
+	}
+	for _, ev := range backlog {
+		writeEvent(w, ps.events.id(ev.seq), ev.typ, ev.data)
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(eventHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case ev, ok := <-client.ch:
+			if !ok {
+				return
+			}
+			writeEvent(w, ps.events.id(ev.seq), ev.typ, ev.data)
+		case <-heartbeat.C:
+			io.WriteString(w, ": ping\n\n")
+		case <-req.Context().Done():
+			return
+		}
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	poststore "SimpleRest/store"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// The feeds syndicate the newest posts of an author or a tag, newest first.
+// Entry ids are built from Posts.ID: the post URL in Atom, which stays
+// stable as long as -base-url does, and the bare id in JSON Feed.
+
+func (ps *postStore) authorAtomHandler(w http.ResponseWriter, req *http.Request) {
+	author := mux.Vars(req)["author"]
+	ps.renderFeed(w, req, poststore.Filter{Author: author}, "Posts by "+author, "/author/"+url.PathEscape(author)+"/", atomFeed)
+}
+
+func (ps *postStore) tagAtomHandler(w http.ResponseWriter, req *http.Request) {
+	tag := mux.Vars(req)["tag"]
+	ps.renderFeed(w, req, poststore.Filter{Tags: []string{tag}}, "Posts tagged "+tag, "/tag/"+url.PathEscape(tag)+"/", atomFeed)
+}
+
+func (ps *postStore) authorJSONFeedHandler(w http.ResponseWriter, req *http.Request) {
+	author := mux.Vars(req)["author"]
+	ps.renderFeed(w, req, poststore.Filter{Author: author}, "Posts by "+author, "/author/"+url.PathEscape(author)+"/", jsonFeed)
+}
+
+func (ps *postStore) tagJSONFeedHandler(w http.ResponseWriter, req *http.Request) {
+	tag := mux.Vars(req)["tag"]
+	ps.renderFeed(w, req, poststore.Filter{Tags: []string{tag}}, "Posts tagged "+tag, "/tag/"+url.PathEscape(tag)+"/", jsonFeed)
+}
+
+// feedInfo is what a feed format needs besides its posts.
+type feedInfo struct {
+	title   string
+	home    string
+	self    string
+	baseURL string
+}
+
+type feedFormat func(w http.ResponseWriter, info feedInfo, posts []poststore.Posts)
+
+// renderFeed writes the newest feedSize posts matching f in format. home is
+// the path of the JSON list the feed mirrors.
+func (ps *postStore) renderFeed(w http.ResponseWriter, req *http.Request, f poststore.Filter, title, home string, format feedFormat) {
+	opts := poststore.ListOptions{Sort: "-id", Limit: ps.options().feedSize}
+	page, err := ps.store.ListPosts(req.Context(), actorFrom(req).Visible(f), opts)
+	if err != nil {
+		renderStoreError(w, err)
+		return
+	}
+	if listNotModified(w, req, listETag(req.URL.Path, page.Posts)) {
+		return
+	}
+
+	base := ps.absURL(req, "")
+	format(w, feedInfo{
+		title:   title,
+		home:    base + home,
+		self:    base + req.URL.Path,
+		baseURL: base,
+	}, page.Posts)
+}
+
+// absURL returns path made absolute with the configured base URL, or the
+// scheme and host the request came in on.
+func (ps *postStore) absURL(req *http.Request, path string) string {
+	if base := ps.options().baseURL; base != "" {
+		return strings.TrimSuffix(base, "/") + path
+	}
+	scheme := "http"
+	if req.TLS != nil || req.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return scheme + "://" + req.Host + path
+}
+
+func postURL(info feedInfo, p poststore.Posts) string {
+	return fmt.Sprintf("%s/post/%d/", info.baseURL, p.ID)
+}
+
+// feedUpdated is the newest update among posts, the Unix epoch for an
+// empty feed so that it is stable.
+func feedUpdated(posts []poststore.Posts) time.Time {
+	updated := time.Unix(0, 0)
+	for _, p := range posts {
+		if p.Updated.After(updated) {
+			updated = p.Updated
+		}
+	}
+	return updated.UTC()
+}
+
+// Atom 1.0, RFC 4287.
+
+type atomLink struct {
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+	Href string `xml:"href,attr"`
+}
+
+type atomPerson struct {
+	Name string `xml:"name"`
+}
+
+type atomCategory struct {
+	Term string `xml:"term,attr"`
+}
+
+type atomText struct {
+	Type string `xml:"type,attr"`
+	Body string `xml:",chardata"`
+}
+
+type atomEntry struct {
+	ID         string         `xml:"id"`
+	Title      atomText       `xml:"title"`
+	Updated    string         `xml:"updated"`
+	Published  string         `xml:"published"`
+	Author     atomPerson     `xml:"author"`
+	Link       atomLink       `xml:"link"`
+	Categories []atomCategory `xml:"category"`
+	Content    atomText       `xml:"content"`
+}
+
+type atomDoc struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+func atomFeed(w http.ResponseWriter, info feedInfo, posts []poststore.Posts) {
+	doc := atomDoc{
+		ID:      info.self,
+		Title:   info.title,
+		Updated: feedUpdated(posts).Format(time.RFC3339),
+		Links: []atomLink{
+			{Rel: "self", Type: "application/atom+xml", Href: info.self},
+			{Rel: "alternate", Type: "application/json", Href: info.home},
+		},
+	}
+	for _, p := range posts {
+		e := atomEntry{
+			ID:        postURL(info, p),
+			Title:     atomText{Type: "text", Body: summary(p.Text)},
+			Updated:   p.Updated.UTC().Format(time.RFC3339),
+			Published: p.Created.UTC().Format(time.RFC3339),
+			Author:    atomPerson{Name: p.Author},
+			Link:      atomLink{Rel: "alternate", Type: "application/json", Href: postURL(info, p)},
+			Content:   atomText{Type: "text", Body: p.Text},
+		}
+		for _, t := range p.Tags {
+			e.Categories = append(e.Categories, atomCategory{Term: t})
+		}
+		doc.Entries = append(doc.Entries, e)
+	}
+
+	out, err := xml.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		renderError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+	w.Write([]byte(xml.Header))
+	w.Write(out)
+}
+
+// JSON Feed 1.1, https://jsonfeed.org/version/1.1.
+
+type jsonFeedAuthor struct {
+	Name string `json:"name"`
+}
+
+type jsonFeedItem struct {
+	ID            string           `json:"id"`
+	URL           string           `json:"url"`
+	Title         string           `json:"title"`
+	ContentText   string           `json:"content_text"`
+	DatePublished string           `json:"date_published"`
+	DateModified  string           `json:"date_modified"`
+	Authors       []jsonFeedAuthor `json:"authors"`
+	Tags          []string         `json:"tags,omitempty"`
+	// Ext carries the due date; JSON Feed extensions start with _.
+	Ext struct {
+		Due string `json:"due"`
+	} `json:"_simplerest"`
+}
+
+type jsonFeedDoc struct {
+	Version     string         `json:"version"`
+	Title       string         `json:"title"`
+	HomePageURL string         `json:"home_page_url"`
+	FeedURL     string         `json:"feed_url"`
+	Items       []jsonFeedItem `json:"items"`
+}
+
+func jsonFeed(w http.ResponseWriter, info feedInfo, posts []poststore.Posts) {
+	doc := jsonFeedDoc{
+		Version:     "https://jsonfeed.org/version/1.1",
+		Title:       info.title,
+		HomePageURL: info.home,
+		FeedURL:     info.self,
+		Items:       []jsonFeedItem{},
+	}
+	for _, p := range posts {
+		item := jsonFeedItem{
+			ID:            fmt.Sprint(p.ID),
+			URL:           postURL(info, p),
+			Title:         summary(p.Text),
+			ContentText:   p.Text,
+			DatePublished: p.Created.UTC().Format(time.RFC3339),
+			DateModified:  p.Updated.UTC().Format(time.RFC3339),
+			Authors:       []jsonFeedAuthor{{Name: p.Author}},
+			Tags:          p.Tags,
+		}
+		item.Ext.Due = p.Due.UTC().Format(time.RFC3339)
+		doc.Items = append(doc.Items, item)
+	}
+
+	js, err := json.Marshal(doc)
+	if err != nil {
+		renderError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/feed+json")
+	w.Write(js)
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	poststore "SimpleRest/store"
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// health answers the probes of the orchestrator: /healthz while the process
+// serves at all, /readyz while it should be sent traffic.
+type health struct {
+	store poststore.PostStoreManager
+	// schema is the store checked for pending migrations, nil when it has
+	// none.
+	schema   schemaChecker
+	draining int32
+}
+
+// readyTimeout bounds the checks of one /readyz probe, so that a database
+// that hangs fails it rather than piling probes up.
+const readyTimeout = 2 * time.Second
+
+// schemaChecker is implemented by stores with migrations.
+type schemaChecker interface {
+	CheckSchema(ctx context.Context) error
+}
+
+// healthBody is the body of both probes. Checks has an entry per thing
+// /readyz looked at, "ok" or why it failed.
+type healthBody struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks,omitempty"`
+}
+
+// drain fails /readyz from now on, so that load balancers stop sending new
+// requests while the ones in flight finish.
+func (h *health) drain() {
+	atomic.StoreInt32(&h.draining, 1)
+}
+
+func (h *health) healthzHandler(w http.ResponseWriter, req *http.Request) {
+	requestLogFrom(req.Context()).probe = true
+	renderJSON(w, healthBody{Status: "ok"})
+}
+
+func (h *health) readyzHandler(w http.ResponseWriter, req *http.Request) {
+	requestLogFrom(req.Context()).probe = true
+	if atomic.LoadInt32(&h.draining) != 0 {
+		renderUnready(w, healthBody{Status: "draining"})
+		return
+	}
+	body := healthBody{Status: "ready", Checks: map[string]string{}}
+	check := func(name string, err error) {
+		if err != nil {
+			body.Status = "unavailable"
+			body.Checks[name] = err.Error()
+			return
+		}
+		body.Checks[name] = "ok"
+	}
+	ctx, cancel := context.WithTimeout(req.Context(), readyTimeout)
+	defer cancel()
+	check("store", h.store.Ping(ctx))
+	if h.schema != nil && body.Status == "ready" {
+		check("schema", h.schema.CheckSchema(ctx))
+	}
+	if body.Status != "ready" {
+		renderUnready(w, body)
+		return
+	}
+	renderJSON(w, body)
+}
+
+// renderUnready answers 503 with the probe body rather than an error body,
+// so that both outcomes read the same.
+func renderUnready(w http.ResponseWriter, body healthBody) {
+	js, _ := json.Marshal(body)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusServiceUnavailable)
+	w.Write(js)
+}
+
+// openStore opens the store, waiting up to wait for the database to accept
+// connections. Other errors, and a signal on stop, end the wait at once.
+func openStore(kind string, pg poststore.PgConfig, autoMigrate bool, wait time.Duration, stop <-chan os.Signal) (poststore.PostStoreManager, error) {
+	deadline := time.Now().Add(wait)
+	backoff := time.Second
+	for {
+		store, err := newStore(kind, pg, autoMigrate)
+		if err == nil || !errors.Is(err, poststore.ErrUnavailable) || time.Now().Add(backoff).After(deadline) {
+			return store, err
+		}
+		logs.Warn("waiting for the database", "error", err, "retry_in", backoff.String())
+		select {
+		case <-time.After(backoff):
+		case <-stop:
+			return nil, errors.New("interrupted while waiting for the database")
+		}
+		if backoff *= 2; backoff > 15*time.Second {
+			backoff = 15 * time.Second
+		}
+	}
+}
+
+type connKey struct{}
+
+// withConn is the ConnContext of the server, it keeps the connection of a
+// request within reach of its handler.
+func withConn(ctx context.Context, c net.Conn) context.Context {
+	return context.WithValue(ctx, connKey{}, c)
+}
+
+// keepStreaming lifts the read and write timeouts of the server from a
+// response that lasts as long as the client stays, like an event stream.
+// The read timeout matters too: when it expires the server takes the
+// client for gone and cancels the request.
+func keepStreaming(req *http.Request) {
+	if c, ok := req.Context().Value(connKey{}).(net.Conn); ok {
+		c.SetDeadline(time.Time{})
+	}
+}
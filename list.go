@@ -0,0 +1,167 @@
+package main
+
+import (
+	poststore "SimpleRest/store"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// listPosts renders the page of posts matching f that the caller may see,
+// chosen by the limit, sort, cursor and total query parameters. The body
+// stays a JSON array; paging metadata goes in the Link and X-Total-Count
+// headers.
+func (ps *postStore) listPosts(w http.ResponseWriter, req *http.Request, f poststore.Filter) {
+	page, ok := ps.listPage(w, req, f, "")
+	if !ok {
+		return
+	}
+	renderJSON(w, page.Posts)
+}
+
+// listPage fetches the page listPosts renders, sorted by defaultSort unless
+// the sort parameter says otherwise, and sets its headers. When it returns
+// false the response has been written.
+func (ps *postStore) listPage(w http.ResponseWriter, req *http.Request, f poststore.Filter, defaultSort string) (poststore.Page, bool) {
+	f = actorFrom(req).Visible(f)
+	q := req.URL.Query()
+	limit, ok := ps.pageLimit(w, q)
+	if !ok {
+		return poststore.Page{}, false
+	}
+	opts := poststore.ListOptions{
+		Sort:   q.Get("sort"),
+		Limit:  limit,
+		Cursor: q.Get("cursor"),
+	}
+	if opts.Sort == "" {
+		opts.Sort = defaultSort
+	}
+	if v := q.Get("total"); v != "" {
+		total, err := strconv.ParseBool(v)
+		if err != nil {
+			renderError(w, http.StatusBadRequest, fmt.Sprintf("total must be a boolean, got %q", v))
+			return poststore.Page{}, false
+		}
+		opts.Total = total
+	}
+
+	page, err := ps.store.ListPosts(req.Context(), f, opts)
+	if err != nil {
+		renderStoreError(w, err)
+		return poststore.Page{}, false
+	}
+
+	links := []string{fmt.Sprintf(`<%s>; rel="first"`, pageURL(req, ""))}
+	if page.Prev != "" {
+		links = append(links, fmt.Sprintf(`<%s>; rel="prev"`, pageURL(req, page.Prev)))
+	}
+	if page.Next != "" {
+		links = append(links, fmt.Sprintf(`<%s>; rel="next"`, pageURL(req, page.Next)))
+	}
+	w.Header().Set("Link", strings.Join(links, ", "))
+	if page.Total >= 0 {
+		w.Header().Set("X-Total-Count", strconv.Itoa(page.Total))
+	}
+	return page, true
+}
+
+// pageLimit reads the limit query parameter, pageSize by default and capped
+// at maxPageSize. When it returns false the response has been written.
+func (ps *postStore) pageLimit(w http.ResponseWriter, q url.Values) (int, bool) {
+	o := ps.options()
+	limit := o.pageSize
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			renderError(w, http.StatusBadRequest, fmt.Sprintf("limit must be a positive integer, got %q", v))
+			return 0, false
+		}
+		limit = n
+	}
+	if limit > o.maxPageSize {
+		limit = o.maxPageSize
+	}
+	return limit, true
+}
+
+// parseFilter reads the filter query parameters of GET /post/: tag
+// (repeated or comma separated) with tag_mode any or all, author, text, and
+// due_after / due_before as RFC 3339 times or YYYY-MM-DD dates in the tz
+// time zone.
+func parseFilter(q url.Values) (poststore.Filter, error) {
+	var f poststore.Filter
+	loc, err := location(q)
+	if err != nil {
+		return f, err
+	}
+	for _, v := range q["tag"] {
+		for _, tag := range strings.Split(v, ",") {
+			if tag = strings.TrimSpace(tag); tag != "" {
+				f.Tags = append(f.Tags, tag)
+			}
+		}
+	}
+	switch mode := q.Get("tag_mode"); mode {
+	case "", "any":
+	case "all":
+		f.AllTags = true
+	default:
+		return f, fmt.Errorf("tag_mode must be any or all, got %q", mode)
+	}
+	f.Author = q.Get("author")
+	f.Text = q.Get("text")
+
+	if f.DueFrom, err = parseTimeParam(q, "due_after", loc); err != nil {
+		return f, err
+	}
+	if f.DueTo, err = parseTimeParam(q, "due_before", loc); err != nil {
+		return f, err
+	}
+	return f, nil
+}
+
+// location returns the IANA time zone named by the tz query parameter, the
+// server's local zone when there is none.
+func location(q url.Values) (*time.Location, error) {
+	tz := q.Get("tz")
+	if tz == "" {
+		return time.Local, nil
+	}
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		return nil, fmt.Errorf("tz must be an IANA time zone, got %q", tz)
+	}
+	return loc, nil
+}
+
+// parseTimeParam parses query parameter name as an RFC 3339 time or a date,
+// which is midnight in loc. It returns the zero time when it is absent.
+func parseTimeParam(q url.Values, name string, loc *time.Location) (time.Time, error) {
+	v := q.Get(name)
+	if v == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", v, loc); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("%s must be an RFC 3339 time or YYYY-MM-DD date, got %q", name, v)
+}
+
+// pageURL is the request URL with its cursor replaced.
+func pageURL(req *http.Request, cursor string) string {
+	u := *req.URL
+	q := u.Query()
+	q.Del("cursor")
+	if cursor != "" {
+		q.Set("cursor", cursor)
+	}
+	u.RawQuery = q.Encode()
+	return u.RequestURI()
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	poststore "SimpleRest/store"
+	"context"
+	"net/http"
+	"reflect"
+	"regexp"
+	"testing"
+	"time"
+)
+
+// linkRel matches one link of a Link header.
+var linkRel = regexp.MustCompile(`<([^>]*)>; rel="([a-z]+)"`)
+
+func TestListLinks(t *testing.T) {
+	store := poststore.New()
+	ctx := context.Background()
+	for i := 0; i < 7; i++ {
+		if _, err := store.CreatePost(ctx, "post", "alice", nil, time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC), false, "alice"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ps := NewPostServer(store)
+	ps.setOptions(options{pageSize: 2, maxPageSize: 3})
+	h := http.HandlerFunc(ps.getAllPostsHandler)
+
+	tests := []struct {
+		name  string
+		limit string
+		want  [][]int
+	}{
+		{"default", "", [][]int{{1, 2}, {3, 4}, {5, 6}, {7}}},
+		{"clamped", "?limit=100", [][]int{{1, 2, 3}, {4, 5, 6}, {7}}},
+		{"below the cap", "?limit=3", [][]int{{1, 2, 3}, {4, 5, 6}, {7}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var pages [][]int
+			var prevs []string
+			path := "/post/" + tt.limit
+			for path != "" {
+				var posts []poststore.Posts
+				w := serve(t, h, "GET", path, "", nil, &posts)
+				if w.Code != http.StatusOK {
+					t.Fatalf("GET %s: %d %s", path, w.Code, w.Body)
+				}
+				var ids []int
+				for _, p := range posts {
+					ids = append(ids, p.ID)
+				}
+				pages = append(pages, ids)
+
+				links := map[string]string{}
+				for _, m := range linkRel.FindAllStringSubmatch(w.Header().Get("Link"), -1) {
+					links[m[2]] = m[1]
+				}
+				if links["first"] != "/post/"+tt.limit {
+					t.Errorf("GET %s: first is %q", path, links["first"])
+				}
+				prevs = append(prevs, links["prev"])
+				path = links["next"]
+				if len(pages) > len(tt.want) {
+					t.Fatalf("more than %d pages: %v", len(tt.want), pages)
+				}
+			}
+			if !reflect.DeepEqual(pages, tt.want) {
+				t.Errorf("pages = %v, want %v", pages, tt.want)
+			}
+
+			// following prev from the last page gives the pages before
+			for i := len(pages) - 1; i > 0; i-- {
+				var posts []poststore.Posts
+				serve(t, h, "GET", prevs[i], "", nil, &posts)
+				var ids []int
+				for _, p := range posts {
+					ids = append(ids, p.ID)
+				}
+				if !reflect.DeepEqual(ids, pages[i-1]) {
+					t.Errorf("prev of page %d = %v, want %v", i, ids, pages[i-1])
+				}
+			}
+			if prevs[0] != "" {
+				t.Errorf("the first page has prev %q", prevs[0])
+			}
+		})
+	}
+
+	for _, limit := range []string{"0", "-1", "x"} {
+		if w := serve(t, h, "GET", "/post/?limit="+limit, "", nil, nil); w.Code != http.StatusBadRequest {
+			t.Errorf("limit %s: %d, want 400", limit, w.Code)
+		}
+	}
+	if w := serve(t, h, "GET", "/post/?cursor=bogus", "", nil, nil); w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("bogus cursor: %d, want 422", w.Code)
+	}
+}
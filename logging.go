@@ -0,0 +1,337 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Everything is logged as one JSON object per line:
+//
+//	{"time":"...","level":"info","msg":"request","method":"GET",...}
+//
+// Requests are logged once they have been served, by logRequests. Post
+// text is never logged, debug entries show its length instead.
+
+type logLevel int32
+
+const (
+	levelDebug logLevel = iota
+	levelInfo
+	levelWarn
+	levelError
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+func (l logLevel) String() string {
+	if l < levelDebug || l > levelError {
+		return fmt.Sprintf("level(%d)", int32(l))
+	}
+	return levelNames[l]
+}
+
+func parseLevel(s string) (logLevel, error) {
+	for i, name := range levelNames {
+		if strings.EqualFold(s, name) {
+			return logLevel(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown log level %q, want one of %s", s, strings.Join(levelNames, ", "))
+}
+
+// logger writes JSON lines at or above a level that can be changed while
+// it is in use.
+type logger struct {
+	level int32
+	mu    sync.Mutex
+	out   io.Writer
+}
+
+// logs is the logger of the process.
+var logs = newLogger(os.Stderr, levelInfo)
+
+func newLogger(out io.Writer, level logLevel) *logger {
+	return &logger{level: int32(level), out: out}
+}
+
+func (l *logger) Level() logLevel {
+	return logLevel(atomic.LoadInt32(&l.level))
+}
+
+func (l *logger) SetLevel(level logLevel) {
+	atomic.StoreInt32(&l.level, int32(level))
+}
+
+func (l *logger) Enabled(level logLevel) bool {
+	return level >= l.Level()
+}
+
+func (l *logger) Debug(msg string, kv ...interface{}) { l.log(levelDebug, msg, kv) }
+func (l *logger) Info(msg string, kv ...interface{})  { l.log(levelInfo, msg, kv) }
+func (l *logger) Warn(msg string, kv ...interface{})  { l.log(levelWarn, msg, kv) }
+func (l *logger) Error(msg string, kv ...interface{}) { l.log(levelError, msg, kv) }
+
+// Fatal logs at error level and exits.
+func (l *logger) Fatal(msg string, kv ...interface{}) {
+	l.log(levelError, msg, kv)
+	os.Exit(1)
+}
+
+// log writes msg with the alternating keys and values of kv.
+func (l *logger) log(level logLevel, msg string, kv []interface{}) {
+	if !l.Enabled(level) {
+		return
+	}
+	var b bytes.Buffer
+	b.WriteString(`{"time":`)
+	writeJSON(&b, time.Now().UTC().Format(time.RFC3339Nano))
+	b.WriteString(`,"level":`)
+	writeJSON(&b, level.String())
+	b.WriteString(`,"msg":`)
+	writeJSON(&b, msg)
+	for i := 0; i < len(kv); i += 2 {
+		key, ok := kv[i].(string)
+		if !ok {
+			key = fmt.Sprint(kv[i])
+		}
+		var v interface{} = "(missing)"
+		if i+1 < len(kv) {
+			v = kv[i+1]
+		}
+		b.WriteByte(',')
+		writeJSON(&b, key)
+		b.WriteByte(':')
+		writeJSON(&b, v)
+	}
+	b.WriteString("}\n")
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.out.Write(b.Bytes())
+}
+
+func writeJSON(b *bytes.Buffer, v interface{}) {
+	switch x := v.(type) {
+	case error:
+		v = x.Error()
+	case fmt.Stringer:
+		v = x.String()
+	}
+	js, err := json.Marshal(v)
+	if err != nil {
+		js, _ = json.Marshal(fmt.Sprint(v))
+	}
+	b.Write(js)
+}
+
+// stdLogWriter turns the lines of the standard logger, which the store
+// package writes to, into entries of a logger.
+type stdLogWriter struct {
+	logs  *logger
+	level logLevel
+}
+
+func (w stdLogWriter) Write(p []byte) (int, error) {
+	w.logs.log(w.level, strings.TrimSpace(string(p)), nil)
+	return len(p), nil
+}
+
+// redact stands in for post text in logs.
+func redact(text string) string {
+	return fmt.Sprintf("[%d bytes]", len(text))
+}
+
+// requestIDHeader carries the id of a request. One sent by the client is
+// kept, so that it can follow a call across services, otherwise one is
+// generated. Either way it is sent back.
+const requestIDHeader = "X-Request-ID"
+
+// maxRequestID bounds the length of request ids taken from clients.
+const maxRequestID = 128
+
+// requestLog collects what the request log line needs from the handlers
+// further down the chain.
+type requestLog struct {
+	id        string
+	traceID   string
+	route     string
+	principal string
+	// probe requests are logged at debug level, they come every few
+	// seconds and say nothing, or at warn when they fail.
+	probe bool
+}
+
+type requestLogKey struct{}
+
+func requestLogFrom(ctx context.Context) *requestLog {
+	rl, _ := ctx.Value(requestLogKey{}).(*requestLog)
+	if rl == nil {
+		return &requestLog{}
+	}
+	return rl
+}
+
+// requestID is the id of the request ctx belongs to.
+func requestID(ctx context.Context) string {
+	return requestLogFrom(ctx).id
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestID {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// statusWriter records the status and size of a response.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += int64(n)
+	return n, err
+}
+
+// Flush keeps event streams working through the wrapper.
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		if w.status == 0 {
+			w.status = http.StatusOK
+		}
+		f.Flush()
+	}
+}
+
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// logRequests assigns every request an id and logs it once served: at
+// error level for 5xx responses, info otherwise, probes aside. It wraps
+// the router so that unrouted requests are logged too.
+func logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		start := time.Now()
+		rl := &requestLog{id: req.Header.Get(requestIDHeader)}
+		if !validRequestID(rl.id) {
+			rl.id = newRequestID()
+		}
+		w.Header().Set(requestIDHeader, rl.id)
+		sw := &statusWriter{ResponseWriter: w}
+		next.ServeHTTP(sw, req.WithContext(context.WithValue(req.Context(), requestLogKey{}, rl)))
+
+		if sw.status == 0 {
+			sw.status = http.StatusOK
+		}
+		level := levelInfo
+		switch {
+		case rl.probe && sw.status >= 500:
+			level = levelWarn
+		case rl.probe:
+			level = levelDebug
+		case sw.status >= 500:
+			level = levelError
+		}
+		logs.log(level, "request", []interface{}{
+			"request_id", rl.id,
+			"method", req.Method,
+			"route", rl.route,
+			"path", req.URL.Path,
+			"status", sw.status,
+			"bytes", sw.bytes,
+			"latency_ms", float64(time.Since(start).Microseconds()) / 1000,
+			"principal", rl.principal,
+			"trace_id", rl.traceID,
+			"remote", req.RemoteAddr,
+		})
+	})
+}
+
+// logRoute records the template of the route that matched, which keeps
+// the number of distinct routes logged small.
+func logRoute(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if route := mux.CurrentRoute(req); route != nil {
+			if tpl, err := route.GetPathTemplate(); err == nil {
+				requestLogFrom(req.Context()).route = tpl
+			}
+		}
+		next.ServeHTTP(w, req)
+	})
+}
+
+// logLevelBody is the body of the log level endpoint.
+type logLevelBody struct {
+	Level string `json:"level"`
+}
+
+func (ps *postStore) getLogLevelHandler(w http.ResponseWriter, req *http.Request) {
+	renderJSON(w, logLevelBody{Level: logs.Level().String()})
+}
+
+// setLogLevelHandler changes the log level of the running process.
+func (ps *postStore) setLogLevelHandler(w http.ResponseWriter, req *http.Request) {
+	if _, ok := requireMediaType(w, req, "application/json"); !ok {
+		return
+	}
+	var body logLevelBody
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		renderError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	level, err := parseLevel(body.Level)
+	if err != nil {
+		renderError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	if old := logs.Level(); old != level {
+		logs.SetLevel(level)
+		logs.Warn("log level changed", "request_id", requestID(req.Context()), "from", old, "to", level)
+	}
+	renderJSON(w, logLevelBody{Level: level.String()})
+}
+
+// stdLog sends the standard logger to logs at level.
+func stdLog(level logLevel) {
+	log.SetFlags(0)
+	log.SetOutput(stdLogWriter{logs: logs, level: level})
+}
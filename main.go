@@ -2,176 +2,684 @@ package main
 
 import (
 	poststore "SimpleRest/store"
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
-	"log"
+	"io"
+	"io/ioutil"
 	"mime"
 	"net/http"
+	"os"
+	"os/signal"
 	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
 
 	"github.com/gorilla/mux"
 	"time"
 )
 
 type postStore struct {
-	store *poststore.PostStore
+	store poststore.PostStoreManager
+	// opts holds the options, which a reload can swap while requests are
+	// being served.
+	opts atomic.Value
+	// events relays the changes of store to /events streams.
+	events *eventHub
+	// webhooks sends the webhook deliveries queued in store.
+	webhooks *dispatcher
+	// limiter counts the requests of each client against the rate limit.
+	limiter *rateLimiter
 }
 
-func NewPostServer() *postStore {
-	store := poststore.New()
-	return &postStore{store: store}
+// options are the settings of the handlers.
+type options struct {
+	// requireIfMatch rejects writes to a single post without If-Match.
+	requireIfMatch bool
+	// pageSize is the default limit of list endpoints, maxPageSize caps
+	// the limit a client can ask for.
+	pageSize    int
+	maxPageSize int
+	// feedSize is the number of entries in Atom and JSON feeds.
+	feedSize int
+	// calendarSize caps the events of a calendar feed.
+	calendarSize int
+	// baseURL is the public URL of the service, used for absolute links.
+	// Empty means the scheme and host of each request.
+	baseURL string
+	// corsOrigins may call the API from a browser, all of them when it
+	// holds *.
+	corsOrigins []string
+	// rateLimit is the requests a minute a client address may make, 0 for
+	// no limit, and rateBurst how many of them it may make at once.
+	rateLimit int
+	rateBurst int
+}
+
+func NewPostServer(store poststore.PostStoreManager) *postStore {
+	ps := &postStore{
+		store:   store,
+		events:  newEventHub(store.Changes(), 1000),
+		limiter: newRateLimiter(),
+	}
+	ps.setOptions(options{pageSize: 50, maxPageSize: 500, feedSize: 20, calendarSize: 500})
+	return ps
+}
+
+func (ps *postStore) options() options {
+	return ps.opts.Load().(options)
+}
+
+func (ps *postStore) setOptions(o options) {
+	ps.opts.Store(o)
+}
+
+// newStore builds the storage backend named by kind. A Postgres store is
+// only returned once its schema is current, migrating it first if
+// autoMigrate is set.
+func newStore(kind string, pg poststore.PgConfig, autoMigrate bool) (poststore.PostStoreManager, error) {
+	switch kind {
+	case "memory":
+		return poststore.New(), nil
+	case "postgres":
+		if err := checkSchema(pg, autoMigrate); err != nil {
+			return nil, err
+		}
+		return poststore.NewPg(pg)
+	default:
+		return nil, fmt.Errorf("unknown store %q, expect memory or postgres", kind)
+	}
+}
+
+// checkSchema fails unless the Postgres schema is current, migrating it
+// first if autoMigrate is set.
+func checkSchema(pg poststore.PgConfig, autoMigrate bool) error {
+	store, err := poststore.NewPg(migrationConfig(pg))
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	if autoMigrate {
+		if _, err := store.MigrateUp(); err != nil {
+			return err
+		}
+	}
+	if err := store.CheckSchema(context.Background()); err != nil {
+		return fmt.Errorf("%w, run %s migrate up", err, os.Args[0])
+	}
+	return nil
 }
 
 func renderJSON(w http.ResponseWriter, v interface{}) {
 	js, err := json.Marshal(v)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		renderError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
 	w.Write(js)
 }
 
-func (ps *postStore) createPostHandler(w http.ResponseWriter, req *http.Request) {
-	log.Printf("handling task create at %s\n", req.URL.Path)
+// errorBody is the JSON body of every error response.
+type errorBody struct {
+	Error struct {
+		Status  int    `json:"status"`
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// renderError writes status with a JSON error body. The code is a stable
+// snake_case form of the status text clients can switch on.
+func renderError(w http.ResponseWriter, status int, msg string) {
+	var body errorBody
+	body.Error.Status = status
+	body.Error.Code = errorCode(status)
+	body.Error.Message = msg
+
+	js, _ := json.Marshal(body)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	w.Write(js)
+}
 
-	// Types used internally in this handler to (de-)serialize the request and
-	// response from/to JSON.
-	type RequestPost struct {
-		ID     int       `json:"id"`
-		Text   string    `json:"text"`
-		Author string    `json:"author"`
-		Tags   []string  `json:"tags"`
-		Due    time.Time `json:"due"`
+// renderStoreError maps an error returned by the store to its status code.
+func renderStoreError(w http.ResponseWriter, err error) {
+	status := storeErrorStatus(err)
+	if status == http.StatusInternalServerError {
+		logs.Error("store error", "error", err)
 	}
+	renderError(w, status, err.Error())
+}
 
-	type ResponseId struct {
-		ID int `json:"id"`
+func storeErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, poststore.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, poststore.ErrConflict):
+		return http.StatusConflict
+	case errors.Is(err, poststore.ErrValidation):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, poststore.ErrPrecondition):
+		return http.StatusPreconditionFailed
+	case errors.Is(err, poststore.ErrForbidden):
+		return http.StatusForbidden
+	case errors.Is(err, poststore.ErrUnavailable):
+		return http.StatusServiceUnavailable
 	}
+	return http.StatusInternalServerError
+}
 
-	// Enforce a JSON Content-Type.
+// errorCode is the code of the error body of status.
+func errorCode(status int) string {
+	return strings.ReplaceAll(strings.ToLower(http.StatusText(status)), " ", "_")
+}
+
+// requestPost is the JSON body of create and replace requests, and the
+// document a PATCH is applied to.
+type requestPost struct {
+	ID   int    `json:"id"`
+	Text string `json:"text"`
+	// Author defaults to the caller on create and to the current author
+	// on replace.
+	Author  string    `json:"author"`
+	Tags    []string  `json:"tags"`
+	Due     time.Time `json:"due"`
+	Private bool      `json:"private"`
+	// Version, when set, must match the stored post like If-Match does.
+	Version int `json:"version,omitempty"`
+	// Created and Updated are accepted so a GET body can be sent back as
+	// is, but they are set by the store and ignored here.
+	Created *time.Time `json:"created,omitempty"`
+	Updated *time.Time `json:"updated,omitempty"`
+}
+
+// requireMediaType checks the request Content-Type is one of types and
+// returns it. It writes the error response itself otherwise.
+func requireMediaType(w http.ResponseWriter, req *http.Request, types ...string) (string, bool) {
 	contentType := req.Header.Get("Content-Type")
 	mediatype, _, err := mime.ParseMediaType(contentType)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		renderError(w, http.StatusBadRequest, err.Error())
+		return "", false
+	}
+	for _, t := range types {
+		if mediatype == t {
+			return mediatype, true
+		}
+	}
+	renderError(w, http.StatusUnsupportedMediaType, fmt.Sprintf("expect %s Content-Type", strings.Join(types, " or ")))
+	return "", false
+}
+
+// decodePost strictly decodes a requestPost, rejecting unknown fields.
+func decodePost(r io.Reader) (requestPost, error) {
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	var rt requestPost
+	err := dec.Decode(&rt)
+	return rt, err
+}
+
+func (ps *postStore) createPostHandler(w http.ResponseWriter, req *http.Request) {
+	// Enforce a JSON Content-Type.
+	if _, ok := requireMediaType(w, req, "application/json"); !ok {
 		return
 	}
-	if mediatype != "application/json" {
-		http.Error(w, "expect application/json Content-Type", http.StatusUnsupportedMediaType)
+
+	rt, err := decodePost(req.Body)
+	if err != nil {
+		renderError(w, http.StatusBadRequest, err.Error())
 		return
 	}
 
-	dec := json.NewDecoder(req.Body)
-	dec.DisallowUnknownFields()
-	var rt RequestPost
-	if err := dec.Decode(&rt); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	actor := actorFrom(req)
+	if rt.Author == "" {
+		rt.Author = actor.Name
+	}
+	if err := actor.CanWrite(rt.Author); err != nil {
+		renderStoreError(w, err)
 		return
 	}
 
-	id := ps.store.AddPostToDb(rt.Author, rt.Text, rt.Tags, rt.Due)
-	rt.ID = id
-	fmt.Println(rt.Text, rt.Tags, rt.Due, ps.store)
-	renderJSON(w, rt)
+	post, err := ps.store.CreatePost(req.Context(), rt.Text, rt.Author, rt.Tags, rt.Due, rt.Private, actor.Name)
+	if err != nil {
+		renderStoreError(w, err)
+		return
+	}
+	logs.Debug("post created", "request_id", requestID(req.Context()), "post", post.ID, "author", post.Author, "tags", post.Tags, "text", redact(post.Text))
+	w.Header().Set("ETag", etag(post))
+	renderJSON(w, post)
 }
 
-func (ps *postStore) getPostsByAuthor(w http.ResponseWriter, req *http.Request) {
-	log.Printf("handling get all tasks at %s\n", req.URL.Path)
+func (ps *postStore) replacePostHandler(w http.ResponseWriter, req *http.Request) {
+	id, _ := strconv.Atoi(mux.Vars(req)["id"])
 
-	author := mux.Vars(req)["author"]
+	if _, ok := requireMediaType(w, req, "application/json"); !ok {
+		return
+	}
+	rt, err := decodePost(req.Body)
+	if err != nil {
+		renderError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	version, ok := ps.expectedVersion(w, req, id)
+	if !ok {
+		return
+	}
+	ps.updatePost(w, req, id, version, rt)
+}
 
-	fmt.Println(author)
-	allPosts := ps.store.GetPostsByAuthorDb(author)
+func (ps *postStore) patchPostHandler(w http.ResponseWriter, req *http.Request) {
+	id, _ := strconv.Atoi(mux.Vars(req)["id"])
+
+	mediatype, ok := requireMediaType(w, req, "application/merge-patch+json", "application/json-patch+json")
+	if !ok {
+		return
+	}
 
-	js, err := json.Marshal(allPosts)
+	body, err := ioutil.ReadAll(req.Body)
 	if err != nil {
+		renderError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	version, ok := ps.expectedVersion(w, req, id)
+	if !ok {
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.Write(js)
+	// the patch is applied to the post as writePost reads it, again when
+	// it changed before the write
+	actor := actorFrom(req)
+	post, ok := ps.writePost(w, req, id, version, ps.store.GetPost, func(current poststore.Posts) (poststore.Posts, error) {
+		rt, status, err := applyPatch(current, mediatype, body)
+		if err != nil {
+			return poststore.Posts{}, &statusError{status, err}
+		}
+		if err := checkChange(actor, current, rt.Author); err != nil {
+			return poststore.Posts{}, err
+		}
+		return ps.store.UpdatePost(req.Context(), id, current.Version, rt.Text, rt.Author, rt.Tags, rt.Due, rt.Private, actor.Name)
+	})
+	if !ok {
+		return
+	}
+	w.Header().Set("ETag", etag(post))
+	renderJSON(w, post)
 }
 
-func (ps *postStore) getAllPostsHandler(w http.ResponseWriter, req *http.Request) {
-	log.Printf("handling get all tasks at %s\n", req.URL.Path)
+// applyPatch applies a merge patch or JSON patch body to the JSON form of
+// current. On failure it returns the status to answer with.
+func applyPatch(current poststore.Posts, mediatype string, body []byte) (requestPost, int, error) {
+	var doc interface{}
+	js, _ := json.Marshal(requestPost{ID: current.ID, Text: current.Text, Author: current.Author, Tags: current.Tags, Due: current.Due, Private: current.Private, Version: current.Version})
+	json.Unmarshal(js, &doc)
+
+	if mediatype == "application/merge-patch+json" {
+		var patch interface{}
+		if err := json.Unmarshal(body, &patch); err != nil {
+			return requestPost{}, http.StatusBadRequest, err
+		}
+		doc = mergePatch(doc, patch)
+	} else {
+		var ops []patchOp
+		if err := json.Unmarshal(body, &ops); err != nil {
+			return requestPost{}, http.StatusBadRequest, err
+		}
+		var err error
+		doc, err = jsonPatch(doc, ops)
+		if errors.Is(err, errPatchTest) {
+			return requestPost{}, http.StatusConflict, err
+		}
+		if err != nil {
+			return requestPost{}, http.StatusUnprocessableEntity, err
+		}
+	}
 
-	allTasks := ps.store.GetAllPostsDb()
+	js, _ = json.Marshal(doc)
+	rt, err := decodePost(bytes.NewReader(js))
+	if err != nil {
+		return requestPost{}, http.StatusUnprocessableEntity, err
+	}
+	if rt.ID != current.ID {
+		return requestPost{}, http.StatusUnprocessableEntity, fmt.Errorf("id %d does not match post %d", rt.ID, current.ID)
+	}
+	if rt.Version != current.Version {
+		return requestPost{}, http.StatusUnprocessableEntity, errors.New("version is read-only, use If-Match")
+	}
+	return rt, 0, nil
+}
 
-	fmt.Println(allTasks)
-	renderJSON(w, allTasks)
+// updatePost stores rt as the new content of post id and renders the result.
+// version is the one required by If-Match, 0 if there was none.
+func (ps *postStore) updatePost(w http.ResponseWriter, req *http.Request, id int, version int, rt requestPost) {
+	if rt.ID != 0 && rt.ID != id {
+		renderError(w, http.StatusUnprocessableEntity, fmt.Sprintf("id %d does not match post %d", rt.ID, id))
+		return
+	}
+	if version == 0 {
+		version = rt.Version
+	} else if rt.Version != 0 && rt.Version != version {
+		renderError(w, http.StatusPreconditionFailed, fmt.Sprintf("body version %d contradicts If-Match", rt.Version))
+		return
+	}
+
+	// the write is made at the version that was authorized, so that the
+	// post cannot change hands in between
+	actor := actorFrom(req)
+	post, ok := ps.writePost(w, req, id, version, ps.store.GetPost, func(current poststore.Posts) (poststore.Posts, error) {
+		author := rt.Author
+		if author == "" {
+			author = current.Author
+		}
+		if err := checkChange(actor, current, author); err != nil {
+			return poststore.Posts{}, err
+		}
+		return ps.store.UpdatePost(req.Context(), id, current.Version, rt.Text, author, rt.Tags, rt.Due, rt.Private, actor.Name)
+	})
+	if !ok {
+		return
+	}
+	w.Header().Set("ETag", etag(post))
+	renderJSON(w, post)
 }
 
-func (ps *postStore) getPostHandler(w http.ResponseWriter, req *http.Request) {
-	log.Printf("handling get post at %s\n", req.URL.Path)
+// checkChange authorizes actor to replace current with a post written by
+// author. A post actor may not read is reported missing.
+func checkChange(actor poststore.Actor, current poststore.Posts, author string) error {
+	if !actor.CanRead(current) {
+		return fmt.Errorf("post %d %w", current.ID, poststore.ErrNotFound)
+	}
+	if err := actor.CanChange(current); err != nil {
+		return err
+	}
+	return actor.CanWrite(author)
+}
+
+func (ps *postStore) getPostsByAuthor(w http.ResponseWriter, req *http.Request) {
+	author := mux.Vars(req)["author"]
+	ps.listPosts(w, req, poststore.Filter{Author: author})
+}
+
+func (ps *postStore) getAllPostsHandler(w http.ResponseWriter, req *http.Request) {
+	f, err := parseFilter(req.URL.Query())
+	if err != nil {
+		renderError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	ps.listPosts(w, req, f)
+}
 
+func (ps *postStore) getPostHandler(w http.ResponseWriter, req *http.Request) {
 	id, _ := strconv.Atoi(mux.Vars(req)["id"])
 
-	task, err := ps.store.GetPostByIdDb(id)
+	task, err := ps.store.GetPost(req.Context(), id)
+	if err == nil && !actorFrom(req).CanRead(task) {
+		err = fmt.Errorf("post %d %w", id, poststore.ErrNotFound)
+	}
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusNotFound)
+		renderStoreError(w, err)
+		return
+	}
+	if notModified(w, req, task) {
 		return
 	}
-	task.Tags = []string(task.Tags)
 
+	w.Header().Set("ETag", etag(task))
 	renderJSON(w, task)
 }
 
 func (ps *postStore) deletePostHandler(w http.ResponseWriter, req *http.Request) {
-	log.Printf("handling delete post at %s\n", req.URL.Path)
 	id, _ := strconv.Atoi(mux.Vars(req)["id"])
 
-	err := ps.store.DeletePostFromDb(id)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusNotFound)
+	version, ok := ps.expectedVersion(w, req, id)
+	if !ok {
 		return
 	}
+	actor := actorFrom(req)
+	ps.writePost(w, req, id, version, ps.store.GetPost, func(current poststore.Posts) (poststore.Posts, error) {
+		if err := checkChange(actor, current, current.Author); err != nil {
+			return poststore.Posts{}, err
+		}
+		return current, ps.store.DeletePost(req.Context(), id, current.Version, actor.Name)
+	})
 }
 
 func (ps *postStore) deleteAllPostsHandler(w http.ResponseWriter, req *http.Request) {
-	log.Printf("handling delete all posts at %s\n", req.URL.Path)
-	ps.store.DeleteAllPostsFromDb()
+	actor := actorFrom(req)
+	if err := actor.CanAdminister(); err != nil {
+		renderStoreError(w, err)
+		return
+	}
+	// a stray DELETE of the collection must not wipe it
+	if req.URL.Query().Get("confirm") != "all" {
+		renderError(w, http.StatusBadRequest, "deleting every post needs ?confirm=all")
+		return
+	}
+	if err := ps.store.DeleteAllPosts(req.Context(), actor.Name); err != nil {
+		renderStoreError(w, err)
+	}
 }
 
 func (ps *postStore) tagHandler(w http.ResponseWriter, req *http.Request) {
-	log.Printf("handling posts by tag at %s\n", req.URL.Path)
-
 	tag := mux.Vars(req)["tag"]
 
-	tasks := ps.store.GetPostsByTagDb(tag)
-	renderJSON(w, tasks)
+	ps.listPosts(w, req, poststore.Filter{Tags: []string{tag}})
 }
 
-func (ps *postStore) dueHandler(w http.ResponseWriter, req *http.Request) {
-	log.Printf("handling posts by due at %s\n", req.URL.Path)
-
-	vars := mux.Vars(req)
-	badRequestError := func() {
-		http.Error(w, fmt.Sprintf("expect /due/<year>/<month>/<day>, got %v", req.URL.Path), http.StatusBadRequest)
+func main() {
+	cfg, err := loadConfig(os.Args[1:], os.LookupEnv)
+	switch {
+	case err == flag.ErrHelp:
+		os.Exit(0)
+	case err != nil:
+		if errs, ok := err.(configErrors); ok {
+			logs.Fatal("bad configuration", "errors", []string(errs))
+		}
+		os.Exit(2)
 	}
 
-	year, _ := strconv.Atoi(vars["year"])
-	month, _ := strconv.Atoi(vars["month"])
-	if month < int(time.January) || month > int(time.December) {
-		badRequestError()
+	level, _ := parseLevel(cfg.LogLevel)
+	logs.SetLevel(level)
+	// the store package logs through the standard logger
+	stdLog(levelWarn)
+
+	switch {
+	case len(cfg.args) == 2 && cfg.args[0] == "config" && cfg.args[1] == "print":
+		cfg.print(os.Stdout)
+		return
+	case len(cfg.args) > 0 && cfg.args[0] == "migrate":
+		if err := runMigrate(cfg.PG, cfg.args[1:]); err != nil {
+			logs.Fatal("migration failed", "error", err)
+		}
 		return
+	case len(cfg.args) > 0:
+		fs := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+		defineConfig(fs)
+		usage(fs)()
+		os.Exit(2)
 	}
-	day, _ := strconv.Atoi(vars["day"])
-	tasks := ps.store.GetPostByDue(year, time.Month(month), day)
-	renderJSON(w, tasks)
-}
 
-func main() {
+	switch {
+	case cfg.TraceFile != "":
+		exporter, err := newFileExporter(cfg.TraceFile)
+		if err != nil {
+			logs.Fatal("cannot open trace file", "error", err)
+		}
+		tracing = newTracer(exporter)
+	case cfg.TraceURL != "":
+		tracing = newTracer(newOTLPExporter(cfg.TraceURL))
+	}
+	// spans still queued are exported once everything else has stopped
+	defer tracing.close()
+	if tracing != nil {
+		cfg.PG.Observer = traceQuery
+	}
+
+	// signals are caught from here on, so that one can end the wait for
+	// the database too
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+
+	store, err := openStore(cfg.Store, cfg.PG, cfg.AutoMigrate, cfg.DBWait, stop)
+	if err != nil {
+		logs.Fatal("cannot open store", "store", cfg.Store, "error", err)
+	}
+	defer store.Close()
+	if pooled, ok := store.(poolStater); ok {
+		registerPoolMetrics(pooled)
+	}
+	probes := &health{}
+	if schema, ok := store.(schemaChecker); ok {
+		probes.schema = schema
+	}
+	store = measureStore(store)
+	probes.store = store
+
 	router := mux.NewRouter()
 	router.StrictSlash(true)
-	server := NewPostServer()
+	router.NotFoundHandler = http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		renderError(w, http.StatusNotFound, fmt.Sprintf("no route for %s", req.URL.Path))
+	})
+	router.MethodNotAllowedHandler = http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		renderError(w, http.StatusMethodNotAllowed, fmt.Sprintf("%s not allowed on %s", req.Method, req.URL.Path))
+	})
+	server := NewPostServer(store)
+	server.setOptions(cfg.options())
+	server.events.resize(cfg.EventReplay)
+	server.webhooks = newDispatcher(store, cfg.WebhookTimeout, cfg.WebhookMaxAttempts)
+	defer server.webhooks.close()
+	if cfg.TrashRetention > 0 {
+		defer newSweeper(store, cfg.TrashRetention).close()
+	}
+
+	jwt := jwtConfig{issuer: cfg.JWTIssuer, audience: cfg.JWTAudience}
+	if cfg.JWTSecret != "" {
+		jwt.secret = []byte(cfg.JWTSecret)
+	}
+	if cfg.JWTPublicKey != "" {
+		if jwt.publicKey, err = loadEd25519Key(cfg.JWTPublicKey); err != nil {
+			logs.Fatal("cannot load JWT public key", "error", err)
+		}
+	}
+	// routes are registered as requiring credentials unless passed to
+	// allowAnonymous
+	auth := newAuthenticator(store, cfg.AdminKey, jwt)
+	router.Use(logRoute, server.limitRate, auth.middleware)
+
+	auth.allowAnonymous(router.HandleFunc("/healthz", probes.healthzHandler).Methods("GET"))
+	auth.allowAnonymous(router.HandleFunc("/readyz", probes.readyzHandler).Methods("GET"))
 
 	router.HandleFunc("/post/", server.createPostHandler).Methods("POST")
-	router.HandleFunc("/post/", server.getAllPostsHandler).Methods("GET")
+	auth.allowAnonymous(router.HandleFunc("/post/", server.getAllPostsHandler).Methods("GET"))
 	router.HandleFunc("/post/", server.deleteAllPostsHandler).Methods("DELETE")
-	router.HandleFunc("/post/{id:[0-9]+}/", server.getPostHandler).Methods("GET")
+	auth.allowAnonymous(router.HandleFunc("/post/{id:[0-9]+}/", server.getPostHandler).Methods("GET"))
+	router.HandleFunc("/post/{id:[0-9]+}/", server.replacePostHandler).Methods("PUT")
+	router.HandleFunc("/post/{id:[0-9]+}/", server.patchPostHandler).Methods("PATCH")
 	router.HandleFunc("/post/{id:[0-9]+}/", server.deletePostHandler).Methods("DELETE")
-	router.HandleFunc("/tag/{tag}/", server.tagHandler).Methods("GET")
-	router.HandleFunc("/author/{author}/", server.getPostsByAuthor).Methods("GET")
-	router.HandleFunc("/due/{year:[0-9]+}/{month:[0-9]+}/{day:[0-9]+}/", server.dueHandler).Methods("GET")
-	log.Fatal(http.ListenAndServe("localhost:"+"8080", router))
+	router.HandleFunc("/post/{id:[0-9]+}/restore", server.restorePostHandler).Methods("POST")
+	auth.allowAnonymous(router.HandleFunc("/post/{id:[0-9]+}/revisions/", server.listRevisionsHandler).Methods("GET"))
+	auth.allowAnonymous(router.HandleFunc("/post/{id:[0-9]+}/revisions/{n:[0-9]+}/", server.getRevisionHandler).Methods("GET"))
+	router.HandleFunc("/post/{id:[0-9]+}/revisions/{n:[0-9]+}/revert", server.revertHandler).Methods("POST")
+	auth.allowAnonymous(router.HandleFunc("/post/{id:[0-9]+}/diff", server.diffHandler).Methods("GET"))
+	router.HandleFunc("/trash/", server.trashHandler).Methods("GET")
+	router.HandleFunc("/trash/", adminOnly(server.purgeTrashHandler)).Methods("DELETE")
+	router.HandleFunc("/trash/{id:[0-9]+}/", server.getTrashedPostHandler).Methods("GET")
+	router.HandleFunc("/trash/{id:[0-9]+}/", adminOnly(server.purgePostHandler)).Methods("DELETE")
+	auth.allowAnonymous(router.HandleFunc("/tag/{tag}/", server.tagHandler).Methods("GET"))
+	auth.allowAnonymous(router.HandleFunc("/author/{author}/", server.getPostsByAuthor).Methods("GET"))
+	auth.allowAnonymous(router.HandleFunc("/due/", server.dueRangeHandler).Methods("GET"))
+	auth.allowAnonymous(router.HandleFunc("/due/{year:[0-9]+}/week/{week:[0-9]+}/", server.dueWeekHandler).Methods("GET"))
+	auth.allowAnonymous(router.HandleFunc("/due/{year:[0-9]+}/{month:[0-9]+}/", server.dueMonthHandler).Methods("GET"))
+	auth.allowAnonymous(router.HandleFunc("/due/{year:[0-9]+}/{month:[0-9]+}/{day:[0-9]+}/", server.dueHandler).Methods("GET"))
+	auth.allowAnonymous(router.HandleFunc("/overdue/", server.overdueHandler).Methods("GET"))
+	auth.allowAnonymous(router.HandleFunc("/calendar.ics", server.calendarHandler).Methods("GET"))
+	auth.allowAnonymous(router.HandleFunc("/author/{author}/calendar.ics", server.authorCalendarHandler).Methods("GET"))
+	auth.allowAnonymous(router.HandleFunc("/tag/{tag}/calendar.ics", server.tagCalendarHandler).Methods("GET"))
+	auth.allowAnonymous(router.HandleFunc("/author/{author}/feed.atom", server.authorAtomHandler).Methods("GET"))
+	auth.allowAnonymous(router.HandleFunc("/tag/{tag}/feed.atom", server.tagAtomHandler).Methods("GET"))
+	auth.allowAnonymous(router.HandleFunc("/author/{author}/feed.json", server.authorJSONFeedHandler).Methods("GET"))
+	auth.allowAnonymous(router.HandleFunc("/tag/{tag}/feed.json", server.tagJSONFeedHandler).Methods("GET"))
+	auth.allowAnonymous(router.HandleFunc("/events", server.eventsHandler).Methods("GET"))
+	auth.allowAnonymous(router.HandleFunc("/search", server.searchHandler).Methods("GET"))
+	router.HandleFunc("/webhooks/", adminOnly(server.createWebhookHandler)).Methods("POST")
+	router.HandleFunc("/webhooks/", adminOnly(server.listWebhooksHandler)).Methods("GET")
+	router.HandleFunc("/webhooks/dead-letters/", adminOnly(server.deadLettersHandler)).Methods("GET")
+	router.HandleFunc("/webhooks/deliveries/{id:[0-9]+}/retry", adminOnly(server.retryDeliveryHandler)).Methods("POST")
+	router.HandleFunc("/webhooks/{id:[0-9]+}/", adminOnly(server.getWebhookHandler)).Methods("GET")
+	router.HandleFunc("/webhooks/{id:[0-9]+}/", adminOnly(server.deleteWebhookHandler)).Methods("DELETE")
+	router.HandleFunc("/webhooks/{id:[0-9]+}/deliveries/", adminOnly(server.webhookDeliveriesHandler)).Methods("GET")
+	router.HandleFunc("/whoami", server.whoamiHandler).Methods("GET")
+	router.HandleFunc("/admin/keys/", adminOnly(server.createKeyHandler)).Methods("POST")
+	router.HandleFunc("/admin/keys/", adminOnly(server.listKeysHandler)).Methods("GET")
+	router.HandleFunc("/admin/keys/{id:[0-9a-f]+}/", adminOnly(server.getKeyHandler)).Methods("GET")
+	router.HandleFunc("/admin/keys/{id:[0-9a-f]+}/", adminOnly(server.deleteKeyHandler)).Methods("DELETE")
+	router.HandleFunc("/admin/log-level", adminOnly(server.getLogLevelHandler)).Methods("GET")
+	router.HandleFunc("/admin/log-level", adminOnly(server.setLogLevelHandler)).Methods("PUT")
+
+	// metrics are either on their own listener, to be kept off the
+	// public network, or behind the admin role
+	var metricsSrv *http.Server
+	if cfg.MetricsAddr != "" {
+		metricsMux := http.NewServeMux()
+		metricsMux.HandleFunc("/metrics", metricsHandler)
+		metricsSrv = &http.Server{Addr: cfg.MetricsAddr, Handler: metricsMux}
+		go func() {
+			if err := metricsSrv.ListenAndServe(); err != http.ErrServerClosed {
+				logs.Fatal("cannot serve metrics", "error", err)
+			}
+		}()
+	} else {
+		router.HandleFunc("/metrics", adminOnly(metricsHandler)).Methods("GET")
+	}
+
+	// SIGHUP reloads the settings that are safe to change while serving
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			reloadConfig(cfg, server)
+		}
+	}()
+
+	srv := &http.Server{
+		Addr:         cfg.Addr,
+		Handler:      logRequests(traceRequests(measureRequests(server.cors(router)))),
+		ReadTimeout:  cfg.ReadTimeout,
+		WriteTimeout: cfg.WriteTimeout,
+		IdleTimeout:  cfg.IdleTimeout,
+		ConnContext:  withConn,
+	}
+	// Shutdown does not wait for streams to end on their own
+	srv.RegisterOnShutdown(server.events.close)
+	// the store is closed by the deferred Close once in-flight requests
+	// have been drained
+	drained := make(chan struct{})
+	go func() {
+		defer close(drained)
+		sig := <-stop
+		logs.Info("shutting down", "signal", sig.String(), "delay", cfg.ShutdownDelay.String(), "timeout", cfg.ShutdownTimeout.String())
+		probes.drain()
+		time.Sleep(cfg.ShutdownDelay)
+		ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+		defer cancel()
+		if err := srv.Shutdown(ctx); err != nil {
+			logs.Error("requests still in flight at the shutdown deadline, closing their connections", "error", err)
+			srv.Close()
+		}
+		if metricsSrv != nil {
+			metricsSrv.Close()
+		}
+	}()
+	logs.Info("listening", "addr", cfg.Addr, "store", cfg.Store)
+	if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+		logs.Fatal("cannot serve", "error", err)
+	}
+	<-drained
 }
@@ -0,0 +1,188 @@
+package main
+
+import (
+	poststore "SimpleRest/store"
+	"context"
+	"time"
+)
+
+// measuredStore times every operation of a store, counts its errors and
+// traces it as part of the trace of its context. Changes and Close are
+// passed through.
+type measuredStore struct {
+	poststore.PostStoreManager
+}
+
+func measureStore(store poststore.PostStoreManager) poststore.PostStoreManager {
+	return measuredStore{store}
+}
+
+// observeStore starts measuring an operation made with ctx. The context it
+// returns carries the span of the operation; done is deferred with the
+// error being returned.
+func observeStore(ctx context.Context, method string) (_ context.Context, done func(err *error)) {
+	start := time.Now()
+	ctx, s := tracing.startChild(ctx, "store."+method, spanInternal)
+	if s != nil {
+		// the webhook deliveries of a write join its trace
+		ctx = poststore.WithTraceparent(ctx, s.traceparent())
+	}
+	return ctx, func(err *error) {
+		storeDuration.observe(time.Since(start).Seconds(), method)
+		if *err != nil {
+			storeErrors.inc(method, errorCode(storeErrorStatus(*err)))
+		}
+		s.SetError(*err)
+		s.End()
+	}
+}
+
+func (s measuredStore) CreatePost(ctx context.Context, text string, author string, tags []string, due time.Time, private bool, by string) (p poststore.Posts, err error) {
+	ctx, done := observeStore(ctx, "CreatePost")
+	defer done(&err)
+	return s.PostStoreManager.CreatePost(ctx, text, author, tags, due, private, by)
+}
+
+func (s measuredStore) GetPost(ctx context.Context, id int) (p poststore.Posts, err error) {
+	ctx, done := observeStore(ctx, "GetPost")
+	defer done(&err)
+	return s.PostStoreManager.GetPost(ctx, id)
+}
+
+func (s measuredStore) UpdatePost(ctx context.Context, id int, version int, text string, author string, tags []string, due time.Time, private bool, by string) (p poststore.Posts, err error) {
+	ctx, done := observeStore(ctx, "UpdatePost")
+	defer done(&err)
+	return s.PostStoreManager.UpdatePost(ctx, id, version, text, author, tags, due, private, by)
+}
+
+func (s measuredStore) DeletePost(ctx context.Context, id int, version int, by string) (err error) {
+	ctx, done := observeStore(ctx, "DeletePost")
+	defer done(&err)
+	return s.PostStoreManager.DeletePost(ctx, id, version, by)
+}
+
+func (s measuredStore) DeleteAllPosts(ctx context.Context, by string) (err error) {
+	ctx, done := observeStore(ctx, "DeleteAllPosts")
+	defer done(&err)
+	return s.PostStoreManager.DeleteAllPosts(ctx, by)
+}
+
+func (s measuredStore) GetTrashedPost(ctx context.Context, id int) (p poststore.Posts, err error) {
+	ctx, done := observeStore(ctx, "GetTrashedPost")
+	defer done(&err)
+	return s.PostStoreManager.GetTrashedPost(ctx, id)
+}
+
+func (s measuredStore) RestorePost(ctx context.Context, id int, version int, by string) (p poststore.Posts, err error) {
+	ctx, done := observeStore(ctx, "RestorePost")
+	defer done(&err)
+	return s.PostStoreManager.RestorePost(ctx, id, version, by)
+}
+
+func (s measuredStore) PurgePost(ctx context.Context, id int) (err error) {
+	ctx, done := observeStore(ctx, "PurgePost")
+	defer done(&err)
+	return s.PostStoreManager.PurgePost(ctx, id)
+}
+
+func (s measuredStore) PurgeTrash(ctx context.Context, before time.Time) (n int, err error) {
+	ctx, done := observeStore(ctx, "PurgeTrash")
+	defer done(&err)
+	return s.PostStoreManager.PurgeTrash(ctx, before)
+}
+
+func (s measuredStore) ListRevisions(ctx context.Context, id int, before int, limit int) (list []poststore.Revision, err error) {
+	ctx, done := observeStore(ctx, "ListRevisions")
+	defer done(&err)
+	return s.PostStoreManager.ListRevisions(ctx, id, before, limit)
+}
+
+func (s measuredStore) GetRevision(ctx context.Context, id int, number int) (r poststore.Revision, err error) {
+	ctx, done := observeStore(ctx, "GetRevision")
+	defer done(&err)
+	return s.PostStoreManager.GetRevision(ctx, id, number)
+}
+
+func (s measuredStore) ListPosts(ctx context.Context, f poststore.Filter, opts poststore.ListOptions) (page poststore.Page, err error) {
+	ctx, done := observeStore(ctx, "ListPosts")
+	defer done(&err)
+	return s.PostStoreManager.ListPosts(ctx, f, opts)
+}
+
+func (s measuredStore) CreateWebhook(ctx context.Context, h poststore.Webhook) (created poststore.Webhook, err error) {
+	ctx, done := observeStore(ctx, "CreateWebhook")
+	defer done(&err)
+	return s.PostStoreManager.CreateWebhook(ctx, h)
+}
+
+func (s measuredStore) GetWebhook(ctx context.Context, id int) (h poststore.Webhook, err error) {
+	ctx, done := observeStore(ctx, "GetWebhook")
+	defer done(&err)
+	return s.PostStoreManager.GetWebhook(ctx, id)
+}
+
+func (s measuredStore) ListWebhooks(ctx context.Context) (hooks []poststore.Webhook, err error) {
+	ctx, done := observeStore(ctx, "ListWebhooks")
+	defer done(&err)
+	return s.PostStoreManager.ListWebhooks(ctx)
+}
+
+func (s measuredStore) DeleteWebhook(ctx context.Context, id int) (err error) {
+	ctx, done := observeStore(ctx, "DeleteWebhook")
+	defer done(&err)
+	return s.PostStoreManager.DeleteWebhook(ctx, id)
+}
+
+func (s measuredStore) ClaimDeliveries(ctx context.Context, limit int, lease time.Duration) (batch []poststore.Delivery, err error) {
+	ctx, done := observeStore(ctx, "ClaimDeliveries")
+	defer done(&err)
+	return s.PostStoreManager.ClaimDeliveries(ctx, limit, lease)
+}
+
+func (s measuredStore) FinishDelivery(ctx context.Context, id int64, a poststore.Attempt) (err error) {
+	ctx, done := observeStore(ctx, "FinishDelivery")
+	defer done(&err)
+	return s.PostStoreManager.FinishDelivery(ctx, id, a)
+}
+
+func (s measuredStore) ListDeliveries(ctx context.Context, webhook int, status string, before int64, limit int) (list []poststore.Delivery, err error) {
+	ctx, done := observeStore(ctx, "ListDeliveries")
+	defer done(&err)
+	return s.PostStoreManager.ListDeliveries(ctx, webhook, status, before, limit)
+}
+
+func (s measuredStore) RetryDelivery(ctx context.Context, id int64) (d poststore.Delivery, err error) {
+	ctx, done := observeStore(ctx, "RetryDelivery")
+	defer done(&err)
+	return s.PostStoreManager.RetryDelivery(ctx, id)
+}
+
+func (s measuredStore) CreateAPIKey(ctx context.Context, k poststore.APIKey) (created poststore.APIKey, err error) {
+	ctx, done := observeStore(ctx, "CreateAPIKey")
+	defer done(&err)
+	return s.PostStoreManager.CreateAPIKey(ctx, k)
+}
+
+func (s measuredStore) GetAPIKey(ctx context.Context, id string) (k poststore.APIKey, err error) {
+	ctx, done := observeStore(ctx, "GetAPIKey")
+	defer done(&err)
+	return s.PostStoreManager.GetAPIKey(ctx, id)
+}
+
+func (s measuredStore) ListAPIKeys(ctx context.Context) (keys []poststore.APIKey, err error) {
+	ctx, done := observeStore(ctx, "ListAPIKeys")
+	defer done(&err)
+	return s.PostStoreManager.ListAPIKeys(ctx)
+}
+
+func (s measuredStore) DeleteAPIKey(ctx context.Context, id string) (err error) {
+	ctx, done := observeStore(ctx, "DeleteAPIKey")
+	defer done(&err)
+	return s.PostStoreManager.DeleteAPIKey(ctx, id)
+}
+
+func (s measuredStore) Ping(ctx context.Context) (err error) {
+	ctx, done := observeStore(ctx, "Ping")
+	defer done(&err)
+	return s.PostStoreManager.Ping(ctx)
+}
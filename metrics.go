@@ -0,0 +1,341 @@
+package main
+
+import (
+	poststore "SimpleRest/store"
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Metrics are served at /metrics in the Prometheus text format (version
+// 0.0.4). Counters and histograms are kept per combination of label
+// values; gauges are read when scraped.
+
+// latencyBuckets are the upper bounds, in seconds, of latency histograms.
+var latencyBuckets = []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// metric is one metric family.
+type metric interface {
+	write(w io.Writer)
+}
+
+type metricRegistry struct {
+	mu      sync.Mutex
+	metrics []metric
+}
+
+// registry holds the metrics of the process.
+var registry = &metricRegistry{}
+
+func (r *metricRegistry) register(m metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.metrics = append(r.metrics, m)
+}
+
+func (r *metricRegistry) counter(name, help string, labels ...string) *counterVec {
+	c := &counterVec{name: name, help: help, labels: labels, series: map[string]*counterSeries{}}
+	r.register(c)
+	return c
+}
+
+func (r *metricRegistry) histogram(name, help string, buckets []float64, labels ...string) *histogramVec {
+	h := &histogramVec{name: name, help: help, labels: labels, buckets: buckets, series: map[string]*histogramSeries{}}
+	r.register(h)
+	return h
+}
+
+// gauge registers a metric whose samples are collected when scraped. kind
+// is gauge or, for totals kept elsewhere, counter.
+func (r *metricRegistry) gauge(name, help, kind string, labels []string, collect func() []sample) {
+	r.register(&funcMetric{name: name, help: help, kind: kind, labels: labels, collect: collect})
+}
+
+func (r *metricRegistry) write(w io.Writer) {
+	r.mu.Lock()
+	metrics := append([]metric(nil), r.metrics...)
+	r.mu.Unlock()
+	for _, m := range metrics {
+		m.write(w)
+	}
+}
+
+// sample is one value of a gauge with its label values.
+type sample struct {
+	values []string
+	value  float64
+}
+
+type counterVec struct {
+	name, help string
+	labels     []string
+	mu         sync.Mutex
+	series     map[string]*counterSeries
+}
+
+type counterSeries struct {
+	values []string
+	value  float64
+}
+
+func (c *counterVec) inc(values ...string) {
+	c.add(1, values...)
+}
+
+func (c *counterVec) add(v float64, values ...string) {
+	key := strings.Join(values, "\xff")
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s, ok := c.series[key]
+	if !ok {
+		s = &counterSeries{values: values}
+		c.series[key] = s
+	}
+	s.value += v
+}
+
+func (c *counterVec) write(w io.Writer) {
+	writeHeader(w, c.name, c.help, "counter")
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	keys := make([]string, 0, len(c.series))
+	for key := range c.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		s := c.series[key]
+		fmt.Fprintf(w, "%s%s %s\n", c.name, labelSet(c.labels, s.values), formatFloat(s.value))
+	}
+}
+
+type histogramVec struct {
+	name, help string
+	labels     []string
+	buckets    []float64
+	mu         sync.Mutex
+	series     map[string]*histogramSeries
+}
+
+type histogramSeries struct {
+	values []string
+	// counts are per bucket, not cumulative; the last one is +Inf.
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+func (h *histogramVec) observe(v float64, values ...string) {
+	key := strings.Join(values, "\xff")
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogramSeries{values: values, counts: make([]uint64, len(h.buckets)+1)}
+		h.series[key] = s
+	}
+	s.counts[sort.SearchFloat64s(h.buckets, v)]++
+	s.sum += v
+	s.count++
+}
+
+func (h *histogramVec) write(w io.Writer) {
+	writeHeader(w, h.name, h.help, "histogram")
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	labels := append(append([]string(nil), h.labels...), "le")
+	keys := make([]string, 0, len(h.series))
+	for key := range h.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		s := h.series[key]
+		var cumulative uint64
+		for i, n := range s.counts {
+			cumulative += n
+			le := "+Inf"
+			if i < len(h.buckets) {
+				le = formatFloat(h.buckets[i])
+			}
+			values := append(append([]string(nil), s.values...), le)
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, labelSet(labels, values), cumulative)
+		}
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, labelSet(h.labels, s.values), formatFloat(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, labelSet(h.labels, s.values), s.count)
+	}
+}
+
+type funcMetric struct {
+	name, help, kind string
+	labels           []string
+	collect          func() []sample
+}
+
+func (m *funcMetric) write(w io.Writer) {
+	writeHeader(w, m.name, m.help, m.kind)
+	for _, s := range m.collect() {
+		fmt.Fprintf(w, "%s%s %s\n", m.name, labelSet(m.labels, s.values), formatFloat(s.value))
+	}
+}
+
+func writeHeader(w io.Writer, name, help, kind string) {
+	help = strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help)
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func labelSet(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(name)
+		b.WriteString(`="`)
+		if i < len(values) {
+			b.WriteString(labelEscaper.Replace(values[i]))
+		}
+		b.WriteByte('"')
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	httpRequests = registry.counter("http_requests_total",
+		"HTTP requests served, by method, route template and status code.",
+		"method", "route", "code")
+	httpDuration = registry.histogram("http_request_duration_seconds",
+		"Time to serve HTTP requests, by method and route template. Event streams count until they end.",
+		latencyBuckets, "method", "route")
+	httpInFlight int64
+
+	storeDuration = registry.histogram("store_operation_duration_seconds",
+		"Time taken by store operations, by method.",
+		latencyBuckets, "method")
+	storeErrors = registry.counter("store_operation_errors_total",
+		"Store operations that failed, by method and error code.",
+		"method", "error")
+)
+
+// processStart is reported as process_start_time_seconds.
+var processStart = time.Now()
+
+func init() {
+	registry.gauge("http_requests_in_flight", "HTTP requests being served.", "gauge", nil, func() []sample {
+		return []sample{{value: float64(atomic.LoadInt64(&httpInFlight))}}
+	})
+	registerRuntimeMetrics()
+}
+
+// measureRequests counts and times the requests served by next. The route
+// template is the one recorded by logRoute.
+func measureRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		start := time.Now()
+		atomic.AddInt64(&httpInFlight, 1)
+		defer atomic.AddInt64(&httpInFlight, -1)
+		sw := &statusWriter{ResponseWriter: w}
+		next.ServeHTTP(sw, req)
+
+		if sw.status == 0 {
+			sw.status = http.StatusOK
+		}
+		route := requestLogFrom(req.Context()).route
+		if route == "" {
+			route = "unmatched"
+		}
+		httpRequests.inc(req.Method, route, strconv.Itoa(sw.status))
+		httpDuration.observe(time.Since(start).Seconds(), req.Method, route)
+	})
+}
+
+func registerRuntimeMetrics() {
+	registry.gauge("go_info", "Version of Go the binary was built with.", "gauge", []string{"version"}, func() []sample {
+		return []sample{{values: []string{runtime.Version()}, value: 1}}
+	})
+	registry.gauge("go_goroutines", "Goroutines that currently exist.", "gauge", nil, func() []sample {
+		return []sample{{value: float64(runtime.NumGoroutine())}}
+	})
+	// one ReadMemStats serves every memory metric of a scrape
+	var mu sync.Mutex
+	var mem runtime.MemStats
+	var read time.Time
+	memStats := func() runtime.MemStats {
+		mu.Lock()
+		defer mu.Unlock()
+		if time.Since(read) > time.Second {
+			runtime.ReadMemStats(&mem)
+			read = time.Now()
+		}
+		return mem
+	}
+	registry.gauge("go_memstats_heap_alloc_bytes", "Bytes of allocated heap objects.", "gauge", nil, func() []sample {
+		return []sample{{value: float64(memStats().HeapAlloc)}}
+	})
+	registry.gauge("go_memstats_heap_objects", "Allocated heap objects.", "gauge", nil, func() []sample {
+		return []sample{{value: float64(memStats().HeapObjects)}}
+	})
+	registry.gauge("go_memstats_sys_bytes", "Bytes of memory obtained from the OS.", "gauge", nil, func() []sample {
+		return []sample{{value: float64(memStats().Sys)}}
+	})
+	registry.gauge("go_gc_cycles_total", "Completed GC cycles.", "counter", nil, func() []sample {
+		return []sample{{value: float64(memStats().NumGC)}}
+	})
+	registry.gauge("go_gc_pause_seconds_total", "Time the world was stopped for GC.", "counter", nil, func() []sample {
+		return []sample{{value: float64(memStats().PauseTotalNs) / 1e9}}
+	})
+	registry.gauge("process_start_time_seconds", "Start time of the process since the Unix epoch.", "gauge", nil, func() []sample {
+		return []sample{{value: float64(processStart.UnixNano()) / 1e9}}
+	})
+}
+
+// poolStater is implemented by stores with a connection pool.
+type poolStater interface {
+	PoolStats() poststore.PoolStats
+}
+
+func registerPoolMetrics(p poolStater) {
+	registry.gauge("db_pool_max_connections", "Size limit of the connection pool.", "gauge", nil, func() []sample {
+		return []sample{{value: float64(p.PoolStats().Max)}}
+	})
+	registry.gauge("db_pool_connections", "Open connections of the pool, by state.", "gauge", []string{"state"}, func() []sample {
+		s := p.PoolStats()
+		return []sample{
+			{values: []string{"idle"}, value: float64(s.Idle)},
+			{values: []string{"in_use"}, value: float64(s.Open - s.Idle)},
+		}
+	})
+}
+
+func metricsHandler(w http.ResponseWriter, req *http.Request) {
+	var b bytes.Buffer
+	registry.write(&b)
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write(b.Bytes())
+}
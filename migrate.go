@@ -0,0 +1,74 @@
+package main
+
+import (
+	poststore "SimpleRest/store"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+)
+
+// migrationConfig is pg without the statement cache: its statements cannot
+// be prepared until the migrations have created the tables they use. Nor
+// does it listen for changes, as nothing reads them.
+func migrationConfig(pg poststore.PgConfig) poststore.PgConfig {
+	pg.StatementCache = false
+	pg.Listen = false
+	return pg
+}
+
+// runMigrate implements the migrate subcommand against the Postgres store
+// described by pg: up applies pending migrations, down [n] reverts the last
+// n (default 1) and status lists them all.
+func runMigrate(pg poststore.PgConfig, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: migrate up|down [n]|status")
+	}
+	store, err := poststore.NewPg(migrationConfig(pg))
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	switch args[0] {
+	case "up":
+		ran, err := store.MigrateUp()
+		for _, m := range ran {
+			fmt.Printf("applied %d %s\n", m.Version, m.Name)
+		}
+		if err == nil && len(ran) == 0 {
+			fmt.Println("schema is up to date")
+		}
+		return err
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			if steps, err = strconv.Atoi(args[1]); err != nil || steps <= 0 {
+				return fmt.Errorf("migrate down expects a positive count, got %q", args[1])
+			}
+		}
+		ran, err := store.MigrateDown(steps)
+		for _, m := range ran {
+			fmt.Printf("reverted %d %s\n", m.Version, m.Name)
+		}
+		return err
+	case "status":
+		states, err := store.MigrationStatus()
+		if err != nil {
+			return err
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(tw, "VERSION\tNAME\tAPPLIED")
+		for _, s := range states {
+			applied := "pending"
+			if !s.AppliedAt.IsZero() {
+				applied = s.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Fprintf(tw, "%d\t%s\t%s\n", s.Version, s.Name, applied)
+		}
+		return tw.Flush()
+	default:
+		return fmt.Errorf("unknown migrate command %q, expect up, down or status", args[0])
+	}
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// errPatchTest is returned when a JSON Patch "test" operation fails.
+var errPatchTest = errors.New("test operation failed")
+
+// mergePatch applies an RFC 7396 JSON Merge Patch to doc.
+func mergePatch(doc, patch interface{}) interface{} {
+	p, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	d, ok := doc.(map[string]interface{})
+	if !ok {
+		d = map[string]interface{}{}
+	}
+	for k, v := range p {
+		if v == nil {
+			delete(d, k)
+			continue
+		}
+		d[k] = mergePatch(d[k], v)
+	}
+	return d
+}
+
+// patchOp is one operation of an RFC 6902 JSON Patch document. Value is
+// nil when the member is missing and holds null when it is null.
+type patchOp struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	From  string          `json:"from"`
+	Value json.RawMessage `json:"value"`
+}
+
+// jsonPatch applies an RFC 6902 JSON Patch to doc. The operations are
+// applied in order and the whole patch fails if any of them does.
+func jsonPatch(doc interface{}, ops []patchOp) (interface{}, error) {
+	var err error
+	for i, op := range ops {
+		var value interface{}
+		if op.Value != nil {
+			if err := json.Unmarshal(op.Value, &value); err != nil {
+				return nil, fmt.Errorf("operation %d: %s", i, err)
+			}
+		}
+		switch op.Op {
+		case "add":
+			if op.Value == nil {
+				return nil, fmt.Errorf("operation %d: add requires a value", i)
+			}
+			doc, err = pointerAdd(doc, op.Path, value)
+		case "remove":
+			doc, _, err = pointerRemove(doc, op.Path)
+		case "replace":
+			if op.Value == nil {
+				return nil, fmt.Errorf("operation %d: replace requires a value", i)
+			}
+			doc, _, err = pointerRemove(doc, op.Path)
+			if err == nil {
+				doc, err = pointerAdd(doc, op.Path, value)
+			}
+		case "move":
+			if strings.HasPrefix(op.Path, op.From+"/") {
+				return nil, fmt.Errorf("operation %d: cannot move %s into itself", i, op.From)
+			}
+			var moved interface{}
+			doc, moved, err = pointerRemove(doc, op.From)
+			if err == nil {
+				doc, err = pointerAdd(doc, op.Path, moved)
+			}
+		case "copy":
+			var copied interface{}
+			copied, err = pointerGet(doc, op.From)
+			if err == nil {
+				doc, err = pointerAdd(doc, op.Path, deepCopy(copied))
+			}
+		case "test":
+			if op.Value == nil {
+				return nil, fmt.Errorf("operation %d: test requires a value", i)
+			}
+			var got interface{}
+			got, err = pointerGet(doc, op.Path)
+			if err == nil && !reflect.DeepEqual(got, value) {
+				return nil, fmt.Errorf("operation %d: %w at %s", i, errPatchTest, op.Path)
+			}
+		default:
+			return nil, fmt.Errorf("operation %d: unknown op %q", i, op.Op)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("operation %d: %s", i, err)
+		}
+	}
+	return doc, nil
+}
+
+// splitPointer decodes an RFC 6901 JSON Pointer into its reference tokens.
+func splitPointer(ptr string) ([]string, error) {
+	if ptr == "" {
+		return nil, nil
+	}
+	if ptr[0] != '/' {
+		return nil, fmt.Errorf("pointer %q must start with /", ptr)
+	}
+	tokens := strings.Split(ptr[1:], "/")
+	for i, t := range tokens {
+		tokens[i] = strings.NewReplacer("~1", "/", "~0", "~").Replace(t)
+	}
+	return tokens, nil
+}
+
+// arrayIndex parses token as an index into an array of length n. end allows
+// the "-" token and n itself, as add does.
+func arrayIndex(token string, n int, end bool) (int, error) {
+	if end && token == "-" {
+		return n, nil
+	}
+	// RFC 6901 allows 0 or digits without a leading zero, no sign
+	if token == "" || token[0] < '0' || token[0] > '9' || (token != "0" && token[0] == '0') {
+		return 0, fmt.Errorf("bad array index %q", token)
+	}
+	i, err := strconv.Atoi(token)
+	if err != nil {
+		return 0, fmt.Errorf("bad array index %q", token)
+	}
+	if i > n || (!end && i == n) {
+		return 0, fmt.Errorf("array index %d out of range", i)
+	}
+	return i, nil
+}
+
+func pointerGet(doc interface{}, ptr string) (interface{}, error) {
+	tokens, err := splitPointer(ptr)
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range tokens {
+		switch node := doc.(type) {
+		case map[string]interface{}:
+			v, ok := node[t]
+			if !ok {
+				return nil, fmt.Errorf("path %s does not exist", ptr)
+			}
+			doc = v
+		case []interface{}:
+			i, err := arrayIndex(t, len(node), false)
+			if err != nil {
+				return nil, err
+			}
+			doc = node[i]
+		default:
+			return nil, fmt.Errorf("path %s does not exist", ptr)
+		}
+	}
+	return doc, nil
+}
+
+// pointerAdd returns doc with value added at ptr.
+func pointerAdd(doc interface{}, ptr string, value interface{}) (interface{}, error) {
+	tokens, err := splitPointer(ptr)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return value, nil
+	}
+	parent, err := pointerGet(doc, joinPointer(tokens[:len(tokens)-1]))
+	if err != nil {
+		return nil, err
+	}
+	last := tokens[len(tokens)-1]
+	switch node := parent.(type) {
+	case map[string]interface{}:
+		node[last] = value
+		return doc, nil
+	case []interface{}:
+		i, err := arrayIndex(last, len(node), true)
+		if err != nil {
+			return nil, err
+		}
+		node = append(node, nil)
+		copy(node[i+1:], node[i:])
+		node[i] = value
+		return setParent(doc, tokens[:len(tokens)-1], node)
+	}
+	return nil, fmt.Errorf("path %s does not exist", ptr)
+}
+
+// pointerRemove returns doc without the value at ptr, and that value.
+func pointerRemove(doc interface{}, ptr string) (interface{}, interface{}, error) {
+	tokens, err := splitPointer(ptr)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, doc, nil
+	}
+	parent, err := pointerGet(doc, joinPointer(tokens[:len(tokens)-1]))
+	if err != nil {
+		return nil, nil, err
+	}
+	last := tokens[len(tokens)-1]
+	switch node := parent.(type) {
+	case map[string]interface{}:
+		v, ok := node[last]
+		if !ok {
+			return nil, nil, fmt.Errorf("path %s does not exist", ptr)
+		}
+		delete(node, last)
+		return doc, v, nil
+	case []interface{}:
+		i, err := arrayIndex(last, len(node), false)
+		if err != nil {
+			return nil, nil, err
+		}
+		v := node[i]
+		node = append(node[:i:i], node[i+1:]...)
+		doc, err = setParent(doc, tokens[:len(tokens)-1], node)
+		return doc, v, err
+	}
+	return nil, nil, fmt.Errorf("path %s does not exist", ptr)
+}
+
+// setParent stores a resized array back at the location named by tokens.
+func setParent(doc interface{}, tokens []string, node []interface{}) (interface{}, error) {
+	if len(tokens) == 0 {
+		return node, nil
+	}
+	grand, err := pointerGet(doc, joinPointer(tokens[:len(tokens)-1]))
+	if err != nil {
+		return nil, err
+	}
+	last := tokens[len(tokens)-1]
+	switch g := grand.(type) {
+	case map[string]interface{}:
+		g[last] = node
+	case []interface{}:
+		i, err := arrayIndex(last, len(g), false)
+		if err != nil {
+			return nil, err
+		}
+		g[i] = node
+	}
+	return doc, nil
+}
+
+func joinPointer(tokens []string) string {
+	var b strings.Builder
+	for _, t := range tokens {
+		b.WriteByte('/')
+		b.WriteString(strings.NewReplacer("~", "~0", "/", "~1").Replace(t))
+	}
+	return b.String()
+}
+
+func deepCopy(v interface{}) interface{} {
+	switch node := v.(type) {
+	case map[string]interface{}:
+		c := make(map[string]interface{}, len(node))
+		for k, e := range node {
+			c[k] = deepCopy(e)
+		}
+		return c
+	case []interface{}:
+		c := make([]interface{}, len(node))
+		for i, e := range node {
+			c[i] = deepCopy(e)
+		}
+		return c
+	}
+	return v
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	poststore "SimpleRest/store"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// decode parses the JSON s, failing t if it is malformed.
+func decode(t *testing.T, s string) interface{} {
+	t.Helper()
+	var v interface{}
+	if err := json.Unmarshal([]byte(s), &v); err != nil {
+		t.Fatalf("%s: %v", s, err)
+	}
+	return v
+}
+
+// TestMergePatch runs the examples of RFC 7396 appendix A.
+func TestMergePatch(t *testing.T) {
+	tests := []struct {
+		doc, patch, want string
+	}{
+		{`{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+		{`{"a":"b"}`, `{"a":null}`, `{}`},
+		{`{"a":"b","b":"c"}`, `{"a":null}`, `{"b":"c"}`},
+		{`{"a":["b"]}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"c"}`, `{"a":["b"]}`, `{"a":["b"]}`},
+		{`{"a":{"b":"c"}}`, `{"a":{"b":"d","c":null}}`, `{"a":{"b":"d"}}`},
+		{`{"a":[{"b":"c"}]}`, `{"a":[1]}`, `{"a":[1]}`},
+		{`["a","b"]`, `["c","d"]`, `["c","d"]`},
+		{`{"a":"b"}`, `["c"]`, `["c"]`},
+		{`{"a":"foo"}`, `null`, `null`},
+		{`{"a":"foo"}`, `"bar"`, `"bar"`},
+		{`{"e":null}`, `{"a":1}`, `{"e":null,"a":1}`},
+		{`[1,2]`, `{"a":"b","c":null}`, `{"a":"b"}`},
+		{`{}`, `{"a":{"bb":{"ccc":null}}}`, `{"a":{"bb":{}}}`},
+	}
+	for _, tt := range tests {
+		got := mergePatch(decode(t, tt.doc), decode(t, tt.patch))
+		if want := decode(t, tt.want); !reflect.DeepEqual(got, want) {
+			t.Errorf("merge %s into %s = %v, want %v", tt.patch, tt.doc, got, want)
+		}
+	}
+}
+
+func TestJSONPatch(t *testing.T) {
+	tests := []struct {
+		name, doc, patch string
+		// want is the patched document, empty when the patch fails
+		want string
+		// failedTest is set when it fails on a test operation
+		failedTest bool
+	}{
+		// RFC 6902 appendix A
+		{"A.1 add an object member", `{"foo":"bar"}`, `[{"op":"add","path":"/baz","value":"qux"}]`, `{"baz":"qux","foo":"bar"}`, false},
+		{"A.2 add an array element", `{"foo":["bar","baz"]}`, `[{"op":"add","path":"/foo/1","value":"qux"}]`, `{"foo":["bar","qux","baz"]}`, false},
+		{"A.3 remove an object member", `{"baz":"qux","foo":"bar"}`, `[{"op":"remove","path":"/baz"}]`, `{"foo":"bar"}`, false},
+		{"A.4 remove an array element", `{"foo":["bar","qux","baz"]}`, `[{"op":"remove","path":"/foo/1"}]`, `{"foo":["bar","baz"]}`, false},
+		{"A.5 replace a value", `{"baz":"qux","foo":"bar"}`, `[{"op":"replace","path":"/baz","value":"boo"}]`, `{"baz":"boo","foo":"bar"}`, false},
+		{"A.6 move a value", `{"foo":{"bar":"baz","waldo":"fred"},"qux":{"corge":"grault"}}`, `[{"op":"move","from":"/foo/waldo","path":"/qux/thud"}]`, `{"foo":{"bar":"baz"},"qux":{"corge":"grault","thud":"fred"}}`, false},
+		{"A.7 move an array element", `{"foo":["all","grass","cows","eat"]}`, `[{"op":"move","from":"/foo/1","path":"/foo/3"}]`, `{"foo":["all","cows","eat","grass"]}`, false},
+		{"A.8 test a value", `{"baz":"qux","foo":["a",2,"c"]}`, `[{"op":"test","path":"/baz","value":"qux"},{"op":"test","path":"/foo/1","value":2}]`, `{"baz":"qux","foo":["a",2,"c"]}`, false},
+		{"A.9 test a value error", `{"baz":"qux"}`, `[{"op":"test","path":"/baz","value":"bar"}]`, ``, true},
+		{"A.10 add a nested member object", `{"foo":"bar"}`, `[{"op":"add","path":"/child","value":{"grandchild":{}}}]`, `{"foo":"bar","child":{"grandchild":{}}}`, false},
+		{"A.11 ignore unrecognized elements", `{"foo":"bar"}`, `[{"op":"add","path":"/baz","value":"qux","xyz":123}]`, `{"foo":"bar","baz":"qux"}`, false},
+		{"A.12 add to a nonexistent target", `{"foo":"bar"}`, `[{"op":"add","path":"/baz/bat","value":"qux"}]`, ``, false},
+		{"A.14 escape ordering", `{"/":9,"~1":10}`, `[{"op":"test","path":"/~01","value":10}]`, `{"/":9,"~1":10}`, false},
+		{"A.15 compare strings and numbers", `{"/":9,"~1":10}`, `[{"op":"test","path":"/~01","value":"10"}]`, ``, true},
+		{"A.16 add an array value", `{"foo":["bar"]}`, `[{"op":"add","path":"/foo/-","value":["abc","def"]}]`, `{"foo":["bar",["abc","def"]]}`, false},
+
+		// pointers
+		{"escaped slash", `{"a/b":1}`, `[{"op":"replace","path":"/a~1b","value":2}]`, `{"a/b":2}`, false},
+		{"escaped tilde", `{"m~n":1}`, `[{"op":"remove","path":"/m~0n"}]`, `{}`, false},
+		{"whole document", `{"a":1}`, `[{"op":"replace","path":"","value":[1]}]`, `[1]`, false},
+		{"pointer without slash", `{"a":1}`, `[{"op":"remove","path":"a"}]`, ``, false},
+		{"leading zero index", `{"a":[1,2]}`, `[{"op":"remove","path":"/a/01"}]`, ``, false},
+		{"plus sign index", `{"a":[1,2]}`, `[{"op":"remove","path":"/a/+1"}]`, ``, false},
+		{"minus zero index", `{"a":[1,2]}`, `[{"op":"remove","path":"/a/-0"}]`, ``, false},
+		{"end of array to remove", `{"a":[1,2]}`, `[{"op":"remove","path":"/a/-"}]`, ``, false},
+		{"index past the end", `{"a":[1,2]}`, `[{"op":"add","path":"/a/3","value":3}]`, ``, false},
+		{"index at the end", `{"a":[1,2]}`, `[{"op":"add","path":"/a/2","value":3}]`, `{"a":[1,2,3]}`, false},
+
+		// arrays resized inside other values go back in place
+		{"insert in a nested array", `{"a":{"b":[1,3]}}`, `[{"op":"add","path":"/a/b/1","value":2}]`, `{"a":{"b":[1,2,3]}}`, false},
+		{"insert in an array of arrays", `[[1],[2]]`, `[{"op":"add","path":"/1/0","value":0}]`, `[[1],[0,2]]`, false},
+		{"remove from an array of arrays", `[[1],[2,3]]`, `[{"op":"remove","path":"/1/1"}]`, `[[1],[2]]`, false},
+		{"move between arrays", `{"a":[1,2],"b":[]}`, `[{"op":"move","from":"/a/0","path":"/b/-"}]`, `{"a":[2],"b":[1]}`, false},
+
+		// operations
+		{"move into itself", `{"a":{"b":{}}}`, `[{"op":"move","from":"/a","path":"/a/b/c"}]`, ``, false},
+		{"move onto itself", `{"a":1}`, `[{"op":"move","from":"/a","path":"/a"}]`, `{"a":1}`, false},
+		{"copy is deep", `{"a":{"b":1}}`, `[{"op":"copy","from":"/a","path":"/c"},{"op":"replace","path":"/c/b","value":2}]`, `{"a":{"b":1},"c":{"b":2}}`, false},
+		{"add without a value", `{}`, `[{"op":"add","path":"/a"}]`, ``, false},
+		{"replace without a value", `{"a":1}`, `[{"op":"replace","path":"/a"}]`, ``, false},
+		{"test without a value", `{"a":null}`, `[{"op":"test","path":"/a"}]`, ``, false},
+		{"test null", `{"a":null}`, `[{"op":"test","path":"/a","value":null}]`, `{"a":null}`, false},
+		{"test a missing path", `{}`, `[{"op":"test","path":"/a","value":1}]`, ``, false},
+		{"unknown op", `{}`, `[{"op":"frobnicate","path":"/a"}]`, ``, false},
+		{"all or nothing", `{"a":1}`, `[{"op":"remove","path":"/a"},{"op":"test","path":"/a","value":1}]`, ``, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var ops []patchOp
+			if err := json.Unmarshal([]byte(tt.patch), &ops); err != nil {
+				t.Fatal(err)
+			}
+			got, err := jsonPatch(decode(t, tt.doc), ops)
+			if tt.want == "" {
+				if err == nil {
+					t.Fatalf("patch = %v, want an error", got)
+				}
+				if errors.Is(err, errPatchTest) != tt.failedTest {
+					t.Errorf("error %q, want a failed test %v", err, tt.failedTest)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := decode(t, tt.want); !reflect.DeepEqual(got, want) {
+				t.Errorf("patch = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestApplyPatch(t *testing.T) {
+	due := time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC)
+	current := poststore.Posts{ID: 1, Text: "first", Author: "alice", Tags: []string{"a", "b"}, Due: due, Version: 2}
+	tests := []struct {
+		name, mediatype, body string
+		status                int
+		want                  requestPost
+	}{
+		{"merge", "application/merge-patch+json", `{"text":"second","tags":null}`, 0, requestPost{ID: 1, Text: "second", Author: "alice", Due: due, Version: 2}},
+		{"json patch", "application/json-patch+json", `[{"op":"test","path":"/tags/0","value":"a"},{"op":"remove","path":"/tags/0"}]`, 0, requestPost{ID: 1, Text: "first", Author: "alice", Tags: []string{"b"}, Due: due, Version: 2}},
+		{"failed test", "application/json-patch+json", `[{"op":"test","path":"/text","value":"other"}]`, http.StatusConflict, requestPost{}},
+		{"bad pointer", "application/json-patch+json", `[{"op":"remove","path":"/tags/+1"}]`, http.StatusUnprocessableEntity, requestPost{}},
+		{"malformed", "application/json-patch+json", `{"op":"remove"}`, http.StatusBadRequest, requestPost{}},
+		{"id", "application/merge-patch+json", `{"id":2}`, http.StatusUnprocessableEntity, requestPost{}},
+		{"version", "application/json-patch+json", `[{"op":"replace","path":"/version","value":3}]`, http.StatusUnprocessableEntity, requestPost{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, status, err := applyPatch(current, tt.mediatype, []byte(tt.body))
+			if status != tt.status {
+				t.Fatalf("status %d (%v), want %d", status, err, tt.status)
+			}
+			if tt.status != 0 {
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Text != tt.want.Text || got.Author != tt.want.Author || !reflect.DeepEqual(got.Tags, tt.want.Tags) || !got.Due.Equal(tt.want.Due) {
+				t.Errorf("patched post = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
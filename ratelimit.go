@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// rateLimiter keeps a token bucket per client address. A bucket fills at
+// the rate limit up to the burst, and every request takes a token from it.
+type rateLimiter struct {
+	mux     sync.Mutex
+	buckets map[string]*bucket
+	// swept is when full buckets were last dropped.
+	swept time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter() *rateLimiter {
+	return &rateLimiter{buckets: make(map[string]*bucket)}
+}
+
+// allow takes a token from the bucket of client, which fills perMinute
+// tokens a minute up to burst. When it is empty it returns how long until
+// it has a token again.
+func (l *rateLimiter) allow(client string, perMinute, burst int, now time.Time) (bool, time.Duration) {
+	rate := float64(perMinute) / 60
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	// a bucket that has filled up is the same as none
+	if now.Sub(l.swept) > time.Minute {
+		for k, b := range l.buckets {
+			if b.tokens+now.Sub(b.last).Seconds()*rate >= float64(burst) {
+				delete(l.buckets, k)
+			}
+		}
+		l.swept = now
+	}
+
+	b := l.buckets[client]
+	if b == nil {
+		b = &bucket{tokens: float64(burst), last: now}
+		l.buckets[client] = b
+	}
+	b.tokens = math.Min(float64(burst), b.tokens+now.Sub(b.last).Seconds()*rate)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) / rate * float64(time.Second))
+}
+
+// limitRate answers 429 Too Many Requests to the clients that go over the
+// rate limit, counted by remote address: behind a proxy they all share
+// one. A zero limit turns it off.
+func (ps *postStore) limitRate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		o := ps.options()
+		if o.rateLimit == 0 {
+			next.ServeHTTP(w, req)
+			return
+		}
+		client, _, err := net.SplitHostPort(req.RemoteAddr)
+		if err != nil {
+			client = req.RemoteAddr
+		}
+		ok, wait := ps.limiter.allow(client, o.rateLimit, o.rateBurst, time.Now())
+		if !ok {
+			secs := int(math.Ceil(wait.Seconds()))
+			w.Header().Set("Retry-After", strconv.Itoa(secs))
+			renderError(w, http.StatusTooManyRequests, fmt.Sprintf("more than %d requests a minute, retry in %ds", o.rateLimit, secs))
+			return
+		}
+		next.ServeHTTP(w, req)
+	})
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	l := newRateLimiter()
+	start := time.Date(2026, 11, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		client string
+		after  time.Duration
+		ok     bool
+		wait   time.Duration
+	}{
+		// 60 a minute is one a second, three at once
+		{"a", 0, true, 0},
+		{"a", 0, true, 0},
+		{"a", 0, true, 0},
+		{"a", 0, false, time.Second},
+		{"b", 0, true, 0},
+		{"a", 500 * time.Millisecond, false, 500 * time.Millisecond},
+		{"a", time.Second, true, 0},
+		{"a", time.Second, false, time.Second},
+		// the bucket is full again after three seconds, and no fuller
+		// after longer
+		{"a", time.Hour, true, 0},
+		{"a", time.Hour, true, 0},
+		{"a", time.Hour, true, 0},
+		{"a", time.Hour, false, time.Second},
+	}
+	for i, tt := range tests {
+		ok, wait := l.allow(tt.client, 60, 3, start.Add(tt.after))
+		if ok != tt.ok || wait != tt.wait {
+			t.Errorf("request %d of %s after %s = %v, %s, want %v, %s", i, tt.client, tt.after, ok, wait, tt.ok, tt.wait)
+		}
+	}
+	if len(l.buckets) != 1 {
+		t.Errorf("%d buckets are kept, want the one of a that is not full", len(l.buckets))
+	}
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	poststore "SimpleRest/store"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// revisionDiff is what changed from one revision of a post to another.
+type revisionDiff struct {
+	From int `json:"from"`
+	To   int `json:"to"`
+	// By is how the text was split, into lines or words.
+	By string `json:"by"`
+	// Fields are the other fields that changed, with both values.
+	Fields map[string]fieldChange `json:"fields"`
+	Text   []diffOp               `json:"text"`
+}
+
+type fieldChange struct {
+	From interface{} `json:"from"`
+	To   interface{} `json:"to"`
+}
+
+// revisedPost returns post id, live or in the trash, if the caller may see
+// its history: anyone who may read it while it is live, those who may
+// restore it once it is deleted.
+func (ps *postStore) revisedPost(req *http.Request, id int) (poststore.Posts, error) {
+	actor := actorFrom(req)
+	post, err := ps.store.GetPost(req.Context(), id)
+	if err == nil && !actor.CanRead(post) {
+		err = fmt.Errorf("post %d %w", id, poststore.ErrNotFound)
+	}
+	if err == nil || !errors.Is(err, poststore.ErrNotFound) {
+		return post, err
+	}
+	post, err = ps.store.GetTrashedPost(req.Context(), id)
+	if err == nil {
+		err = checkChange(actor, post, post.Author)
+	}
+	if errors.Is(err, poststore.ErrNotFound) {
+		err = fmt.Errorf("post %d %w", id, poststore.ErrNotFound)
+	}
+	return post, err
+}
+
+// revision returns revision n of post id if the caller may read it: a
+// revision that was private is hidden like the post was then.
+func (ps *postStore) revision(req *http.Request, id, n int) (poststore.Revision, error) {
+	rev, err := ps.store.GetRevision(req.Context(), id, n)
+	if err == nil && !actorFrom(req).CanRead(rev.Post) {
+		err = fmt.Errorf("revision %d of post %d %w", n, id, poststore.ErrNotFound)
+	}
+	return rev, err
+}
+
+// listRevisionsHandler answers GET /post/{id}/revisions/ with a page of
+// the revisions of the post, newest first, chosen by the limit and before
+// query parameters, with a Link to the next page. Revisions the caller may
+// not read are left out, so a page can be short.
+func (ps *postStore) listRevisionsHandler(w http.ResponseWriter, req *http.Request) {
+	id, _ := strconv.Atoi(mux.Vars(req)["id"])
+	if _, err := ps.revisedPost(req, id); err != nil {
+		renderStoreError(w, err)
+		return
+	}
+	q := req.URL.Query()
+	limit, ok := ps.pageLimit(w, q)
+	if !ok {
+		return
+	}
+	var before int
+	if v := q.Get("before"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			renderError(w, http.StatusBadRequest, fmt.Sprintf("before must be a revision number, got %q", v))
+			return
+		}
+		before = n
+	}
+
+	list, err := ps.store.ListRevisions(req.Context(), id, before, limit)
+	if err != nil {
+		renderStoreError(w, err)
+		return
+	}
+	if len(list) == limit {
+		u := *req.URL
+		q.Set("before", strconv.Itoa(list[len(list)-1].Number))
+		u.RawQuery = q.Encode()
+		w.Header().Set("Link", fmt.Sprintf(`<%s>; rel="next"`, u.RequestURI()))
+	}
+	actor := actorFrom(req)
+	visible := []poststore.Revision{}
+	for _, rev := range list {
+		if actor.CanRead(rev.Post) {
+			visible = append(visible, rev)
+		}
+	}
+	renderJSON(w, visible)
+}
+
+func (ps *postStore) getRevisionHandler(w http.ResponseWriter, req *http.Request) {
+	id, _ := strconv.Atoi(mux.Vars(req)["id"])
+	n, _ := strconv.Atoi(mux.Vars(req)["n"])
+	if _, err := ps.revisedPost(req, id); err != nil {
+		renderStoreError(w, err)
+		return
+	}
+	rev, err := ps.revision(req, id, n)
+	if err != nil {
+		renderStoreError(w, err)
+		return
+	}
+	renderJSON(w, rev)
+}
+
+// diffHandler answers GET /post/{id}/diff with the changes from revision
+// from to revision to, by default the latest and the one before. Revision 0
+// is no post at all. by=line (the default) or by=word chooses how the text
+// is compared.
+func (ps *postStore) diffHandler(w http.ResponseWriter, req *http.Request) {
+	id, _ := strconv.Atoi(mux.Vars(req)["id"])
+	current, err := ps.revisedPost(req, id)
+	if err != nil {
+		renderStoreError(w, err)
+		return
+	}
+	q := req.URL.Query()
+	d := revisionDiff{To: current.Version, By: q.Get("by"), Fields: map[string]fieldChange{}}
+	split := splitLines
+	switch d.By {
+	case "", "line":
+		d.By = "line"
+	case "word":
+		split = splitWords
+	default:
+		renderError(w, http.StatusBadRequest, fmt.Sprintf("by must be line or word, got %q", d.By))
+		return
+	}
+	for _, p := range []struct {
+		name string
+		n    *int
+		min  int
+	}{{"to", &d.To, 1}, {"from", &d.From, 0}} {
+		v := q.Get(p.name)
+		if v == "" {
+			continue
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil || n < p.min {
+			renderError(w, http.StatusBadRequest, fmt.Sprintf("%s must be a revision number, got %q", p.name, v))
+			return
+		}
+		*p.n = n
+	}
+	if q.Get("from") == "" {
+		d.From = d.To - 1
+	}
+
+	var from, to poststore.Posts
+	for _, r := range []struct {
+		n    int
+		post *poststore.Posts
+	}{{d.To, &to}, {d.From, &from}} {
+		if r.n == 0 {
+			continue
+		}
+		rev, err := ps.revision(req, id, r.n)
+		if err != nil {
+			renderStoreError(w, err)
+			return
+		}
+		*r.post = rev.Post
+	}
+
+	for _, f := range []struct {
+		name     string
+		from, to interface{}
+		changed  bool
+	}{
+		{"author", from.Author, to.Author, from.Author != to.Author},
+		{"tags", from.Tags, to.Tags, !reflect.DeepEqual(from.Tags, to.Tags)},
+		{"due", from.Due, to.Due, !from.Due.Equal(to.Due)},
+		{"private", from.Private, to.Private, from.Private != to.Private},
+	} {
+		switch {
+		case d.From == 0:
+			// revision 0 has none of the fields
+			d.Fields[f.name] = fieldChange{To: f.to}
+		case f.changed:
+			d.Fields[f.name] = fieldChange{From: f.from, To: f.to}
+		}
+	}
+	d.Text = diff(split(from.Text), split(to.Text))
+	renderJSON(w, d)
+}
+
+// revertHandler answers POST /post/{id}/revisions/{n}/revert by writing
+// the fields of revision n over the post, as a new revision, for those who
+// may update it.
+func (ps *postStore) revertHandler(w http.ResponseWriter, req *http.Request) {
+	id, _ := strconv.Atoi(mux.Vars(req)["id"])
+	n, _ := strconv.Atoi(mux.Vars(req)["n"])
+
+	version, ok := ps.expectedVersion(w, req, id)
+	if !ok {
+		return
+	}
+	rev, err := ps.revision(req, id, n)
+	if err != nil {
+		renderStoreError(w, err)
+		return
+	}
+	old := rev.Post
+	actor := actorFrom(req)
+	post, ok := ps.writePost(w, req, id, version, ps.store.GetPost, func(current poststore.Posts) (poststore.Posts, error) {
+		if err := checkChange(actor, current, old.Author); err != nil {
+			return poststore.Posts{}, err
+		}
+		return ps.store.UpdatePost(req.Context(), id, current.Version, old.Text, old.Author, old.Tags, old.Due, old.Private, actor.Name)
+	})
+	if !ok {
+		return
+	}
+	w.Header().Set("ETag", etag(post))
+	renderJSON(w, post)
+}
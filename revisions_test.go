@@ -0,0 +1,140 @@
+package main
+
+import (
+	poststore "SimpleRest/store"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// revisionsServer serves the revision routes of a new memory store to the
+// writers alice and bob, whose API keys are sr_<name>_secret.
+func revisionsServer(t *testing.T) (*postStore, http.Handler) {
+	store := poststore.New()
+	for _, name := range []string{"alice", "bob"} {
+		hash := sha256.Sum256([]byte("secret"))
+		k := poststore.APIKey{ID: name, Name: name, Role: poststore.RoleWriter, Hash: hash[:]}
+		if _, err := store.CreateAPIKey(context.Background(), k); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ps := NewPostServer(store)
+	auth := newAuthenticator(store, "", jwtConfig{})
+	router := mux.NewRouter()
+	router.Use(auth.middleware)
+	auth.allowAnonymous(router.HandleFunc("/post/{id:[0-9]+}/revisions/", ps.listRevisionsHandler).Methods("GET"))
+	auth.allowAnonymous(router.HandleFunc("/post/{id:[0-9]+}/revisions/{n:[0-9]+}/", ps.getRevisionHandler).Methods("GET"))
+	router.HandleFunc("/post/{id:[0-9]+}/revisions/{n:[0-9]+}/revert", ps.revertHandler).Methods("POST")
+	return ps, router
+}
+
+// serve makes a request as user, anonymous when empty, and decodes the
+// response body into v unless it is nil.
+func serve(t *testing.T, h http.Handler, method, path, user string, header http.Header, v interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, path, nil)
+	for k, vs := range header {
+		req.Header[k] = vs
+	}
+	if user != "" {
+		req.Header.Set("X-API-Key", "sr_"+user+"_secret")
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if v != nil && w.Code < 300 {
+		if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+			t.Fatalf("%s %s: %v", method, path, err)
+		}
+	}
+	return w
+}
+
+func TestRevert(t *testing.T) {
+	ps, h := revisionsServer(t)
+	ctx := context.Background()
+	due := time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC)
+	first, err := ps.store.CreatePost(ctx, "first", "alice", []string{"a"}, due, false, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ps.store.UpdatePost(ctx, first.ID, 1, "second", "alice", []string{"b"}, due.Add(time.Hour), false, "alice"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		user   string
+		path   string
+		header http.Header
+		status int
+	}{
+		{"anonymous", "", "/post/1/revisions/1/revert", nil, http.StatusUnauthorized},
+		{"not the author", "bob", "/post/1/revisions/1/revert", nil, http.StatusForbidden},
+		{"unknown revision", "alice", "/post/1/revisions/9/revert", nil, http.StatusNotFound},
+		{"unknown post", "alice", "/post/9/revisions/1/revert", nil, http.StatusNotFound},
+		{"stale If-Match", "alice", "/post/1/revisions/1/revert", http.Header{"If-Match": {`"1-1"`}}, http.StatusPreconditionFailed},
+		{"current If-Match", "alice", "/post/1/revisions/1/revert", http.Header{"If-Match": {`"1-2"`}}, http.StatusOK},
+		// the revert made version 3, so 2 is stale now
+		{"after a write", "alice", "/post/1/revisions/2/revert", http.Header{"If-Match": {`"1-2"`}}, http.StatusPreconditionFailed},
+		{"without If-Match", "alice", "/post/1/revisions/2/revert", nil, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if w := serve(t, h, "POST", tt.path, tt.user, tt.header, nil); w.Code != tt.status {
+				t.Errorf("status %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+		})
+	}
+
+	post, err := ps.store.GetPost(ctx, first.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if post.Version != 4 || post.Text != "second" || post.Tags[0] != "b" || !post.Due.Equal(due.Add(time.Hour)) {
+		t.Errorf("post is %+v, want version 4 with the fields of revision 2", post)
+	}
+	var revs []poststore.Revision
+	serve(t, h, "GET", "/post/1/revisions/", "", nil, &revs)
+	var texts []string
+	for _, rev := range revs {
+		texts = append(texts, rev.Post.Text)
+		if rev.By != "alice" {
+			t.Errorf("revision %d is by %q, want alice", rev.Number, rev.By)
+		}
+	}
+	if want := []string{"second", "first", "second", "first"}; !reflect.DeepEqual(texts, want) {
+		t.Errorf("revisions have texts %q, want %q", texts, want)
+	}
+}
+
+func TestPrivateRevisions(t *testing.T) {
+	ps, h := revisionsServer(t)
+	ctx := context.Background()
+	due := time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC)
+	post, err := ps.store.CreatePost(ctx, "draft", "alice", nil, due, true, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ps.store.UpdatePost(ctx, post.ID, 1, "published", "alice", nil, due, false, "alice"); err != nil {
+		t.Fatal(err)
+	}
+
+	if w := serve(t, h, "GET", "/post/1/revisions/1/", "bob", nil, nil); w.Code != http.StatusNotFound {
+		t.Errorf("bob reads the private revision: %d %s", w.Code, w.Body)
+	}
+	if w := serve(t, h, "GET", "/post/1/revisions/1/", "alice", nil, nil); w.Code != http.StatusOK {
+		t.Errorf("alice cannot read her private revision: %d %s", w.Code, w.Body)
+	}
+	var revs []poststore.Revision
+	serve(t, h, "GET", "/post/1/revisions/", "", nil, &revs)
+	if len(revs) != 1 || revs[0].Number != 2 {
+		t.Errorf("anonymous callers list %+v, want revision 2 only", revs)
+	}
+}
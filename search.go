@@ -0,0 +1,49 @@
+package main
+
+import (
+	poststore "SimpleRest/store"
+	"net/http"
+)
+
+// snippetWords is the length of the snippets of search results.
+const snippetWords = 30
+
+// searchHit is one result of a search: the post, how well it matched and
+// the part of its text that matched, as HTML.
+type searchHit struct {
+	poststore.Posts
+	Rank    float64 `json:"rank"`
+	Snippet string  `json:"snippet"`
+}
+
+// searchHandler answers GET /search?q= with the posts matching the search,
+// best first unless sorted otherwise. It takes the filter and paging
+// parameters of GET /post/.
+func (ps *postStore) searchHandler(w http.ResponseWriter, req *http.Request) {
+	q := req.URL.Query()
+	if q.Get("q") == "" {
+		renderError(w, http.StatusBadRequest, "q is required")
+		return
+	}
+	search, err := poststore.ParseSearch(q.Get("q"))
+	if err != nil {
+		renderStoreError(w, err)
+		return
+	}
+	f, err := parseFilter(q)
+	if err != nil {
+		renderError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	f.Search = search
+
+	page, ok := ps.listPage(w, req, f, "-rank")
+	if !ok {
+		return
+	}
+	hits := make([]searchHit, len(page.Posts))
+	for i, p := range page.Posts {
+		hits[i] = searchHit{Posts: p, Rank: p.Rank, Snippet: search.Snippet(p.Text, snippetWords)}
+	}
+	renderJSON(w, hits)
+}
@@ -0,0 +1,92 @@
+package taskstore
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// Change types published on a ChangeBus.
+const (
+	Created = "created"
+	Updated = "updated"
+	Deleted = "deleted"
+)
+
+// Change is a committed write to one post. Post is the post after the
+// change, or as it was before a delete.
+type Change struct {
+	Type string
+	Post Posts
+	Time time.Time
+}
+
+// ChangeBus fans the changes committed by a store out to in-process
+// subscribers and listeners.
+type ChangeBus struct {
+	mux       sync.Mutex
+	subs      map[chan Change]struct{}
+	listeners map[int]func(Change)
+	nextID    int
+}
+
+func NewChangeBus() *ChangeBus {
+	return &ChangeBus{subs: make(map[chan Change]struct{}), listeners: make(map[int]func(Change))}
+}
+
+// Publish delivers a change to every listener, then to every subscriber.
+// It never blocks on a subscriber: one whose buffer is full misses the
+// change.
+func (b *ChangeBus) Publish(typ string, p Posts) {
+	c := Change{Type: typ, Post: p, Time: time.Now()}
+
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	for _, fn := range b.listeners {
+		fn(c)
+	}
+	for ch := range b.subs {
+		select {
+		case ch <- c:
+		default:
+			log.Printf("change bus subscriber is full, dropped %s of post %d", typ, p.ID)
+		}
+	}
+}
+
+// Subscribe returns a channel receiving every change published from now on
+// and a function to unsubscribe, which closes the channel.
+func (b *ChangeBus) Subscribe(buffer int) (<-chan Change, func()) {
+	ch := make(chan Change, buffer)
+	b.mux.Lock()
+	b.subs[ch] = struct{}{}
+	b.mux.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			b.mux.Lock()
+			delete(b.subs, ch)
+			b.mux.Unlock()
+			close(ch)
+		})
+	}
+}
+
+// Listen calls fn with every change published from now on, and returns a
+// function to stop. fn gets every change, in order, but is called by
+// Publish, which often runs with the store locked: it must return quickly
+// and must not call the store.
+func (b *ChangeBus) Listen(fn func(Change)) func() {
+	b.mux.Lock()
+	id := b.nextID
+	b.nextID++
+	b.listeners[id] = fn
+	b.mux.Unlock()
+
+	return func() {
+		b.mux.Lock()
+		delete(b.listeners, id)
+		b.mux.Unlock()
+	}
+}
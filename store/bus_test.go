@@ -0,0 +1,35 @@
+package taskstore
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"testing"
+)
+
+func TestListenMissesNothing(t *testing.T) {
+	b := NewChangeBus()
+	var got []int
+	stop := b.Listen(func(c Change) { got = append(got, c.Post.ID) })
+	// a subscriber with a small buffer fills up along the way, which is
+	// logged for every change it misses
+	_, unsubscribe := b.Subscribe(1)
+	defer unsubscribe()
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	for id := 1; id <= 200; id++ {
+		b.Publish(Deleted, Posts{ID: id})
+	}
+	stop()
+	b.Publish(Deleted, Posts{ID: 201})
+
+	if len(got) != 200 {
+		t.Fatalf("listener got %d changes, want 200", len(got))
+	}
+	for i, id := range got {
+		if id != i+1 {
+			t.Fatalf("change %d is of post %d, want %d", i, id, i+1)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package taskstore
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Every PostStoreManager method returns errors wrapping one of these, so
+// callers can classify them with errors.Is whatever the backend.
+var (
+	ErrNotFound     = errors.New("not found")
+	ErrConflict     = errors.New("conflict")
+	ErrValidation   = errors.New("validation failed")
+	ErrPrecondition = errors.New("version mismatch")
+	ErrUnavailable  = errors.New("store unavailable")
+	// ErrForbidden is returned by the Actor policy, not the stores.
+	ErrForbidden = errors.New("forbidden")
+)
+
+func notFound(id int) error {
+	return fmt.Errorf("post %d %w", id, ErrNotFound)
+}
+
+func trashedNotFound(id int) error {
+	return fmt.Errorf("post %d %w in the trash", id, ErrNotFound)
+}
+
+func notTrashed(id int) error {
+	return fmt.Errorf("post %d is not in the trash: %w", id, ErrConflict)
+}
+
+func versionMismatch(id, want, have int) error {
+	return fmt.Errorf("post %d is at version %d, not %d: %w", id, have, want, ErrPrecondition)
+}
+
+// validate checks the fields a client supplies when writing a post.
+func validate(text, author string, tags []string) error {
+	if strings.TrimSpace(text) == "" {
+		return fmt.Errorf("%w: text is required", ErrValidation)
+	}
+	if strings.TrimSpace(author) == "" {
+		return fmt.Errorf("%w: author is required", ErrValidation)
+	}
+	for i, tag := range tags {
+		if strings.TrimSpace(tag) == "" {
+			return fmt.Errorf("%w: tag %d is empty", ErrValidation, i)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,42 @@
+package taskstore
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// APIKey authenticates its holder as Name. Only a hash of the secret part
+// of the key is kept.
+type APIKey struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	Role Role   `json:"role"`
+	// Hash is the SHA-256 of the key secret.
+	Hash    []byte    `json:"-"`
+	Created time.Time `json:"created"`
+}
+
+// KeyStore keeps the API keys.
+type KeyStore interface {
+	CreateAPIKey(ctx context.Context, k APIKey) (APIKey, error)
+	GetAPIKey(ctx context.Context, id string) (APIKey, error)
+	ListAPIKeys(ctx context.Context) ([]APIKey, error)
+	DeleteAPIKey(ctx context.Context, id string) error
+}
+
+func validateAPIKey(k APIKey) error {
+	if k.ID == "" || len(k.Hash) == 0 {
+		return fmt.Errorf("%w: key id and hash are required", ErrValidation)
+	}
+	if strings.TrimSpace(k.Name) == "" {
+		return fmt.Errorf("%w: name is required", ErrValidation)
+	}
+	_, err := ParseRole(string(k.Role))
+	return err
+}
+
+func keyNotFound(id string) error {
+	return fmt.Errorf("api key %s %w", id, ErrNotFound)
+}
@@ -0,0 +1,315 @@
+package taskstore
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Filter selects the posts returned by ListPosts. Zero fields match
+// everything.
+type Filter struct {
+	Author string
+	// Tags selects posts carrying any of them, or all of them with AllTags.
+	Tags    []string
+	AllTags bool
+	// DueFrom and DueTo bound Due to [DueFrom, DueTo).
+	DueFrom time.Time
+	DueTo   time.Time
+	// Text selects posts whose text contains it, ignoring case.
+	Text string
+	// Search selects posts whose text matches it.
+	Search *SearchQuery
+	// Trashed makes ListPosts list the posts in the trash instead of the
+	// others. Match ignores it, so that the change moving a post to the
+	// trash still matches the filters it did before.
+	Trashed bool
+	// HidePrivate leaves out private posts, except those written by
+	// Viewer.
+	HidePrivate bool
+	Viewer      string
+}
+
+// ListOptions controls the order and window of ListPosts.
+type ListOptions struct {
+	// Sort is id, due, author, rank when the filter has a Search or
+	// deleted when it lists the trash, prefixed with - for descending
+	// order. Posts with equal keys are ordered by id in the same direction.
+	Sort string
+	// Limit is the page size, it must be positive.
+	Limit int
+	// Cursor is Page.Next or Page.Prev of a previous call with the same
+	// Sort, empty for the first page.
+	Cursor string
+	// Total asks for Page.Total to be counted.
+	Total bool
+}
+
+// Page is one window of ListPosts.
+type Page struct {
+	Posts []Posts
+	// Next and Prev are opaque cursors to the neighbouring pages, empty
+	// when there is none.
+	Next string
+	Prev string
+	// Total is the number of posts matching the filter, -1 unless
+	// ListOptions.Total was set.
+	Total int
+}
+
+// sortKeys are the fields posts can be sorted by.
+var sortKeys = map[string]bool{"id": true, "due": true, "author": true, "rank": true, "deleted": true}
+
+// cursor is the decoded form of Page.Next and Page.Prev: the sort key and id
+// of the post at the edge of a page.
+type cursor struct {
+	Sort   string    `json:"s"`
+	Before bool      `json:"b,omitempty"`
+	ID     int       `json:"i"`
+	Author string    `json:"a,omitempty"`
+	Due    time.Time `json:"d,omitempty"`
+	Rank   float64   `json:"r,omitempty"`
+	// Deleted is the time the post was moved to the trash.
+	Deleted time.Time `json:"x,omitempty"`
+}
+
+func (c cursor) encode() string {
+	js, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(js)
+}
+
+// parseSort splits a ListOptions.Sort into its key and direction. Sorting
+// by rank needs a search, by deleted the trash.
+func parseSort(s string, f Filter) (key string, desc bool, err error) {
+	if s == "" {
+		s = "id"
+	}
+	key = strings.TrimPrefix(s, "-")
+	if !sortKeys[key] {
+		return "", false, fmt.Errorf("%w: cannot sort by %q, expect id, due, author, rank or deleted", ErrValidation, key)
+	}
+	if key == "rank" && f.Search == nil {
+		return "", false, fmt.Errorf("%w: cannot sort by rank without a search", ErrValidation)
+	}
+	if key == "deleted" && !f.Trashed {
+		return "", false, fmt.Errorf("%w: cannot sort by deleted outside the trash", ErrValidation)
+	}
+	return key, key != s, nil
+}
+
+// decodeCursor parses opts.Cursor, which must have been issued for the same
+// sort order. It returns nil for the first page.
+func decodeCursor(opts ListOptions) (*cursor, error) {
+	if opts.Cursor == "" {
+		return nil, nil
+	}
+	js, err := base64.RawURLEncoding.DecodeString(opts.Cursor)
+	var c cursor
+	if err == nil {
+		err = json.Unmarshal(js, &c)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed cursor", ErrValidation)
+	}
+	sortBy := opts.Sort
+	if sortBy == "" {
+		sortBy = "id"
+	}
+	if c.Sort != sortBy {
+		return nil, fmt.Errorf("%w: cursor was issued for sort %s, not %s", ErrValidation, c.Sort, sortBy)
+	}
+	return &c, nil
+}
+
+func checkLimit(opts ListOptions) error {
+	if opts.Limit <= 0 {
+		return fmt.Errorf("%w: limit must be positive", ErrValidation)
+	}
+	return nil
+}
+
+// edgeCursor returns the cursor pointing past p in the given direction.
+func edgeCursor(sortBy string, p Posts, before bool) string {
+	if sortBy == "" {
+		sortBy = "id"
+	}
+	c := cursor{Sort: sortBy, Before: before, ID: p.ID}
+	switch strings.TrimPrefix(sortBy, "-") {
+	case "author":
+		c.Author = p.Author
+	case "due":
+		c.Due = p.Due
+	case "rank":
+		c.Rank = p.Rank
+	case "deleted":
+		c.Deleted = deletedAt(p)
+	}
+	return c.encode()
+}
+
+// makePage trims rows fetched with one extra post beyond the limit into a
+// page and sets its cursors. rows are in display order when c is nil or
+// points forward, in reverse display order when c points backward.
+func makePage(rows []Posts, c *cursor, opts ListOptions) Page {
+	page := Page{Total: -1}
+	backward := c != nil && c.Before
+	more := len(rows) > opts.Limit
+	if more {
+		rows = rows[:opts.Limit]
+	}
+	if backward {
+		for i, j := 0, len(rows)-1; i < j; i, j = i+1, j-1 {
+			rows[i], rows[j] = rows[j], rows[i]
+		}
+	}
+	page.Posts = rows
+
+	hasNext, hasPrev := more, c != nil
+	if backward {
+		hasNext, hasPrev = true, more
+	}
+	if len(rows) > 0 {
+		if hasNext {
+			page.Next = edgeCursor(opts.Sort, rows[len(rows)-1], false)
+		}
+		if hasPrev {
+			page.Prev = edgeCursor(opts.Sort, rows[0], true)
+		}
+	}
+	return page
+}
+
+// Match reports whether p is selected by f.
+func (f Filter) Match(p Posts) bool {
+	if f.Author != "" && p.Author != f.Author {
+		return false
+	}
+	if len(f.Tags) > 0 {
+		found := 0
+		for _, tag := range f.Tags {
+			if hasTag(p.Tags, tag) {
+				found++
+			}
+		}
+		if found == 0 || (f.AllTags && found < len(f.Tags)) {
+			return false
+		}
+	}
+	if !f.DueFrom.IsZero() && p.Due.Before(f.DueFrom) {
+		return false
+	}
+	if !f.DueTo.IsZero() && !p.Due.Before(f.DueTo) {
+		return false
+	}
+	if f.Text != "" && !strings.Contains(strings.ToLower(p.Text), strings.ToLower(f.Text)) {
+		return false
+	}
+	if f.Search != nil && !f.Search.Match(p.Text) {
+		return false
+	}
+	if f.HidePrivate && p.Private && p.Author != f.Viewer {
+		return false
+	}
+	return true
+}
+
+func hasTag(tags []string, tag string) bool {
+	for _, t := range tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// compare orders a and b by key then id, like the Postgres store does.
+func compare(key string, a, b Posts) int {
+	switch key {
+	case "author":
+		if c := strings.Compare(a.Author, b.Author); c != 0 {
+			return c
+		}
+	case "due":
+		if a.Due.Before(b.Due) {
+			return -1
+		}
+		if a.Due.After(b.Due) {
+			return 1
+		}
+	case "rank":
+		if a.Rank < b.Rank {
+			return -1
+		}
+		if a.Rank > b.Rank {
+			return 1
+		}
+	case "deleted":
+		if deletedAt(a).Before(deletedAt(b)) {
+			return -1
+		}
+		if deletedAt(a).After(deletedAt(b)) {
+			return 1
+		}
+	}
+	return a.ID - b.ID
+}
+
+// deletedAt is when p was moved to the trash, zero when it is not there.
+func deletedAt(p Posts) time.Time {
+	if p.Deleted == nil {
+		return time.Time{}
+	}
+	return *p.Deleted
+}
+
+// paginate applies opts to posts, which all match the filter, the way the
+// Postgres store does in SQL.
+func paginate(posts []Posts, f Filter, opts ListOptions) (Page, error) {
+	key, desc, err := parseSort(opts.Sort, f)
+	if err != nil {
+		return Page{}, err
+	}
+	if err := checkLimit(opts); err != nil {
+		return Page{}, err
+	}
+	c, err := decodeCursor(opts)
+	if err != nil {
+		return Page{}, err
+	}
+
+	// walk in the direction the page is fetched in
+	reverse := desc
+	if c != nil && c.Before {
+		reverse = !reverse
+	}
+	sort.Slice(posts, func(i, j int) bool {
+		cmp := compare(key, posts[i], posts[j])
+		if reverse {
+			return cmp > 0
+		}
+		return cmp < 0
+	})
+
+	rows := []Posts{}
+	for _, p := range posts {
+		if c != nil {
+			cmp := compare(key, p, Posts{ID: c.ID, Author: c.Author, Due: c.Due, Rank: c.Rank, Deleted: &c.Deleted})
+			if (reverse && cmp >= 0) || (!reverse && cmp <= 0) {
+				continue
+			}
+		}
+		rows = append(rows, p)
+		if len(rows) > opts.Limit {
+			break
+		}
+	}
+
+	page := makePage(rows, c, opts)
+	if opts.Total {
+		page.Total = len(posts)
+	}
+	return page, nil
+}
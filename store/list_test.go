@@ -0,0 +1,166 @@
+package taskstore
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestCursor(t *testing.T) {
+	due := time.Date(2026, 11, 1, 9, 30, 0, 0, time.UTC)
+	p := Posts{ID: 7, Author: "bob", Due: due, Rank: 0.5}
+	tests := []struct {
+		sort   string
+		before bool
+		want   cursor
+	}{
+		{"", false, cursor{Sort: "id", ID: 7}},
+		{"-id", true, cursor{Sort: "-id", Before: true, ID: 7}},
+		{"author", false, cursor{Sort: "author", ID: 7, Author: "bob"}},
+		{"-due", true, cursor{Sort: "-due", Before: true, ID: 7, Due: due}},
+		{"-rank", false, cursor{Sort: "-rank", ID: 7, Rank: 0.5}},
+	}
+	for _, tt := range tests {
+		c, err := decodeCursor(ListOptions{Sort: tt.sort, Cursor: edgeCursor(tt.sort, p, tt.before)})
+		if err != nil {
+			t.Errorf("sort %q: %v", tt.sort, err)
+			continue
+		}
+		if !reflect.DeepEqual(*c, tt.want) {
+			t.Errorf("sort %q: cursor = %+v, want %+v", tt.sort, *c, tt.want)
+		}
+	}
+
+	if c, err := decodeCursor(ListOptions{Sort: "due"}); c != nil || err != nil {
+		t.Errorf("no cursor = %+v, %v, want the first page", c, err)
+	}
+	for _, opts := range []ListOptions{
+		{Sort: "due", Cursor: edgeCursor("-due", p, false)},
+		{Sort: "", Cursor: edgeCursor("author", p, false)},
+		{Sort: "id", Cursor: "not base64!"},
+		{Sort: "id", Cursor: "bm90IGpzb24"},
+	} {
+		if _, err := decodeCursor(opts); !errors.Is(err, ErrValidation) {
+			t.Errorf("cursor %q for sort %q: %v, want a validation error", opts.Cursor, opts.Sort, err)
+		}
+	}
+}
+
+// TestPagination walks every sort order forward then backward, a page at a
+// time, and checks that each post comes once and in order.
+func TestPagination(t *testing.T) {
+	day := time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC)
+	// ties on both due and author, so that the id decides
+	posts := []struct {
+		author string
+		due    time.Time
+	}{
+		{"bob", day.Add(48 * time.Hour)},
+		{"alice", day},
+		{"bob", day},
+		{"carol", day.Add(24 * time.Hour)},
+		{"alice", day.Add(48 * time.Hour)},
+		{"bob", day},
+		{"alice", day.Add(24 * time.Hour)},
+	}
+
+	forEachStore(t, func(t *testing.T, s PostStoreManager) {
+		ctx := context.Background()
+		var created []Posts
+		for _, p := range posts {
+			post, err := s.CreatePost(ctx, "post", p.author, nil, p.due, false, "test")
+			if err != nil {
+				t.Fatal(err)
+			}
+			created = append(created, post)
+		}
+
+		for _, tt := range []struct {
+			sort string
+			less func(a, b Posts) bool
+		}{
+			{"id", func(a, b Posts) bool { return a.ID < b.ID }},
+			{"-id", func(a, b Posts) bool { return a.ID > b.ID }},
+			{"-due", func(a, b Posts) bool {
+				if !a.Due.Equal(b.Due) {
+					return a.Due.After(b.Due)
+				}
+				return a.ID > b.ID
+			}},
+			{"author", func(a, b Posts) bool {
+				if a.Author != b.Author {
+					return a.Author < b.Author
+				}
+				return a.ID < b.ID
+			}},
+		} {
+			t.Run(tt.sort, func(t *testing.T) {
+				want := append([]Posts(nil), created...)
+				sort.Slice(want, func(i, j int) bool { return tt.less(want[i], want[j]) })
+				var wantIDs []int
+				for _, p := range want {
+					wantIDs = append(wantIDs, p.ID)
+				}
+
+				for _, limit := range []int{1, 2, 3, len(posts), 100} {
+					var forward []int
+					var prev string
+					opts := ListOptions{Sort: tt.sort, Limit: limit}
+					for pages := 0; ; pages++ {
+						page, err := s.ListPosts(ctx, Filter{}, opts)
+						if err != nil {
+							t.Fatal(err)
+						}
+						if len(page.Posts) > limit {
+							t.Fatalf("limit %d: page has %d posts", limit, len(page.Posts))
+						}
+						if (pages == 0) != (page.Prev == "") {
+							t.Errorf("limit %d: page %d has prev %q", limit, pages, page.Prev)
+						}
+						for _, p := range page.Posts {
+							forward = append(forward, p.ID)
+						}
+						if page.Next == "" {
+							prev = page.Prev
+							break
+						}
+						if pages > len(posts) {
+							t.Fatalf("limit %d: more pages than posts", limit)
+						}
+						opts.Cursor = page.Next
+					}
+					if !reflect.DeepEqual(forward, wantIDs) {
+						t.Errorf("limit %d: forward = %v, want %v", limit, forward, wantIDs)
+					}
+
+					// back from the last page, which holds what the full
+					// pages before it left over
+					last := (len(forward)-1)%limit + 1
+					backward := append([]int(nil), forward[len(forward)-last:]...)
+					opts.Cursor = prev
+					for opts.Cursor != "" {
+						page, err := s.ListPosts(ctx, Filter{}, opts)
+						if err != nil {
+							t.Fatal(err)
+						}
+						if page.Next == "" {
+							t.Errorf("limit %d: a page before the last has no next", limit)
+						}
+						var ids []int
+						for _, p := range page.Posts {
+							ids = append(ids, p.ID)
+						}
+						backward = append(ids, backward...)
+						opts.Cursor = page.Prev
+					}
+					if !reflect.DeepEqual(backward, wantIDs) {
+						t.Errorf("limit %d: backward = %v, want %v", limit, backward, wantIDs)
+					}
+				}
+			})
+		}
+	})
+}
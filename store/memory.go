@@ -0,0 +1,521 @@
+package taskstore
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// PostStore keeps posts in a map. It is used in dev and tests where no
+// Postgres is available.
+type PostStore struct {
+	mux     sync.Mutex
+	Post    map[int]Posts
+	nextID  int
+	changes *ChangeBus
+	// index holds the words of every post, for searches.
+	index *textIndex
+	// revisions are those of every post, oldest first.
+	revisions map[int][]Revision
+
+	hooks        map[int]Webhook
+	nextHook     int
+	outbox       map[int64]Delivery
+	nextDelivery int64
+
+	keys map[string]APIKey
+}
+
+func New() *PostStore {
+	ts := &PostStore{}
+	ts.Post = make(map[int]Posts)
+	// match the Postgres sequence which starts at 1
+	ts.nextID = 1
+	ts.changes = NewChangeBus()
+	ts.index = newTextIndex()
+	ts.revisions = make(map[int][]Revision)
+	ts.hooks = make(map[int]Webhook)
+	ts.nextHook = 1
+	ts.outbox = make(map[int64]Delivery)
+	ts.nextDelivery = 1
+	ts.keys = make(map[string]APIKey)
+	return ts
+}
+
+// Close does nothing, the map lives as long as the process.
+func (p *PostStore) Close() {}
+
+// Ping always succeeds, the map is always there.
+func (p *PostStore) Ping(ctx context.Context) error {
+	return nil
+}
+
+func (p *PostStore) Changes() *ChangeBus {
+	return p.changes
+}
+
+// revise keeps the revision of post a write of event by by left. p.mux must
+// be held.
+func (p *PostStore) revise(event string, post Posts, by string, now time.Time) {
+	p.revisions[post.ID] = append(p.revisions[post.ID], Revision{
+		Number:  post.Version,
+		Event:   event,
+		By:      by,
+		Created: now,
+		Post:    post,
+	})
+}
+
+// record publishes a write made with ctx and queues its webhook deliveries.
+// p.mux must be held, so that both happen atomically with the write.
+func (p *PostStore) record(ctx context.Context, typ string, post Posts) {
+	p.changes.Publish(typ, post)
+	now := time.Now()
+	for _, h := range p.hooks {
+		if !h.Match(typ, post) {
+			continue
+		}
+		p.outbox[p.nextDelivery] = Delivery{
+			ID:          p.nextDelivery,
+			Webhook:     h.ID,
+			Event:       typ,
+			Post:        post,
+			Status:      DeliveryPending,
+			NextAttempt: now,
+			Traceparent: traceparentFrom(ctx),
+			Created:     now,
+			Updated:     now,
+		}
+		p.nextDelivery++
+	}
+}
+
+func (p *PostStore) CreatePost(ctx context.Context, tx string, author string, tags []string, due time.Time, private bool, by string) (Posts, error) {
+	if err := validate(tx, author, tags); err != nil {
+		return Posts{}, err
+	}
+
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	now := time.Now()
+	post := Posts{
+		ID:      p.nextID,
+		Author:  author,
+		Text:    tx,
+		Due:     due,
+		Private: private,
+		Version: 1,
+		Created: now,
+		Updated: now,
+	}
+
+	post.Tags = make([]string, len(tags))
+	copy(post.Tags, tags)
+
+	p.Post[p.nextID] = post
+	p.index.set(post.ID, post.Text)
+	p.nextID++
+	p.revise(Created, post, by, now)
+	p.record(ctx, Created, post)
+	return post, nil
+}
+
+func (p *PostStore) GetPost(ctx context.Context, id int) (Posts, error) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	t, ok := p.Post[id]
+	if !ok || t.Deleted != nil {
+		return Posts{}, notFound(id)
+	}
+	return t, nil
+}
+
+func (p *PostStore) UpdatePost(ctx context.Context, id int, version int, tx string, author string, tags []string, due time.Time, private bool, by string) (Posts, error) {
+	if err := validate(tx, author, tags); err != nil {
+		return Posts{}, err
+	}
+
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	post, ok := p.Post[id]
+	if !ok || post.Deleted != nil {
+		return Posts{}, notFound(id)
+	}
+	if version != 0 && version != post.Version {
+		return Posts{}, versionMismatch(id, version, post.Version)
+	}
+	post.Version++
+	post.Updated = time.Now()
+	post.Author = author
+	post.Text = tx
+	post.Due = due
+	post.Private = private
+	post.Tags = make([]string, len(tags))
+	copy(post.Tags, tags)
+
+	p.Post[id] = post
+	p.index.set(id, post.Text)
+	p.revise(Updated, post, by, post.Updated)
+	p.record(ctx, Updated, post)
+	return post, nil
+}
+
+func (p *PostStore) DeletePost(ctx context.Context, id int, version int, by string) error {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	post, ok := p.Post[id]
+	if !ok || post.Deleted != nil {
+		return notFound(id)
+	}
+	if version != 0 && version != post.Version {
+		return versionMismatch(id, version, post.Version)
+	}
+	p.trash(ctx, post, by, time.Now())
+	return nil
+}
+
+func (p *PostStore) DeleteAllPosts(ctx context.Context, by string) error {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	now := time.Now()
+	for _, post := range p.Post {
+		if post.Deleted == nil {
+			p.trash(ctx, post, by, now)
+		}
+	}
+	return nil
+}
+
+// trash moves post to the trash, for a write made with ctx. p.mux must be
+// held.
+func (p *PostStore) trash(ctx context.Context, post Posts, by string, now time.Time) {
+	post.Version++
+	post.Deleted = &now
+	post.DeletedBy = by
+	p.Post[post.ID] = post
+	p.revise(Deleted, post, by, now)
+	p.record(ctx, Deleted, post)
+}
+
+func (p *PostStore) GetTrashedPost(ctx context.Context, id int) (Posts, error) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	t, ok := p.Post[id]
+	if !ok || t.Deleted == nil {
+		return Posts{}, trashedNotFound(id)
+	}
+	return t, nil
+}
+
+func (p *PostStore) RestorePost(ctx context.Context, id int, version int, by string) (Posts, error) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	post, ok := p.Post[id]
+	if !ok {
+		return Posts{}, trashedNotFound(id)
+	}
+	if post.Deleted == nil {
+		return Posts{}, notTrashed(id)
+	}
+	if version != 0 && version != post.Version {
+		return Posts{}, versionMismatch(id, version, post.Version)
+	}
+	post.Version++
+	post.Updated = time.Now()
+	post.Deleted = nil
+	post.DeletedBy = ""
+	p.Post[id] = post
+	p.revise(Restored, post, by, post.Updated)
+	p.record(ctx, Created, post)
+	return post, nil
+}
+
+func (p *PostStore) PurgePost(ctx context.Context, id int) error {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	post, ok := p.Post[id]
+	if !ok {
+		return trashedNotFound(id)
+	}
+	if post.Deleted == nil {
+		return notTrashed(id)
+	}
+	delete(p.Post, id)
+	delete(p.revisions, id)
+	p.index.remove(id)
+	return nil
+}
+
+func (p *PostStore) PurgeTrash(ctx context.Context, before time.Time) (int, error) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	n := 0
+	for id, post := range p.Post {
+		if post.Deleted != nil && post.Deleted.Before(before) {
+			delete(p.Post, id)
+			delete(p.revisions, id)
+			p.index.remove(id)
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (p *PostStore) ListPosts(ctx context.Context, f Filter, opts ListOptions) (Page, error) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	posts := []Posts{}
+	if f.Search == nil {
+		for _, post := range p.Post {
+			if (post.Deleted != nil) == f.Trashed && f.Match(post) {
+				posts = append(posts, post)
+			}
+		}
+		return paginate(posts, f, opts)
+	}
+	// the index finds the matches of the search, Match the rest of f
+	rest := f
+	rest.Search = nil
+	for id, rank := range p.index.search(f.Search) {
+		if post := p.Post[id]; (post.Deleted != nil) == f.Trashed && rest.Match(post) {
+			post.Rank = rank
+			posts = append(posts, post)
+		}
+	}
+	return paginate(posts, f, opts)
+}
+
+func (p *PostStore) ListRevisions(ctx context.Context, id int, before int, limit int) ([]Revision, error) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	list := []Revision{}
+	revs := p.revisions[id]
+	for i := len(revs) - 1; i >= 0 && len(list) < limit; i-- {
+		if before == 0 || revs[i].Number < before {
+			list = append(list, revs[i])
+		}
+	}
+	return list, nil
+}
+
+func (p *PostStore) GetRevision(ctx context.Context, id int, number int) (Revision, error) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	for _, r := range p.revisions[id] {
+		if r.Number == number {
+			return r, nil
+		}
+	}
+	return Revision{}, revisionNotFound(id, number)
+}
+
+func (p *PostStore) CreateWebhook(ctx context.Context, h Webhook) (Webhook, error) {
+	if err := validateWebhook(h); err != nil {
+		return Webhook{}, err
+	}
+
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	h.ID = p.nextHook
+	h.Events = append([]string(nil), h.Events...)
+	h.Tags = append([]string(nil), h.Tags...)
+	h.Created = time.Now()
+	p.hooks[h.ID] = h
+	p.nextHook++
+	return h, nil
+}
+
+func (p *PostStore) GetWebhook(ctx context.Context, id int) (Webhook, error) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	h, ok := p.hooks[id]
+	if !ok {
+		return Webhook{}, webhookNotFound(id)
+	}
+	return h, nil
+}
+
+func (p *PostStore) ListWebhooks(ctx context.Context) ([]Webhook, error) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	hooks := []Webhook{}
+	for _, h := range p.hooks {
+		hooks = append(hooks, h)
+	}
+	sort.Slice(hooks, func(i, j int) bool { return hooks[i].ID < hooks[j].ID })
+	return hooks, nil
+}
+
+func (p *PostStore) DeleteWebhook(ctx context.Context, id int) error {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	if _, ok := p.hooks[id]; !ok {
+		return webhookNotFound(id)
+	}
+	delete(p.hooks, id)
+	for did, d := range p.outbox {
+		if d.Webhook == id {
+			delete(p.outbox, did)
+		}
+	}
+	return nil
+}
+
+func (p *PostStore) ClaimDeliveries(ctx context.Context, limit int, lease time.Duration) ([]Delivery, error) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	now := time.Now()
+	due := []Delivery{}
+	for _, d := range p.outbox {
+		if d.Status == DeliveryPending && !d.NextAttempt.After(now) {
+			due = append(due, d)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool {
+		if !due[i].NextAttempt.Equal(due[j].NextAttempt) {
+			return due[i].NextAttempt.Before(due[j].NextAttempt)
+		}
+		return due[i].ID < due[j].ID
+	})
+	if len(due) > limit {
+		due = due[:limit]
+	}
+	for i := range due {
+		due[i].NextAttempt = now.Add(lease)
+		p.outbox[due[i].ID] = due[i]
+	}
+	return due, nil
+}
+
+func (p *PostStore) FinishDelivery(ctx context.Context, id int64, a Attempt) error {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	d, ok := p.outbox[id]
+	if !ok {
+		return deliveryNotFound(id)
+	}
+	d.Attempts++
+	d.LastStatus = a.Status
+	d.LastError = a.Error
+	d.Updated = time.Now()
+	d.NextAttempt = d.Updated
+	switch {
+	case a.Delivered:
+		d.Status = DeliveryDelivered
+	case a.RetryAt.IsZero():
+		d.Status = DeliveryDead
+	default:
+		d.NextAttempt = a.RetryAt
+	}
+	p.outbox[id] = d
+	return nil
+}
+
+func (p *PostStore) ListDeliveries(ctx context.Context, webhook int, status string, before int64, limit int) ([]Delivery, error) {
+	if err := checkDeliveryStatus(status); err != nil {
+		return nil, err
+	}
+
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	list := []Delivery{}
+	for _, d := range p.outbox {
+		if (webhook == 0 || d.Webhook == webhook) && (status == "" || d.Status == status) && (before == 0 || d.ID < before) {
+			list = append(list, d)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID > list[j].ID })
+	if len(list) > limit {
+		list = list[:limit]
+	}
+	return list, nil
+}
+
+func (p *PostStore) RetryDelivery(ctx context.Context, id int64) (Delivery, error) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	d, ok := p.outbox[id]
+	if !ok {
+		return Delivery{}, deliveryNotFound(id)
+	}
+	if d.Status != DeliveryDead {
+		return Delivery{}, fmt.Errorf("delivery %d is %s, not dead: %w", id, d.Status, ErrConflict)
+	}
+	d.Status = DeliveryPending
+	d.Updated = time.Now()
+	d.NextAttempt = d.Updated
+	p.outbox[id] = d
+	return d, nil
+}
+
+func (p *PostStore) CreateAPIKey(ctx context.Context, k APIKey) (APIKey, error) {
+	if err := validateAPIKey(k); err != nil {
+		return APIKey{}, err
+	}
+
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	if _, ok := p.keys[k.ID]; ok {
+		return APIKey{}, fmt.Errorf("api key %s exists: %w", k.ID, ErrConflict)
+	}
+	k.Created = time.Now()
+	p.keys[k.ID] = k
+	return k, nil
+}
+
+func (p *PostStore) GetAPIKey(ctx context.Context, id string) (APIKey, error) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	k, ok := p.keys[id]
+	if !ok {
+		return APIKey{}, keyNotFound(id)
+	}
+	return k, nil
+}
+
+func (p *PostStore) ListAPIKeys(ctx context.Context) ([]APIKey, error) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	keys := []APIKey{}
+	for _, k := range p.keys {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].Created.Before(keys[j].Created) })
+	return keys, nil
+}
+
+func (p *PostStore) DeleteAPIKey(ctx context.Context, id string) error {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	if _, ok := p.keys[id]; !ok {
+		return keyNotFound(id)
+	}
+	delete(p.keys, id)
+	return nil
+}
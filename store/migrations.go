@@ -0,0 +1,556 @@
+package taskstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx"
+)
+
+// Migration is one versioned change of the Postgres schema.
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// migrations are compiled into the binary and applied in order. Never edit
+// a released migration, append a new one.
+var migrations = []Migration{
+	{
+		Version: 1,
+		Name:    "create posts",
+		// IF NOT EXISTS adopts databases created before migrations existed
+		Up: `
+CREATE SEQUENCE IF NOT EXISTS postsseq;
+CREATE TABLE IF NOT EXISTS posts (
+	id     integer PRIMARY KEY DEFAULT nextval('postsseq'),
+	author text NOT NULL,
+	text   text NOT NULL,
+	tags   text[] NOT NULL DEFAULT '{}',
+	due    timestamptz NOT NULL
+);`,
+		Down: `
+DROP TABLE posts;
+DROP SEQUENCE postsseq;`,
+	},
+	{
+		Version: 2,
+		Name:    "add post version",
+		Up:      `ALTER TABLE posts ADD COLUMN IF NOT EXISTS version integer NOT NULL DEFAULT 1;`,
+		Down:    `ALTER TABLE posts DROP COLUMN version;`,
+	},
+	{
+		Version: 3,
+		Name:    "index post tags",
+		// tag queries use the array operators && and @>, which GIN indexes
+		Up:   `CREATE INDEX IF NOT EXISTS posts_tags_idx ON posts USING GIN (tags);`,
+		Down: `DROP INDEX posts_tags_idx;`,
+	},
+	{
+		Version: 4,
+		Name:    "add post timestamps",
+		Up: `
+ALTER TABLE posts ADD COLUMN created_at timestamptz NOT NULL DEFAULT now();
+ALTER TABLE posts ADD COLUMN updated_at timestamptz NOT NULL DEFAULT now();`,
+		Down: `
+ALTER TABLE posts DROP COLUMN created_at;
+ALTER TABLE posts DROP COLUMN updated_at;`,
+	},
+	{
+		Version: 5,
+		Name:    "notify post changes",
+		// a notification payload must stay under 8000 bytes, a post whose
+		// text does not fit is sent without it and marked partial
+		Up: `
+CREATE FUNCTION posts_notify() RETURNS trigger AS $$
+DECLARE
+	post    jsonb;
+	payload text;
+BEGIN
+	IF TG_OP = 'DELETE' THEN
+		post := to_jsonb(OLD);
+	ELSE
+		post := to_jsonb(NEW);
+	END IF;
+	payload := jsonb_build_object('op', lower(TG_OP), 'post', post)::text;
+	IF octet_length(payload) > 7900 THEN
+		payload := jsonb_build_object('op', lower(TG_OP), 'post', post - 'text', 'partial', true)::text;
+	END IF;
+	PERFORM pg_notify('posts_changes', payload);
+	RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+CREATE TRIGGER posts_notify AFTER INSERT OR UPDATE OR DELETE ON posts
+	FOR EACH ROW EXECUTE PROCEDURE posts_notify();`,
+		Down: `
+DROP TRIGGER posts_notify ON posts;
+DROP FUNCTION posts_notify();`,
+	},
+	{
+		Version: 6,
+		Name:    "add webhooks",
+		// the trigger queues deliveries in the transaction writing the
+		// post, matching webhooks the way Webhook.Match does
+		Up: `
+CREATE TABLE webhooks (
+	id         serial PRIMARY KEY,
+	url        text NOT NULL,
+	events     text[] NOT NULL,
+	author     text NOT NULL DEFAULT '',
+	tags       text[] NOT NULL DEFAULT '{}',
+	secret     text NOT NULL,
+	created_at timestamptz NOT NULL DEFAULT now()
+);
+CREATE TABLE webhook_outbox (
+	id           bigserial PRIMARY KEY,
+	webhook_id   integer NOT NULL REFERENCES webhooks ON DELETE CASCADE,
+	event        text NOT NULL,
+	post         jsonb NOT NULL,
+	status       text NOT NULL DEFAULT 'pending',
+	attempts     integer NOT NULL DEFAULT 0,
+	next_attempt timestamptz NOT NULL DEFAULT now(),
+	last_status  integer NOT NULL DEFAULT 0,
+	last_error   text NOT NULL DEFAULT '',
+	created_at   timestamptz NOT NULL DEFAULT now(),
+	updated_at   timestamptz NOT NULL DEFAULT now()
+);
+CREATE INDEX webhook_outbox_due_idx ON webhook_outbox (next_attempt, id) WHERE status = 'pending';
+CREATE INDEX webhook_outbox_webhook_idx ON webhook_outbox (webhook_id, id);
+CREATE FUNCTION posts_outbox() RETURNS trigger AS $$
+DECLARE
+	p    posts;
+	kind text;
+BEGIN
+	IF TG_OP = 'DELETE' THEN
+		p := OLD;
+	ELSE
+		p := NEW;
+	END IF;
+	kind := CASE TG_OP WHEN 'INSERT' THEN 'created' WHEN 'UPDATE' THEN 'updated' ELSE 'deleted' END;
+	INSERT INTO webhook_outbox (webhook_id, event, post)
+	SELECT webhooks.id, kind, to_jsonb(p) FROM webhooks
+	WHERE kind = ANY (webhooks.events)
+		AND (webhooks.author = '' OR webhooks.author = p.author)
+		AND (cardinality(webhooks.tags) = 0 OR webhooks.tags && p.tags);
+	RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+CREATE TRIGGER posts_outbox AFTER INSERT OR UPDATE OR DELETE ON posts
+	FOR EACH ROW EXECUTE PROCEDURE posts_outbox();`,
+		Down: `
+DROP TRIGGER posts_outbox ON posts;
+DROP FUNCTION posts_outbox();
+DROP TABLE webhook_outbox;
+DROP TABLE webhooks;`,
+	},
+	{
+		Version: 7,
+		Name:    "add api keys",
+		Up: `
+CREATE TABLE api_keys (
+	id         text PRIMARY KEY,
+	name       text NOT NULL,
+	admin      boolean NOT NULL DEFAULT false,
+	hash       bytea NOT NULL,
+	created_at timestamptz NOT NULL DEFAULT now()
+);`,
+		Down: `DROP TABLE api_keys;`,
+	},
+	{
+		Version: 8,
+		Name:    "add private posts and roles",
+		// existing keys keep their rights: admins stay admins, the others
+		// could write
+		Up: `
+ALTER TABLE posts ADD COLUMN private boolean NOT NULL DEFAULT false;
+ALTER TABLE api_keys ADD COLUMN role text NOT NULL DEFAULT 'writer';
+UPDATE api_keys SET role = 'admin' WHERE admin;
+ALTER TABLE api_keys DROP COLUMN admin;`,
+		Down: `
+ALTER TABLE api_keys ADD COLUMN admin boolean NOT NULL DEFAULT false;
+UPDATE api_keys SET admin = true WHERE role = 'admin';
+ALTER TABLE api_keys DROP COLUMN role;
+ALTER TABLE posts DROP COLUMN private;`,
+	},
+	{
+		Version: 9,
+		Name:    "index post text search",
+		// searches match to_tsvector('simple', text), which must be
+		// written the same way to use the index
+		Up:   `CREATE INDEX posts_text_search_idx ON posts USING GIN (to_tsvector('simple', text));`,
+		Down: `DROP INDEX posts_text_search_idx;`,
+	},
+	{
+		Version: 10,
+		Name:    "add post trash",
+		// to the triggers, moving a post to the trash deletes it and
+		// restoring it creates it again; changes within the trash, and the
+		// purges emptying it, are not changes of any post anyone can see
+		Up: `
+ALTER TABLE posts ADD COLUMN deleted_at timestamptz;
+ALTER TABLE posts ADD COLUMN deleted_by text NOT NULL DEFAULT '';
+CREATE INDEX posts_trash_idx ON posts (deleted_at, id) WHERE deleted_at IS NOT NULL;
+CREATE OR REPLACE FUNCTION posts_notify() RETURNS trigger AS $$
+DECLARE
+	op      text;
+	post    jsonb;
+	payload text;
+BEGIN
+	IF TG_OP = 'INSERT' THEN
+		op := 'insert';
+		post := to_jsonb(NEW);
+	ELSIF TG_OP = 'DELETE' THEN
+		IF OLD.deleted_at IS NOT NULL THEN
+			RETURN NULL;
+		END IF;
+		op := 'delete';
+		post := to_jsonb(OLD);
+	ELSE
+		IF OLD.deleted_at IS NOT NULL AND NEW.deleted_at IS NOT NULL THEN
+			RETURN NULL;
+		END IF;
+		op := CASE WHEN NEW.deleted_at IS NOT NULL THEN 'delete' WHEN OLD.deleted_at IS NOT NULL THEN 'insert' ELSE 'update' END;
+		post := to_jsonb(NEW);
+	END IF;
+	payload := jsonb_build_object('op', op, 'post', post)::text;
+	IF octet_length(payload) > 7900 THEN
+		payload := jsonb_build_object('op', op, 'post', post - 'text', 'partial', true)::text;
+	END IF;
+	PERFORM pg_notify('posts_changes', payload);
+	RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+CREATE OR REPLACE FUNCTION posts_outbox() RETURNS trigger AS $$
+DECLARE
+	p    posts;
+	kind text;
+BEGIN
+	IF TG_OP = 'INSERT' THEN
+		p := NEW;
+		kind := 'created';
+	ELSIF TG_OP = 'DELETE' THEN
+		IF OLD.deleted_at IS NOT NULL THEN
+			RETURN NULL;
+		END IF;
+		p := OLD;
+		kind := 'deleted';
+	ELSE
+		IF OLD.deleted_at IS NOT NULL AND NEW.deleted_at IS NOT NULL THEN
+			RETURN NULL;
+		END IF;
+		p := NEW;
+		kind := CASE WHEN NEW.deleted_at IS NOT NULL THEN 'deleted' WHEN OLD.deleted_at IS NOT NULL THEN 'created' ELSE 'updated' END;
+	END IF;
+	INSERT INTO webhook_outbox (webhook_id, event, post)
+	SELECT webhooks.id, kind, to_jsonb(p) FROM webhooks
+	WHERE kind = ANY (webhooks.events)
+		AND (webhooks.author = '' OR webhooks.author = p.author)
+		AND (cardinality(webhooks.tags) = 0 OR webhooks.tags && p.tags);
+	RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;`,
+		// the posts in the trash go for good, unannounced like a purge,
+		// before the triggers of migrations 5 and 6 come back
+		Down: `
+DELETE FROM posts WHERE deleted_at IS NOT NULL;
+CREATE OR REPLACE FUNCTION posts_notify() RETURNS trigger AS $$
+DECLARE
+	post    jsonb;
+	payload text;
+BEGIN
+	IF TG_OP = 'DELETE' THEN
+		post := to_jsonb(OLD);
+	ELSE
+		post := to_jsonb(NEW);
+	END IF;
+	payload := jsonb_build_object('op', lower(TG_OP), 'post', post)::text;
+	IF octet_length(payload) > 7900 THEN
+		payload := jsonb_build_object('op', lower(TG_OP), 'post', post - 'text', 'partial', true)::text;
+	END IF;
+	PERFORM pg_notify('posts_changes', payload);
+	RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+CREATE OR REPLACE FUNCTION posts_outbox() RETURNS trigger AS $$
+DECLARE
+	p    posts;
+	kind text;
+BEGIN
+	IF TG_OP = 'DELETE' THEN
+		p := OLD;
+	ELSE
+		p := NEW;
+	END IF;
+	kind := CASE TG_OP WHEN 'INSERT' THEN 'created' WHEN 'UPDATE' THEN 'updated' ELSE 'deleted' END;
+	INSERT INTO webhook_outbox (webhook_id, event, post)
+	SELECT webhooks.id, kind, to_jsonb(p) FROM webhooks
+	WHERE kind = ANY (webhooks.events)
+		AND (webhooks.author = '' OR webhooks.author = p.author)
+		AND (cardinality(webhooks.tags) = 0 OR webhooks.tags && p.tags);
+	RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+DROP INDEX posts_trash_idx;
+ALTER TABLE posts DROP COLUMN deleted_by;
+ALTER TABLE posts DROP COLUMN deleted_at;`,
+	},
+	{
+		Version: 11,
+		Name:    "add post revisions",
+		// the history of existing posts starts with their current state,
+		// by an unknown hand unless they are in the trash
+		Up: `
+CREATE TABLE post_revisions (
+	post_id    integer NOT NULL REFERENCES posts ON DELETE CASCADE,
+	version    integer NOT NULL,
+	event      text NOT NULL,
+	changed_by text NOT NULL,
+	post       jsonb NOT NULL,
+	created_at timestamptz NOT NULL DEFAULT now(),
+	PRIMARY KEY (post_id, version)
+);
+INSERT INTO post_revisions (post_id, version, event, changed_by, post, created_at)
+SELECT id, version,
+	CASE WHEN deleted_at IS NOT NULL THEN 'deleted' WHEN version = 1 THEN 'created' ELSE 'updated' END,
+	deleted_by, to_jsonb(posts), coalesce(deleted_at, updated_at)
+FROM posts;`,
+		Down: `DROP TABLE post_revisions;`,
+	},
+	{
+		Version: 12,
+		Name:    "add delivery traceparent",
+		// the writes of the store set simplerest.traceparent for their
+		// transaction, which is empty or unset otherwise
+		Up: `
+ALTER TABLE webhook_outbox ADD COLUMN traceparent text NOT NULL DEFAULT '';
+CREATE OR REPLACE FUNCTION posts_outbox() RETURNS trigger AS $$
+DECLARE
+	p    posts;
+	kind text;
+BEGIN
+	IF TG_OP = 'INSERT' THEN
+		p := NEW;
+		kind := 'created';
+	ELSIF TG_OP = 'DELETE' THEN
+		IF OLD.deleted_at IS NOT NULL THEN
+			RETURN NULL;
+		END IF;
+		p := OLD;
+		kind := 'deleted';
+	ELSE
+		IF OLD.deleted_at IS NOT NULL AND NEW.deleted_at IS NOT NULL THEN
+			RETURN NULL;
+		END IF;
+		p := NEW;
+		kind := CASE WHEN NEW.deleted_at IS NOT NULL THEN 'deleted' WHEN OLD.deleted_at IS NOT NULL THEN 'created' ELSE 'updated' END;
+	END IF;
+	INSERT INTO webhook_outbox (webhook_id, event, post, traceparent)
+	SELECT webhooks.id, kind, to_jsonb(p), coalesce(current_setting('simplerest.traceparent', true), '') FROM webhooks
+	WHERE kind = ANY (webhooks.events)
+		AND (webhooks.author = '' OR webhooks.author = p.author)
+		AND (cardinality(webhooks.tags) = 0 OR webhooks.tags && p.tags);
+	RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;`,
+		Down: `
+CREATE OR REPLACE FUNCTION posts_outbox() RETURNS trigger AS $$
+DECLARE
+	p    posts;
+	kind text;
+BEGIN
+	IF TG_OP = 'INSERT' THEN
+		p := NEW;
+		kind := 'created';
+	ELSIF TG_OP = 'DELETE' THEN
+		IF OLD.deleted_at IS NOT NULL THEN
+			RETURN NULL;
+		END IF;
+		p := OLD;
+		kind := 'deleted';
+	ELSE
+		IF OLD.deleted_at IS NOT NULL AND NEW.deleted_at IS NOT NULL THEN
+			RETURN NULL;
+		END IF;
+		p := NEW;
+		kind := CASE WHEN NEW.deleted_at IS NOT NULL THEN 'deleted' WHEN OLD.deleted_at IS NOT NULL THEN 'created' ELSE 'updated' END;
+	END IF;
+	INSERT INTO webhook_outbox (webhook_id, event, post)
+	SELECT webhooks.id, kind, to_jsonb(p) FROM webhooks
+	WHERE kind = ANY (webhooks.events)
+		AND (webhooks.author = '' OR webhooks.author = p.author)
+		AND (cardinality(webhooks.tags) = 0 OR webhooks.tags && p.tags);
+	RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+ALTER TABLE webhook_outbox DROP COLUMN traceparent;`,
+	},
+	{
+		Version: 13,
+		Name:    "index post text search words",
+		// the parser keeps hyphenated words, emails, URLs and decimals
+		// whole, which the memory store splits into their letters and
+		// digits; searches now match the text split the same way
+		Up: `
+DROP INDEX posts_text_search_idx;
+CREATE INDEX posts_text_search_idx ON posts USING GIN (to_tsvector('simple', regexp_replace(text, '[^[:alnum:]]+', ' ', 'g')));`,
+		Down: `
+DROP INDEX posts_text_search_idx;
+CREATE INDEX posts_text_search_idx ON posts USING GIN (to_tsvector('simple', text));`,
+	},
+}
+
+// ErrSchemaOutdated is returned by CheckSchema when migrations are pending.
+var ErrSchemaOutdated = errors.New("schema is out of date")
+
+// MigrationState is a migration and when it was applied, zero if pending.
+type MigrationState struct {
+	Migration
+	AppliedAt time.Time
+}
+
+// migrationLock is the advisory lock key serializing migrations between
+// processes.
+const migrationLock = 7302651
+
+const createMigrationsTable = `
+CREATE TABLE IF NOT EXISTS schema_migrations (
+	version    integer PRIMARY KEY,
+	name       text NOT NULL,
+	applied_at timestamptz NOT NULL DEFAULT now()
+)`
+
+// applied returns when each applied migration was applied, by version, or
+// none when no migration ever ran.
+func (ps *PgPostStore) applied(ctx context.Context) (map[int]time.Time, error) {
+	rows, err := ps.pool.QueryEx(ctx, "SELECT version, applied_at FROM schema_migrations", nil)
+	if pgErr, ok := err.(pgx.PgError); ok && pgErr.Code == "42P01" {
+		// undefined_table
+		return map[int]time.Time{}, nil
+	}
+	if err != nil {
+		return nil, classify(err)
+	}
+	defer rows.Close()
+
+	done := map[int]time.Time{}
+	for rows.Next() {
+		var v int
+		var at time.Time
+		if err := rows.Scan(&v, &at); err != nil {
+			return nil, classify(err)
+		}
+		done[v] = at
+	}
+	return done, classify(rows.Err())
+}
+
+// MigrationStatus lists every known migration and whether it is applied.
+func (ps *PgPostStore) MigrationStatus() ([]MigrationState, error) {
+	done, err := ps.applied(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	states := make([]MigrationState, len(migrations))
+	for i, m := range migrations {
+		states[i] = MigrationState{Migration: m, AppliedAt: done[m.Version]}
+	}
+	return states, nil
+}
+
+// CheckSchema fails with ErrSchemaOutdated unless every migration is applied.
+// It only reads, so that readiness probes can run it, and gives up when ctx
+// is done.
+func (ps *PgPostStore) CheckSchema(ctx context.Context) error {
+	done, err := ps.applied(ctx)
+	if err != nil {
+		return err
+	}
+	pending := 0
+	for _, m := range migrations {
+		if _, ok := done[m.Version]; !ok {
+			pending++
+		}
+	}
+	if pending > 0 {
+		return fmt.Errorf("%w: %d of %d migrations pending", ErrSchemaOutdated, pending, len(migrations))
+	}
+	return nil
+}
+
+// MigrateUp applies every pending migration, each in its own transaction,
+// and returns those it applied.
+func (ps *PgPostStore) MigrateUp() ([]Migration, error) {
+	var ran []Migration
+	for _, m := range migrations {
+		ok, err := ps.migrate(m, true)
+		if err != nil {
+			return ran, fmt.Errorf("migration %d %s: %w", m.Version, m.Name, err)
+		}
+		if ok {
+			ran = append(ran, m)
+		}
+	}
+	return ran, nil
+}
+
+// MigrateDown reverts the last steps applied migrations, newest first, and
+// returns those it reverted.
+func (ps *PgPostStore) MigrateDown(steps int) ([]Migration, error) {
+	var ran []Migration
+	for i := len(migrations) - 1; i >= 0 && len(ran) < steps; i-- {
+		m := migrations[i]
+		ok, err := ps.migrate(m, false)
+		if err != nil {
+			return ran, fmt.Errorf("migration %d %s: %w", m.Version, m.Name, err)
+		}
+		if ok {
+			ran = append(ran, m)
+		}
+	}
+	return ran, nil
+}
+
+// migrate applies (up) or reverts m unless that was already done. The
+// advisory lock makes concurrent migrating processes take turns, and the
+// bookkeeping row is written in the same transaction as the change.
+func (ps *PgPostStore) migrate(m Migration, up bool) (bool, error) {
+	if _, err := ps.pool.Exec(createMigrationsTable); err != nil {
+		return false, classify(err)
+	}
+	tx, err := ps.pool.Begin()
+	if err != nil {
+		return false, classify(err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("SELECT pg_advisory_xact_lock($1)", migrationLock); err != nil {
+		return false, classify(err)
+	}
+	var n int
+	if err := tx.QueryRow("SELECT count(*) FROM schema_migrations WHERE version = $1", m.Version).Scan(&n); err != nil {
+		return false, classify(err)
+	}
+	if (n == 1) == up {
+		return false, nil
+	}
+
+	if up {
+		_, err = tx.Exec(m.Up)
+		if err == nil {
+			_, err = tx.Exec("INSERT INTO schema_migrations (version, name) VALUES ($1, $2)", m.Version, m.Name)
+		}
+	} else {
+		_, err = tx.Exec(m.Down)
+		if err == nil {
+			_, err = tx.Exec("DELETE FROM schema_migrations WHERE version = $1", m.Version)
+		}
+	}
+	if err != nil {
+		return false, classify(err)
+	}
+	return true, classify(tx.Commit())
+}
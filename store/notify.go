@@ -0,0 +1,100 @@
+package taskstore
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/jackc/pgx"
+)
+
+// notifyChannel is the channel the posts_notify trigger (migration 5)
+// notifies on, once per written row, when the transaction commits.
+const notifyChannel = "posts_changes"
+
+// listenBackoff bounds the wait between attempts to reopen the listening
+// connection.
+const (
+	minListenBackoff = time.Second
+	maxListenBackoff = 30 * time.Second
+)
+
+// notification is the payload of the posts_notify trigger.
+type notification struct {
+	Op   string  `json:"op"`
+	Post postRow `json:"post"`
+	// Partial is set when the text was left out to fit the payload limit.
+	Partial bool `json:"partial"`
+}
+
+var notifyOps = map[string]string{"insert": Created, "update": Updated, "delete": Deleted}
+
+// listen publishes the notifications of the posts_notify trigger on the
+// change bus until ctx is done, reopening its connection with backoff when
+// it is lost. Writes committed while it is down are not published.
+func (ps *PgPostStore) listen(ctx context.Context, conf pgx.ConnConfig) {
+	defer close(ps.listening)
+	backoff := minListenBackoff
+	for {
+		connected, err := ps.listenOnce(ctx, conf)
+		if ctx.Err() != nil {
+			return
+		}
+		if connected {
+			backoff = minListenBackoff
+		}
+		log.Printf("listening for post changes failed, retrying in %s: %s", backoff, err)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return
+		}
+		if backoff *= 2; backoff > maxListenBackoff {
+			backoff = maxListenBackoff
+		}
+	}
+}
+
+// listenOnce relays notifications on a new connection until it fails.
+// connected reports whether LISTEN succeeded.
+func (ps *PgPostStore) listenOnce(ctx context.Context, conf pgx.ConnConfig) (connected bool, err error) {
+	conn, err := pgx.Connect(conf)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+	if err := conn.Listen(notifyChannel); err != nil {
+		return false, err
+	}
+	for {
+		n, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return true, err
+		}
+		ps.relay(ctx, n.Payload)
+	}
+}
+
+// relay publishes the change described by a notification payload.
+func (ps *PgPostStore) relay(ctx context.Context, payload string) {
+	var n notification
+	if err := json.Unmarshal([]byte(payload), &n); err != nil {
+		log.Printf("ignoring malformed post change notification: %s", err)
+		return
+	}
+	typ, ok := notifyOps[n.Op]
+	if !ok {
+		log.Printf("ignoring post change notification for %q", n.Op)
+		return
+	}
+	p := n.Post.post()
+	if n.Partial && typ != Deleted {
+		// the row is still there unless a later change removed it, whose
+		// own notification follows
+		if full, err := ps.GetPost(ctx, p.ID); err == nil && full.Version == p.Version {
+			p = full
+		}
+	}
+	ps.changes.Publish(typ, p)
+}
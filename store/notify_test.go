@@ -0,0 +1,130 @@
+package taskstore
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+)
+
+// TestNotifyRelay writes through one store and checks that every store on
+// the database publishes the change exactly once: those with
+// PgConfig.Listen from the notification only, the others from their own
+// writes only.
+func TestNotifyRelay(t *testing.T) {
+	dsn := os.Getenv(testDSN)
+	if dsn == "" {
+		t.Skip(testDSN + " is not set")
+	}
+	open := func(listen bool) *PgPostStore {
+		s, err := NewPg(PgConfig{DSN: dsn, MaxConnections: 2, Listen: listen})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+	a, b, local := open(true), open(true), open(false)
+	defer a.Close()
+	defer b.Close()
+	defer local.Close()
+	if _, err := a.MigrateUp(); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	empty := func() {
+		if err := a.DeleteAllPosts(ctx, "test"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := a.PurgeTrash(ctx, time.Now().Add(time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	empty()
+	defer empty()
+
+	changesA, unsubscribeA := a.Changes().Subscribe(16)
+	defer unsubscribeA()
+	changesB, unsubscribeB := b.Changes().Subscribe(16)
+	defer unsubscribeB()
+	changesLocal, unsubscribeLocal := local.Changes().Subscribe(16)
+	defer unsubscribeLocal()
+
+	due := time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC)
+	// both stores LISTEN in the background, and miss what is committed
+	// before: write until they have both seen a post. Notifications come
+	// in commit order, so none of the earlier ones can follow.
+	var post Posts
+	for attempt := 1; ; attempt++ {
+		var err error
+		post, err = a.CreatePost(ctx, "warm up", "alice", nil, due, false, "alice")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if waitFor(changesA, post) && waitFor(changesB, post) {
+			break
+		}
+		if attempt == 10 {
+			t.Fatal("the stores are not listening")
+		}
+	}
+
+	check := func(name string, ch <-chan Change, typ string, want Posts) {
+		t.Helper()
+		got := collect(ch)
+		if len(got) != 1 {
+			t.Fatalf("%s published %d changes, want 1: %+v", name, len(got), got)
+		}
+		if c := got[0]; c.Type != typ || c.Post.ID != want.ID || c.Post.Version != want.Version || c.Post.Text != want.Text {
+			t.Errorf("%s published %s of %+v, want %s of %+v", name, c.Type, c.Post, typ, want)
+		}
+	}
+
+	updated, err := a.UpdatePost(ctx, post.ID, post.Version, "relayed", "alice", nil, due, false, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	check("the writer", changesA, Updated, updated)
+	check("the other listener", changesB, Updated, updated)
+	if got := collect(changesLocal); len(got) != 0 {
+		t.Errorf("a store without Listen published the write of another: %+v", got)
+	}
+
+	created, err := local.CreatePost(ctx, "local", "bob", nil, due, false, "bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	check("the writer without Listen", changesLocal, Created, created)
+	check("a listener", changesB, Created, created)
+}
+
+// waitFor reads changes until one of p, and reports whether it came in
+// time.
+func waitFor(ch <-chan Change, p Posts) bool {
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case c := <-ch:
+			if c.Post.ID == p.ID && c.Post.Version == p.Version {
+				return true
+			}
+		case <-timeout:
+			return false
+		}
+	}
+}
+
+// collect returns the changes published until none has come for half a
+// second, waiting up to five seconds for the first.
+func collect(ch <-chan Change) []Change {
+	var got []Change
+	wait := 5 * time.Second
+	for {
+		select {
+		case c := <-ch:
+			got = append(got, c)
+			wait = 500 * time.Millisecond
+		case <-time.After(wait):
+			return got
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package taskstore
+
+import "fmt"
+
+// Role grants an Actor its rights and those of every role below it.
+type Role string
+
+const (
+	// RoleReader reads public posts and its own private ones.
+	RoleReader Role = "reader"
+	// RoleWriter also writes and deletes its own posts.
+	RoleWriter Role = "writer"
+	// RoleModerator also reads, writes and deletes the posts of others.
+	RoleModerator Role = "moderator"
+	// RoleAdmin also deletes every post at once and manages keys and
+	// webhooks.
+	RoleAdmin Role = "admin"
+)
+
+var roleRank = map[Role]int{RoleReader: 1, RoleWriter: 2, RoleModerator: 3, RoleAdmin: 4}
+
+// ParseRole checks s names a role.
+func ParseRole(s string) (Role, error) {
+	r := Role(s)
+	if roleRank[r] == 0 {
+		return "", fmt.Errorf("%w: unknown role %q, expect reader, writer, moderator or admin", ErrValidation, s)
+	}
+	return r, nil
+}
+
+// Actor is who posts are read or written for. The zero Actor is anonymous
+// and may only read public posts.
+type Actor struct {
+	Name string
+	Role Role
+}
+
+// AtLeast reports whether a has the rights of r.
+func (a Actor) AtLeast(r Role) bool {
+	return roleRank[a.Role] >= roleRank[r]
+}
+
+func (a Actor) forbidden(format string, args ...interface{}) error {
+	who := a.Name
+	if who == "" {
+		who = "anonymous"
+	}
+	return fmt.Errorf("%s may not %s: %w", who, fmt.Sprintf(format, args...), ErrForbidden)
+}
+
+// CanRead reports whether a may see p.
+func (a Actor) CanRead(p Posts) bool {
+	return !p.Private || (a.Name != "" && p.Author == a.Name) || a.AtLeast(RoleModerator)
+}
+
+// Visible narrows f to the posts a may see.
+func (a Actor) Visible(f Filter) Filter {
+	if !a.AtLeast(RoleModerator) {
+		f.HidePrivate = true
+		f.Viewer = a.Name
+	}
+	return f
+}
+
+// Trash narrows f to the posts in the trash a may see: writers their own,
+// moderators every post.
+func (a Actor) Trash(f Filter) (Filter, error) {
+	switch {
+	case !a.AtLeast(RoleWriter):
+		return f, a.forbidden("see the trash")
+	case a.AtLeast(RoleModerator):
+	case f.Author != "" && f.Author != a.Name:
+		return f, a.forbidden("see the trash of %s", f.Author)
+	default:
+		f.Author = a.Name
+	}
+	f.Trashed = true
+	return f, nil
+}
+
+// CanWrite checks a may store a post written by author: writers only as
+// themselves, moderators as anyone.
+func (a Actor) CanWrite(author string) error {
+	switch {
+	case !a.AtLeast(RoleWriter):
+		return a.forbidden("write posts")
+	case author != a.Name && !a.AtLeast(RoleModerator):
+		return a.forbidden("write posts as %s", author)
+	}
+	return nil
+}
+
+// CanChange checks a may update or delete p.
+func (a Actor) CanChange(p Posts) error {
+	switch {
+	case !a.AtLeast(RoleWriter):
+		return a.forbidden("change posts")
+	case p.Author != a.Name && !a.AtLeast(RoleModerator):
+		return a.forbidden("change post %d of %s", p.ID, p.Author)
+	}
+	return nil
+}
+
+// CanAdminister checks a may delete every post and manage keys and
+// webhooks.
+func (a Actor) CanAdminister() error {
+	if !a.AtLeast(RoleAdmin) {
+		return a.forbidden("administer the service")
+	}
+	return nil
+}
@@ -0,0 +1,895 @@
+package taskstore
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx"
+)
+
+// PgConfig configures the connection pool of PgPostStore.
+type PgConfig struct {
+	// DSN is a libpq connection string or postgres:// URL. When empty the
+	// standard PG* environment variables are used. A missing password is
+	// looked up in PGPASSFILE (or ~/.pgpass) by the driver.
+	DSN string
+	// MaxConnections caps the pool size; pgx requires at least 2.
+	MaxConnections int
+	// AcquireTimeout bounds the wait for a free connection, 0 waits forever.
+	AcquireTimeout time.Duration
+	// StatementCache prepares the store's statements once on every pooled
+	// connection instead of on every call.
+	StatementCache bool
+	// SimpleProtocol disables implicit prepared statements, for proxies such
+	// as PgBouncer in transaction mode.
+	SimpleProtocol bool
+	// Listen publishes the changes notified by the database, so that those
+	// written by other processes are seen too, instead of only the writes
+	// of this store. It holds one more connection, which has to be a
+	// session of its own: PgBouncer in transaction mode will not do.
+	Listen bool
+	// Observer, when set, is told about every statement the store runs.
+	Observer QueryObserver
+}
+
+// postColumns is the column list every query selects, in the order scanPost
+// reads them.
+const postColumns = "id, author, text, tags, due, private, version, created_at, updated_at, deleted_at, deleted_by"
+
+// statements are the fixed queries of the store, keyed by the name they are
+// prepared under when the statement cache is enabled.
+var statements = map[string]string{
+	"createPost":     "INSERT INTO posts (id, author, text, tags, due, private, version) VALUES (nextval('postsseq'), $1, $2, $3, $4, $5, 1) RETURNING " + postColumns,
+	"getPost":        "SELECT " + postColumns + " FROM posts WHERE id = $1 AND deleted_at IS NULL",
+	"getVersion":     "SELECT version, deleted_at IS NOT NULL FROM posts WHERE id = $1",
+	"updatePost":     "UPDATE posts SET author = $3, text = $4, tags = $5, due = $6, private = $7, version = version + 1, updated_at = now() WHERE id = $1 AND deleted_at IS NULL AND ($2 = 0 OR version = $2) RETURNING " + postColumns,
+	"trashPost":      "UPDATE posts SET deleted_at = now(), deleted_by = $3, version = version + 1 WHERE id = $1 AND deleted_at IS NULL AND ($2 = 0 OR version = $2) RETURNING " + postColumns,
+	"trashAllPosts":  "UPDATE posts SET deleted_at = now(), deleted_by = $1, version = version + 1 WHERE deleted_at IS NULL RETURNING " + postColumns,
+	"getTrashedPost": "SELECT " + postColumns + " FROM posts WHERE id = $1 AND deleted_at IS NOT NULL",
+	"restorePost":    "UPDATE posts SET deleted_at = NULL, deleted_by = '', version = version + 1, updated_at = now() WHERE id = $1 AND deleted_at IS NOT NULL AND ($2 = 0 OR version = $2) RETURNING " + postColumns,
+	"purgePost":      "DELETE FROM posts WHERE id = $1 AND deleted_at IS NOT NULL",
+	"purgeTrash":     "DELETE FROM posts WHERE deleted_at < $1",
+	// the revisions are snapshots of the rows just written
+	"createRevisions": "INSERT INTO post_revisions (post_id, version, event, changed_by, post) SELECT id, version, $2, $3, to_jsonb(posts) FROM posts WHERE id = ANY($1::integer[])",
+	"listRevisions":   "SELECT " + revisionColumns + " FROM post_revisions WHERE post_id = $1 AND ($2 = 0 OR version < $2) ORDER BY version DESC LIMIT $3",
+	"getRevision":     "SELECT " + revisionColumns + " FROM post_revisions WHERE post_id = $1 AND version = $2",
+
+	"createWebhook": "INSERT INTO webhooks (url, events, author, tags, secret) VALUES ($1, $2, $3, $4, $5) RETURNING " + webhookColumns,
+	"getWebhook":    "SELECT " + webhookColumns + " FROM webhooks WHERE id = $1",
+	"listWebhooks":  "SELECT " + webhookColumns + " FROM webhooks ORDER BY id",
+	"deleteWebhook": "DELETE FROM webhooks WHERE id = $1",
+	// SKIP LOCKED lets processes claim disjoint batches concurrently
+	"claimDeliveries":   "UPDATE webhook_outbox SET next_attempt = now() + $2::bigint * interval '1 millisecond' WHERE id IN (SELECT id FROM webhook_outbox WHERE status = 'pending' AND next_attempt <= now() ORDER BY next_attempt, id LIMIT $1 FOR UPDATE SKIP LOCKED) RETURNING " + deliveryColumns,
+	"finishDelivery":    "UPDATE webhook_outbox SET attempts = attempts + 1, status = $2, next_attempt = $3, last_status = $4, last_error = $5, updated_at = now() WHERE id = $1",
+	"retryDelivery":     "UPDATE webhook_outbox SET status = 'pending', next_attempt = now(), updated_at = now() WHERE id = $1 AND status = 'dead' RETURNING " + deliveryColumns,
+	"getDeliveryStatus": "SELECT status FROM webhook_outbox WHERE id = $1",
+
+	"createAPIKey": "INSERT INTO api_keys (id, name, role, hash) VALUES ($1, $2, $3, $4) RETURNING " + keyColumns,
+	"getAPIKey":    "SELECT " + keyColumns + " FROM api_keys WHERE id = $1",
+	"listAPIKeys":  "SELECT " + keyColumns + " FROM api_keys ORDER BY created_at, id",
+	"deleteAPIKey": "DELETE FROM api_keys WHERE id = $1",
+}
+
+// webhookColumns, deliveryColumns, keyColumns and revisionColumns are the
+// column lists read by scanWebhook, scanDelivery, scanAPIKey and
+// scanRevision.
+const (
+	revisionColumns = "version, event, changed_by, created_at, post"
+	webhookColumns  = "id, url, events, author, tags, secret, created_at"
+	deliveryColumns = "id, webhook_id, event, post, status, attempts, next_attempt, last_status, last_error, traceparent, created_at, updated_at"
+	keyColumns      = "id, name, role, hash, created_at"
+)
+
+// searchVector is the expression of the GIN index on the text of posts,
+// which searches must write the same way to use it. The text is split into
+// runs of letters and digits first, the words tokenize finds, rather than
+// left to the parser, which keeps e.g. hyphenated words and emails whole.
+const searchVector = `to_tsvector('simple', regexp_replace(text, '[^[:alnum:]]+', ' ', 'g'))`
+
+// scanner is implemented by *pgx.Row and *pgx.Rows.
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanPost reads one row selected with postColumns.
+func scanPost(row scanner) (Posts, error) {
+	p := Posts{}
+	err := row.Scan(&p.ID, &p.Author, &p.Text, &p.Tags, &p.Due, &p.Private, &p.Version, &p.Created, &p.Updated, &p.Deleted, &p.DeletedBy)
+	return p, err
+}
+
+// scanRankedPost reads one row selected with postColumns and a rank.
+func scanRankedPost(row scanner) (Posts, error) {
+	p := Posts{}
+	err := row.Scan(&p.ID, &p.Author, &p.Text, &p.Tags, &p.Due, &p.Private, &p.Version, &p.Created, &p.Updated, &p.Deleted, &p.DeletedBy, &p.Rank)
+	return p, err
+}
+
+// postRow is a posts row as to_jsonb renders it, in trigger payloads and the
+// webhook outbox.
+type postRow struct {
+	ID        int        `json:"id"`
+	Author    string     `json:"author"`
+	Text      string     `json:"text"`
+	Tags      []string   `json:"tags"`
+	Due       time.Time  `json:"due"`
+	Private   bool       `json:"private"`
+	Version   int        `json:"version"`
+	Created   time.Time  `json:"created_at"`
+	Updated   time.Time  `json:"updated_at"`
+	Deleted   *time.Time `json:"deleted_at"`
+	DeletedBy string     `json:"deleted_by"`
+}
+
+func (r postRow) post() Posts {
+	return Posts{
+		ID:        r.ID,
+		Author:    r.Author,
+		Text:      r.Text,
+		Tags:      nonNil(r.Tags),
+		Due:       r.Due,
+		Private:   r.Private,
+		Version:   r.Version,
+		Created:   r.Created,
+		Updated:   r.Updated,
+		Deleted:   r.Deleted,
+		DeletedBy: r.DeletedBy,
+	}
+}
+
+// PgPostStore keeps posts in the posts table of a Postgres database.
+type PgPostStore struct {
+	pool     *pgx.ConnPool
+	prepared bool
+	changes  *ChangeBus
+	observe  QueryObserver
+	// stopListening and listening are set when the changes come from
+	// database notifications.
+	stopListening context.CancelFunc
+	listening     chan struct{}
+}
+
+// NewPg opens the connection pool described by cfg.
+func NewPg(cfg PgConfig) (*PgPostStore, error) {
+	var conf pgx.ConnConfig
+	var err error
+	if cfg.DSN == "" {
+		conf, err = pgx.ParseEnvLibpq()
+	} else {
+		conf, err = pgx.ParseConnectionString(cfg.DSN)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("connection string is bad %s", err)
+	}
+	conf.PreferSimpleProtocol = cfg.SimpleProtocol
+
+	ps := &PgPostStore{prepared: cfg.StatementCache && !cfg.SimpleProtocol, changes: NewChangeBus(), observe: cfg.Observer}
+	poolConf := pgx.ConnPoolConfig{
+		ConnConfig:     conf,
+		MaxConnections: cfg.MaxConnections,
+		AcquireTimeout: cfg.AcquireTimeout,
+	}
+	if ps.prepared {
+		poolConf.AfterConnect = prepareStatements
+	}
+
+	ps.pool, err = pgx.NewConnPool(poolConf)
+	if err != nil {
+		return nil, fmt.Errorf("cant connect to db: %w", classify(err))
+	}
+	if cfg.Listen {
+		var ctx context.Context
+		ctx, ps.stopListening = context.WithCancel(context.Background())
+		ps.listening = make(chan struct{})
+		go ps.listen(ctx, conf)
+	}
+	return ps, nil
+}
+
+func prepareStatements(conn *pgx.Conn) error {
+	for name, sql := range statements {
+		if _, err := conn.Prepare(name, sql); err != nil {
+			return fmt.Errorf("cant prepare %s %s", name, err)
+		}
+	}
+	return nil
+}
+
+// sql returns what to send for the named statement: its name when it is
+// prepared on every connection, its text otherwise.
+func (ps *PgPostStore) sql(name string) string {
+	if ps.prepared {
+		return name
+	}
+	return statements[name]
+}
+
+// Close stops listening and closes every connection of the pool.
+func (ps *PgPostStore) Close() {
+	if ps.stopListening != nil {
+		ps.stopListening()
+		<-ps.listening
+	}
+	ps.pool.Close()
+}
+
+// Ping runs a trivial statement on a pooled connection.
+func (ps *PgPostStore) Ping(ctx context.Context) error {
+	_, err := ps.db(ctx).Exec("SELECT 1")
+	return classify(err)
+}
+
+// PoolStats are the connection counts of a pool.
+type PoolStats struct {
+	Max int
+	// Open connections are either idle or in use.
+	Open int
+	Idle int
+}
+
+func (ps *PgPostStore) PoolStats() PoolStats {
+	s := ps.pool.Stat()
+	return PoolStats{Max: s.MaxConnections, Open: s.CurrentConnections, Idle: s.AvailableConnections}
+}
+
+// Changes publishes the writes of every process sharing the database with
+// PgConfig.Listen, only those made through this store otherwise.
+func (ps *PgPostStore) Changes() *ChangeBus {
+	return ps.changes
+}
+
+// publish publishes a write of this store, unless its notification will.
+func (ps *PgPostStore) publish(typ string, p Posts) {
+	if ps.stopListening == nil {
+		ps.changes.Publish(typ, p)
+	}
+}
+
+// classify wraps a driver error with the matching store error.
+func classify(err error) error {
+	if err == nil {
+		return nil
+	}
+	if pgErr, ok := err.(pgx.PgError); ok {
+		switch {
+		case pgErr.Code == "23505":
+			return fmt.Errorf("%w: %s", ErrConflict, pgErr.Message)
+		case strings.HasPrefix(pgErr.Code, "22"), strings.HasPrefix(pgErr.Code, "23"):
+			return fmt.Errorf("%w: %s", ErrValidation, pgErr.Message)
+		case strings.HasPrefix(pgErr.Code, "08"), strings.HasPrefix(pgErr.Code, "53"), strings.HasPrefix(pgErr.Code, "57P"):
+			return fmt.Errorf("%w: %s", ErrUnavailable, pgErr.Message)
+		}
+		return fmt.Errorf("postgres: %s", pgErr.Message)
+	}
+	// anything below the SQL layer (dial, acquire timeout, dead connection)
+	// means the database cannot be used right now
+	return fmt.Errorf("%w: %s", ErrUnavailable, err)
+}
+
+func (ps *PgPostStore) CreatePost(ctx context.Context, text, author string, tags []string, due time.Time, private bool, by string) (Posts, error) {
+	if err := validate(text, author, tags); err != nil {
+		return Posts{}, err
+	}
+
+	var p Posts
+	err := ps.db(ctx).inTx(func(tx *pgx.Tx) (err error) {
+		if p, err = scanPost(tx.QueryRow(ps.sql("createPost"), author, text, nonNil(tags), due, private)); err != nil {
+			return err
+		}
+		return ps.revise(tx, Created, by, p.ID)
+	})
+	if err != nil {
+		return Posts{}, classify(err)
+	}
+	ps.publish(Created, p)
+	return p, nil
+}
+
+func (ps *PgPostStore) GetPost(ctx context.Context, id int) (Posts, error) {
+	//one row
+	p, err := scanPost(ps.db(ctx).QueryRow(ps.sql("getPost"), id))
+	if err == pgx.ErrNoRows {
+		return Posts{}, notFound(id)
+	}
+	if err != nil {
+		return Posts{}, classify(err)
+	}
+	return p, nil
+}
+
+func (ps *PgPostStore) UpdatePost(ctx context.Context, id int, version int, text, author string, tags []string, due time.Time, private bool, by string) (Posts, error) {
+	if err := validate(text, author, tags); err != nil {
+		return Posts{}, err
+	}
+
+	// the version check and the write are one statement, so a concurrent
+	// update cannot slip in between them
+	var p Posts
+	err := ps.db(ctx).inTx(func(tx *pgx.Tx) (err error) {
+		if p, err = scanPost(tx.QueryRow(ps.sql("updatePost"), id, version, author, text, nonNil(tags), due, private)); err != nil {
+			return err
+		}
+		return ps.revise(tx, Updated, by, id)
+	})
+	if err == pgx.ErrNoRows {
+		return Posts{}, ps.missed(ctx, id, version, false)
+	}
+	if err != nil {
+		return Posts{}, classify(err)
+	}
+	ps.publish(Updated, p)
+	return p, nil
+}
+
+func (ps *PgPostStore) DeletePost(ctx context.Context, id int, version int, by string) error {
+	var p Posts
+	err := ps.db(ctx).inTx(func(tx *pgx.Tx) (err error) {
+		if p, err = scanPost(tx.QueryRow(ps.sql("trashPost"), id, version, by)); err != nil {
+			return err
+		}
+		return ps.revise(tx, Deleted, by, id)
+	})
+	if err == pgx.ErrNoRows {
+		return ps.missed(ctx, id, version, false)
+	}
+	if err != nil {
+		return classify(err)
+	}
+	ps.publish(Deleted, p)
+	return nil
+}
+
+func (ps *PgPostStore) GetTrashedPost(ctx context.Context, id int) (Posts, error) {
+	p, err := scanPost(ps.db(ctx).QueryRow(ps.sql("getTrashedPost"), id))
+	if err == pgx.ErrNoRows {
+		return Posts{}, trashedNotFound(id)
+	}
+	if err != nil {
+		return Posts{}, classify(err)
+	}
+	return p, nil
+}
+
+func (ps *PgPostStore) RestorePost(ctx context.Context, id int, version int, by string) (Posts, error) {
+	var p Posts
+	err := ps.db(ctx).inTx(func(tx *pgx.Tx) (err error) {
+		if p, err = scanPost(tx.QueryRow(ps.sql("restorePost"), id, version)); err != nil {
+			return err
+		}
+		return ps.revise(tx, Restored, by, id)
+	})
+	if err == pgx.ErrNoRows {
+		return Posts{}, ps.missed(ctx, id, version, true)
+	}
+	if err != nil {
+		return Posts{}, classify(err)
+	}
+	ps.publish(Created, p)
+	return p, nil
+}
+
+func (ps *PgPostStore) PurgePost(ctx context.Context, id int) error {
+	ct, err := ps.db(ctx).Exec(ps.sql("purgePost"), id)
+	if err != nil {
+		return classify(err)
+	}
+	if ct.RowsAffected() == 0 {
+		return ps.missed(ctx, id, 0, true)
+	}
+	return nil
+}
+
+func (ps *PgPostStore) PurgeTrash(ctx context.Context, before time.Time) (int, error) {
+	ct, err := ps.db(ctx).Exec(ps.sql("purgeTrash"), before)
+	if err != nil {
+		return 0, classify(err)
+	}
+	return int(ct.RowsAffected()), nil
+}
+
+// revise keeps the revisions the write of event by by left to posts ids,
+// in the transaction of the write.
+func (ps *PgPostStore) revise(tx *pgx.Tx, event, by string, ids ...int) error {
+	_, err := tx.Exec(ps.sql("createRevisions"), ids, event, by)
+	return err
+}
+
+// nonNil stores a missing tag list as an empty array rather than NULL, which
+// reads back as [] like it does from the memory store.
+func nonNil(tags []string) []string {
+	if tags == nil {
+		return []string{}
+	}
+	return tags
+}
+
+// missed explains why a conditional write of post id touched no row.
+// trashed tells whether the write expected the post in the trash.
+func (ps *PgPostStore) missed(ctx context.Context, id, version int, trashed bool) error {
+	var have int
+	var inTrash bool
+	err := ps.db(ctx).QueryRow(ps.sql("getVersion"), id).Scan(&have, &inTrash)
+	switch {
+	case err == pgx.ErrNoRows && trashed:
+		return trashedNotFound(id)
+	case err == pgx.ErrNoRows:
+		return notFound(id)
+	case err != nil:
+		return classify(err)
+	case inTrash != trashed && trashed:
+		return notTrashed(id)
+	case inTrash != trashed:
+		return notFound(id)
+	}
+	return versionMismatch(id, version, have)
+}
+
+func (ps *PgPostStore) DeleteAllPosts(ctx context.Context, by string) error {
+	// the trashed rows come back so every delete can be published
+	var posts []Posts
+	err := ps.db(ctx).inTx(func(tx *pgx.Tx) error {
+		rows, err := tx.Query(ps.sql("trashAllPosts"), by)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		ids := []int{}
+		for rows.Next() {
+			p, err := scanPost(rows)
+			if err != nil {
+				return err
+			}
+			posts = append(posts, p)
+			ids = append(ids, p.ID)
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		// the connection reads no other statement before the rows are done
+		rows.Close()
+		return ps.revise(tx, Deleted, by, ids...)
+	})
+	if err != nil {
+		return classify(err)
+	}
+	for _, p := range posts {
+		ps.publish(Deleted, p)
+	}
+	return nil
+}
+
+// sortColumns are the ORDER BY columns of each sort key. Authors compare
+// bytewise like the memory store does, whatever the database collation.
+var sortColumns = map[string][]string{
+	"id":      {"id"},
+	"due":     {"due", "id"},
+	"author":  {`author COLLATE "C"`, "id"},
+	"deleted": {"deleted_at", "id"},
+}
+
+// sqlArgs collects query arguments and hands out their placeholders.
+type sqlArgs []interface{}
+
+func (a *sqlArgs) add(v interface{}) string {
+	*a = append(*a, v)
+	return fmt.Sprintf("$%d", len(*a))
+}
+
+// where renders f as the conditions of a WHERE clause. There is always
+// one, on whether the posts are in the trash.
+func (f Filter) where(args *sqlArgs) []string {
+	conds := []string{"deleted_at IS NULL"}
+	if f.Trashed {
+		conds[0] = "deleted_at IS NOT NULL"
+	}
+	if f.Author != "" {
+		conds = append(conds, "author = "+args.add(f.Author))
+	}
+	if len(f.Tags) > 0 {
+		// overlap and containment cover the whole array and use the GIN
+		// index on tags
+		op := "&&"
+		if f.AllTags {
+			op = "@>"
+		}
+		conds = append(conds, "tags "+op+" "+args.add(f.Tags)+"::text[]")
+	}
+	if !f.DueFrom.IsZero() {
+		conds = append(conds, "due >= "+args.add(f.DueFrom))
+	}
+	if !f.DueTo.IsZero() {
+		conds = append(conds, "due < "+args.add(f.DueTo))
+	}
+	if f.Text != "" {
+		conds = append(conds, "strpos(lower(text), lower("+args.add(f.Text)+")) > 0")
+	}
+	if f.Search != nil {
+		conds = append(conds, searchVector+" @@ to_tsquery('simple', "+args.add(f.Search.tsquery())+")")
+	}
+	if f.HidePrivate {
+		conds = append(conds, "(NOT private OR author = "+args.add(f.Viewer)+")")
+	}
+	return conds
+}
+
+func (ps *PgPostStore) ListPosts(ctx context.Context, f Filter, opts ListOptions) (Page, error) {
+	key, desc, err := parseSort(opts.Sort, f)
+	if err != nil {
+		return Page{}, err
+	}
+	if err := checkLimit(opts); err != nil {
+		return Page{}, err
+	}
+	c, err := decodeCursor(opts)
+	if err != nil {
+		return Page{}, err
+	}
+
+	var args sqlArgs
+	conds := f.where(&args)
+	filterArgs := len(args)
+
+	// fetch in the direction the page is walked, one row beyond the limit
+	// to learn whether there is more
+	reverse := desc
+	if c != nil && c.Before {
+		reverse = !reverse
+	}
+	cmp, dir := ">", "ASC"
+	if reverse {
+		cmp, dir = "<", "DESC"
+	}
+	cols := sortColumns[key]
+	columns, scan := postColumns, scanPost
+	if f.Search != nil {
+		rank := "ts_rank(" + searchVector + ", to_tsquery('simple', " + args.add(f.Search.tsquery()) + "), 1)::float8"
+		columns, scan = postColumns+", "+rank, scanRankedPost
+		if key == "rank" {
+			cols = []string{rank, "id"}
+		}
+	}
+	if c != nil {
+		switch key {
+		case "id":
+			conds = append(conds, "id "+cmp+" "+args.add(c.ID))
+		case "due":
+			conds = append(conds, fmt.Sprintf("(due, id) %s (%s, %s)", cmp, args.add(c.Due), args.add(c.ID)))
+		case "author":
+			conds = append(conds, fmt.Sprintf(`(author COLLATE "C", id) %s (%s::text COLLATE "C", %s)`, cmp, args.add(c.Author), args.add(c.ID)))
+		case "rank":
+			conds = append(conds, fmt.Sprintf("(%s, id) %s (%s, %s)", cols[0], cmp, args.add(c.Rank), args.add(c.ID)))
+		case "deleted":
+			conds = append(conds, fmt.Sprintf("(deleted_at, id) %s (%s, %s)", cmp, args.add(c.Deleted), args.add(c.ID)))
+		}
+	}
+	order := make([]string, len(cols))
+	for i, col := range cols {
+		order[i] = col + " " + dir
+	}
+
+	sql := "SELECT " + columns + " FROM posts WHERE " + strings.Join(conds, " AND ")
+	sql += " ORDER BY " + strings.Join(order, ", ") + " LIMIT " + args.add(opts.Limit+1)
+
+	rows, err := ps.query(ctx, scan, sql, args...)
+	if err != nil {
+		return Page{}, err
+	}
+	page := makePage(rows, c, opts)
+
+	if opts.Total {
+		sql := "SELECT count(*) FROM posts WHERE " + strings.Join(f.where(new(sqlArgs)), " AND ")
+		if err := ps.db(ctx).QueryRow(sql, args[:filterArgs]...).Scan(&page.Total); err != nil {
+			return Page{}, classify(err)
+		}
+	}
+	return page, nil
+}
+
+// query runs a statement returning posts rows and scans every row with
+// scan.
+func (ps *PgPostStore) query(ctx context.Context, scan func(scanner) (Posts, error), sql string, args ...interface{}) ([]Posts, error) {
+	//get rows
+	all, err := ps.db(ctx).Query(sql, args...)
+	if err != nil {
+		return nil, classify(err)
+	}
+	defer all.Close()
+
+	//walk to posts
+	posts := []Posts{}
+	for all.Next() {
+		// scanning values
+		p, err := scan(all)
+		if err != nil {
+			return nil, classify(err)
+		}
+		posts = append(posts, p)
+	}
+	if err := all.Err(); err != nil {
+		return nil, classify(err)
+	}
+	return posts, nil
+}
+
+func scanRevision(row scanner) (Revision, error) {
+	r := Revision{}
+	var post []byte
+	err := row.Scan(&r.Number, &r.Event, &r.By, &r.Created, &post)
+	if err != nil {
+		return r, err
+	}
+	var p postRow
+	if err := json.Unmarshal(post, &p); err != nil {
+		return r, fmt.Errorf("revision %d has a malformed post: %s", r.Number, err)
+	}
+	r.Post = p.post()
+	return r, nil
+}
+
+func (ps *PgPostStore) ListRevisions(ctx context.Context, id int, before int, limit int) ([]Revision, error) {
+	rows, err := ps.db(ctx).Query(ps.sql("listRevisions"), id, before, limit)
+	if err != nil {
+		return nil, classify(err)
+	}
+	defer rows.Close()
+
+	list := []Revision{}
+	for rows.Next() {
+		r, err := scanRevision(rows)
+		if err != nil {
+			return nil, classify(err)
+		}
+		list = append(list, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, classify(err)
+	}
+	return list, nil
+}
+
+func (ps *PgPostStore) GetRevision(ctx context.Context, id int, number int) (Revision, error) {
+	r, err := scanRevision(ps.db(ctx).QueryRow(ps.sql("getRevision"), id, number))
+	if err == pgx.ErrNoRows {
+		return Revision{}, revisionNotFound(id, number)
+	}
+	if err != nil {
+		return Revision{}, classify(err)
+	}
+	return r, nil
+}
+
+func scanWebhook(row scanner) (Webhook, error) {
+	h := Webhook{}
+	err := row.Scan(&h.ID, &h.URL, &h.Events, &h.Author, &h.Tags, &h.Secret, &h.Created)
+	return h, err
+}
+
+func scanDelivery(row scanner) (Delivery, error) {
+	d := Delivery{}
+	var post []byte
+	err := row.Scan(&d.ID, &d.Webhook, &d.Event, &post, &d.Status, &d.Attempts, &d.NextAttempt, &d.LastStatus, &d.LastError, &d.Traceparent, &d.Created, &d.Updated)
+	if err != nil {
+		return d, err
+	}
+	var r postRow
+	if err := json.Unmarshal(post, &r); err != nil {
+		return d, fmt.Errorf("delivery %d has a malformed post: %s", d.ID, err)
+	}
+	d.Post = r.post()
+	return d, nil
+}
+
+func (ps *PgPostStore) CreateWebhook(ctx context.Context, h Webhook) (Webhook, error) {
+	if err := validateWebhook(h); err != nil {
+		return Webhook{}, err
+	}
+
+	h, err := scanWebhook(ps.db(ctx).QueryRow(ps.sql("createWebhook"), h.URL, h.Events, h.Author, nonNil(h.Tags), h.Secret))
+	if err != nil {
+		return Webhook{}, classify(err)
+	}
+	return h, nil
+}
+
+func (ps *PgPostStore) GetWebhook(ctx context.Context, id int) (Webhook, error) {
+	h, err := scanWebhook(ps.db(ctx).QueryRow(ps.sql("getWebhook"), id))
+	if err == pgx.ErrNoRows {
+		return Webhook{}, webhookNotFound(id)
+	}
+	if err != nil {
+		return Webhook{}, classify(err)
+	}
+	return h, nil
+}
+
+func (ps *PgPostStore) ListWebhooks(ctx context.Context) ([]Webhook, error) {
+	rows, err := ps.db(ctx).Query(ps.sql("listWebhooks"))
+	if err != nil {
+		return nil, classify(err)
+	}
+	defer rows.Close()
+
+	hooks := []Webhook{}
+	for rows.Next() {
+		h, err := scanWebhook(rows)
+		if err != nil {
+			return nil, classify(err)
+		}
+		hooks = append(hooks, h)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, classify(err)
+	}
+	return hooks, nil
+}
+
+func (ps *PgPostStore) DeleteWebhook(ctx context.Context, id int) error {
+	ct, err := ps.db(ctx).Exec(ps.sql("deleteWebhook"), id)
+	if err != nil {
+		return classify(err)
+	}
+	if ct.RowsAffected() == 0 {
+		return webhookNotFound(id)
+	}
+	return nil
+}
+
+func (ps *PgPostStore) ClaimDeliveries(ctx context.Context, limit int, lease time.Duration) ([]Delivery, error) {
+	list, err := ps.deliveries(ctx, ps.sql("claimDeliveries"), limit, lease.Milliseconds())
+	if err != nil {
+		return nil, err
+	}
+	// RETURNING does not keep the order of the subquery
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list, nil
+}
+
+func (ps *PgPostStore) FinishDelivery(ctx context.Context, id int64, a Attempt) error {
+	status, next := DeliveryPending, a.RetryAt
+	switch {
+	case a.Delivered:
+		status, next = DeliveryDelivered, time.Now()
+	case a.RetryAt.IsZero():
+		status, next = DeliveryDead, time.Now()
+	}
+	ct, err := ps.db(ctx).Exec(ps.sql("finishDelivery"), id, status, next, a.Status, a.Error)
+	if err != nil {
+		return classify(err)
+	}
+	if ct.RowsAffected() == 0 {
+		return deliveryNotFound(id)
+	}
+	return nil
+}
+
+func (ps *PgPostStore) ListDeliveries(ctx context.Context, webhook int, status string, before int64, limit int) ([]Delivery, error) {
+	if err := checkDeliveryStatus(status); err != nil {
+		return nil, err
+	}
+
+	var args sqlArgs
+	var conds []string
+	if webhook != 0 {
+		conds = append(conds, "webhook_id = "+args.add(webhook))
+	}
+	if status != "" {
+		conds = append(conds, "status = "+args.add(status))
+	}
+	if before != 0 {
+		conds = append(conds, "id < "+args.add(before))
+	}
+	sql := "SELECT " + deliveryColumns + " FROM webhook_outbox"
+	if len(conds) > 0 {
+		sql += " WHERE " + strings.Join(conds, " AND ")
+	}
+	sql += " ORDER BY id DESC LIMIT " + args.add(limit)
+	return ps.deliveries(ctx, sql, args...)
+}
+
+func (ps *PgPostStore) RetryDelivery(ctx context.Context, id int64) (Delivery, error) {
+	d, err := scanDelivery(ps.db(ctx).QueryRow(ps.sql("retryDelivery"), id))
+	if err != pgx.ErrNoRows {
+		if err != nil {
+			return Delivery{}, classify(err)
+		}
+		return d, nil
+	}
+
+	var status string
+	err = ps.db(ctx).QueryRow(ps.sql("getDeliveryStatus"), id).Scan(&status)
+	if err == pgx.ErrNoRows {
+		return Delivery{}, deliveryNotFound(id)
+	}
+	if err != nil {
+		return Delivery{}, classify(err)
+	}
+	return Delivery{}, fmt.Errorf("delivery %d is %s, not dead: %w", id, status, ErrConflict)
+}
+
+// deliveries runs a statement returning webhook_outbox rows and scans every
+// row.
+func (ps *PgPostStore) deliveries(ctx context.Context, sql string, args ...interface{}) ([]Delivery, error) {
+	rows, err := ps.db(ctx).Query(sql, args...)
+	if err != nil {
+		return nil, classify(err)
+	}
+	defer rows.Close()
+
+	list := []Delivery{}
+	for rows.Next() {
+		d, err := scanDelivery(rows)
+		if err != nil {
+			return nil, classify(err)
+		}
+		list = append(list, d)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, classify(err)
+	}
+	return list, nil
+}
+
+func scanAPIKey(row scanner) (APIKey, error) {
+	k := APIKey{}
+	err := row.Scan(&k.ID, &k.Name, &k.Role, &k.Hash, &k.Created)
+	return k, err
+}
+
+func (ps *PgPostStore) CreateAPIKey(ctx context.Context, k APIKey) (APIKey, error) {
+	if err := validateAPIKey(k); err != nil {
+		return APIKey{}, err
+	}
+
+	k, err := scanAPIKey(ps.db(ctx).QueryRow(ps.sql("createAPIKey"), k.ID, k.Name, string(k.Role), k.Hash))
+	if err != nil {
+		return APIKey{}, classify(err)
+	}
+	return k, nil
+}
+
+func (ps *PgPostStore) GetAPIKey(ctx context.Context, id string) (APIKey, error) {
+	k, err := scanAPIKey(ps.db(ctx).QueryRow(ps.sql("getAPIKey"), id))
+	if err == pgx.ErrNoRows {
+		return APIKey{}, keyNotFound(id)
+	}
+	if err != nil {
+		return APIKey{}, classify(err)
+	}
+	return k, nil
+}
+
+func (ps *PgPostStore) ListAPIKeys(ctx context.Context) ([]APIKey, error) {
+	rows, err := ps.db(ctx).Query(ps.sql("listAPIKeys"))
+	if err != nil {
+		return nil, classify(err)
+	}
+	defer rows.Close()
+
+	keys := []APIKey{}
+	for rows.Next() {
+		k, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, classify(err)
+		}
+		keys = append(keys, k)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, classify(err)
+	}
+	return keys, nil
+}
+
+func (ps *PgPostStore) DeleteAPIKey(ctx context.Context, id string) error {
+	ct, err := ps.db(ctx).Exec(ps.sql("deleteAPIKey"), id)
+	if err != nil {
+		return classify(err)
+	}
+	if ct.RowsAffected() == 0 {
+		return keyNotFound(id)
+	}
+	return nil
+}
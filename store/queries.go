@@ -0,0 +1,174 @@
+package taskstore
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx"
+)
+
+// Query describes a statement run by a PgPostStore, as reported by the
+// driver once it has run.
+type Query struct {
+	// Name is the name of the statement when it is one of the store's
+	// fixed statements, SQL its text with placeholders for the arguments.
+	Name string
+	SQL  string
+	// Start is when the statement was sent; Duration is zero for failed
+	// ones, which the driver does not time.
+	Start    time.Time
+	Duration time.Duration
+	// Rows is the number of rows returned or affected.
+	Rows int64
+	Err  error
+}
+
+// QueryObserver is told about every statement run by a PgPostStore, with
+// the context of the store call that ran it. It must not block.
+type QueryObserver func(ctx context.Context, q Query)
+
+// queryLogger turns the statement logs of the driver into Querys for the
+// observer of one store call.
+type queryLogger struct {
+	ctx     context.Context
+	observe QueryObserver
+}
+
+func (l queryLogger) Log(level pgx.LogLevel, msg string, data map[string]interface{}) {
+	if msg != "Query" && msg != "Exec" {
+		return
+	}
+	q := Query{}
+	q.SQL, _ = data["sql"].(string)
+	if _, ok := statements[q.SQL]; ok {
+		q.Name, q.SQL = q.SQL, statements[q.SQL]
+	} else {
+		for name, sql := range statements {
+			if sql == q.SQL {
+				q.Name = name
+				break
+			}
+		}
+	}
+	q.Duration, _ = data["time"].(time.Duration)
+	q.Start = time.Now().Add(-q.Duration)
+	switch v := data["rowCount"].(type) {
+	case int:
+		q.Rows = int64(v)
+	}
+	if tag, ok := data["commandTag"].(pgx.CommandTag); ok {
+		q.Rows = tag.RowsAffected()
+	}
+	q.Err, _ = data["err"].(error)
+	l.observe(l.ctx, q)
+}
+
+// db runs the statements of a store call made with ctx. Each one gets a
+// connection of its own from the pool, like it would from the pool itself,
+// which reports it to the QueryObserver with ctx.
+type db struct {
+	ps  *PgPostStore
+	ctx context.Context
+}
+
+func (ps *PgPostStore) db(ctx context.Context) db {
+	return db{ps: ps, ctx: ctx}
+}
+
+// acquire takes a connection from the pool, to be given back by calling
+// release.
+func (d db) acquire() (c *pgx.Conn, release func(), err error) {
+	c, err = d.ps.pool.Acquire()
+	if err != nil {
+		return nil, nil, err
+	}
+	if d.ps.observe == nil {
+		return c, func() { d.ps.pool.Release(c) }, nil
+	}
+	logger := c.SetLogger(queryLogger{ctx: d.ctx, observe: d.ps.observe})
+	level, _ := c.SetLogLevel(pgx.LogLevelInfo)
+	return c, func() {
+		c.SetLogger(logger)
+		c.SetLogLevel(level)
+		d.ps.pool.Release(c)
+	}, nil
+}
+
+// QueryRow acquires a connection when the row is scanned.
+func (d db) QueryRow(sql string, args ...interface{}) scanner {
+	return rowFunc(func(dest ...interface{}) error {
+		c, release, err := d.acquire()
+		if err != nil {
+			return err
+		}
+		defer release()
+		return c.QueryRow(sql, args...).Scan(dest...)
+	})
+}
+
+func (d db) Query(sql string, args ...interface{}) (*rows, error) {
+	c, release, err := d.acquire()
+	if err != nil {
+		return nil, err
+	}
+	r, err := c.Query(sql, args...)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	return &rows{Rows: r, release: release}, nil
+}
+
+func (d db) Exec(sql string, args ...interface{}) (pgx.CommandTag, error) {
+	c, release, err := d.acquire()
+	if err != nil {
+		return "", err
+	}
+	defer release()
+	return c.Exec(sql, args...)
+}
+
+// inTx runs fn in a transaction on one connection, which is committed when
+// fn returns nil and rolled back otherwise. The traceparent of ctx is set
+// for the transaction, for the deliveries its writes queue.
+func (d db) inTx(fn func(tx *pgx.Tx) error) error {
+	c, release, err := d.acquire()
+	if err != nil {
+		return err
+	}
+	defer release()
+	tx, err := c.Begin()
+	if err != nil {
+		return err
+	}
+	// a no-op once committed
+	defer tx.Rollback()
+	if tp := traceparentFrom(d.ctx); tp != "" {
+		if _, err := tx.Exec("SELECT set_config('simplerest.traceparent', $1, true)", tp); err != nil {
+			return err
+		}
+	}
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+type rowFunc func(dest ...interface{}) error
+
+func (f rowFunc) Scan(dest ...interface{}) error {
+	return f(dest...)
+}
+
+// rows gives the connection back once they are closed.
+type rows struct {
+	*pgx.Rows
+	release func()
+	once    sync.Once
+}
+
+func (r *rows) Close() {
+	r.Rows.Close()
+	r.once.Do(r.release)
+}
@@ -0,0 +1,37 @@
+package taskstore
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Restored is the event of the revision taking a post out of the trash,
+// which is published as Created.
+const Restored = "restored"
+
+// Revision is a post as one change left it. Revisions are never changed,
+// and are numbered by the version of the post they hold.
+type Revision struct {
+	Number int `json:"revision"`
+	// Event is created, updated, deleted or restored.
+	Event string `json:"event"`
+	// By is who made the change.
+	By      string    `json:"by"`
+	Created time.Time `json:"created"`
+	Post    Posts     `json:"post"`
+}
+
+// RevisionStore keeps a revision for every write of a post, atomically
+// with it. They go when the post is purged from the trash.
+type RevisionStore interface {
+	// ListRevisions returns the revisions of post id newest first, with a
+	// number below before (0 for no bound). It is empty for a post that
+	// does not exist.
+	ListRevisions(ctx context.Context, id int, before int, limit int) ([]Revision, error)
+	GetRevision(ctx context.Context, id int, number int) (Revision, error)
+}
+
+func revisionNotFound(id, number int) error {
+	return fmt.Errorf("revision %d of post %d %w", number, id, ErrNotFound)
+}
@@ -0,0 +1,622 @@
+package taskstore
+
+import (
+	"fmt"
+	"html"
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Searches are written in a small query language:
+//
+//	deploy friday          posts with both words
+//	"deploy on friday"     the words next to each other, in this order
+//	depl*                  words starting with depl
+//	deploy OR release      either word, OR binds tighter than AND
+//	-friday, NOT friday    posts without the word
+//	(deploy OR release) AND NOT friday
+//
+// Words are runs of letters and digits, compared ignoring case and without
+// stemming or stop words, like the simple text search configuration of
+// Postgres. Everything else separates words: "e-mail", "bob@example.com"
+// and "3.14" are two, three and two words, which the Postgres parser would
+// keep whole, so the Postgres store replaces the rest with spaces before
+// it matches with to_tsvector and to_tsquery. The memory store matches
+// with an inverted index of its own.
+
+// maxSearchTerms bounds the words of a search.
+const maxSearchTerms = 32
+
+// SearchQuery is a parsed search.
+type SearchQuery struct {
+	text string
+	root *searchNode
+}
+
+type searchOp int
+
+const (
+	searchPhrase searchOp = iota
+	searchAnd
+	searchOr
+	searchNot
+)
+
+// searchNode is a phrase of terms, which is a single word most of the
+// time, or an operator on args.
+type searchNode struct {
+	op    searchOp
+	terms []searchTerm
+	args  []*searchNode
+}
+
+type searchTerm struct {
+	word   string
+	prefix bool
+}
+
+// ParseSearch parses s. Errors wrap ErrValidation.
+func ParseSearch(s string) (*SearchQuery, error) {
+	items, err := lexSearch(s)
+	if err != nil {
+		return nil, err
+	}
+	p := &searchParser{items: items}
+	root, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	if !p.done() {
+		return nil, searchError("unbalanced )")
+	}
+	if root == nil {
+		return nil, searchError("no words to search for")
+	}
+	if p.terms > maxSearchTerms {
+		return nil, searchError(fmt.Sprintf("more than %d words", maxSearchTerms))
+	}
+	return &SearchQuery{text: s, root: root}, nil
+}
+
+func searchError(msg string) error {
+	return fmt.Errorf("%w: search: %s", ErrValidation, msg)
+}
+
+// String returns the search as it was written.
+func (q *SearchQuery) String() string {
+	return q.text
+}
+
+type searchItemKind int
+
+const (
+	itemWord searchItemKind = iota
+	itemPhrase
+	itemLParen
+	itemRParen
+	itemAnd
+	itemOr
+	itemNot
+)
+
+type searchItem struct {
+	kind searchItemKind
+	text string
+}
+
+func lexSearch(s string) ([]searchItem, error) {
+	var items []searchItem
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '(':
+			items = append(items, searchItem{kind: itemLParen})
+			i++
+		case c == ')':
+			items = append(items, searchItem{kind: itemRParen})
+			i++
+		case c == '"':
+			end := strings.IndexByte(s[i+1:], '"')
+			if end < 0 {
+				return nil, searchError("unterminated quote")
+			}
+			items = append(items, searchItem{kind: itemPhrase, text: s[i+1 : i+1+end]})
+			i += end + 2
+		case c == '-':
+			// only a leading - negates, one inside a word separates words
+			items = append(items, searchItem{kind: itemNot})
+			i++
+		default:
+			end := i
+			for end < len(s) && !strings.ContainsRune(" \t\n\r()\"", rune(s[end])) {
+				end++
+			}
+			word := s[i:end]
+			switch word {
+			case "AND":
+				items = append(items, searchItem{kind: itemAnd})
+			case "OR":
+				items = append(items, searchItem{kind: itemOr})
+			case "NOT":
+				items = append(items, searchItem{kind: itemNot})
+			default:
+				items = append(items, searchItem{kind: itemWord, text: word})
+			}
+			i = end
+		}
+	}
+	return items, nil
+}
+
+type searchParser struct {
+	items []searchItem
+	pos   int
+	terms int
+}
+
+func (p *searchParser) done() bool {
+	return p.pos >= len(p.items)
+}
+
+func (p *searchParser) peek(kind searchItemKind) bool {
+	return !p.done() && p.items[p.pos].kind == kind
+}
+
+// parseAnd parses terms up to the end or a closing parenthesis. It returns
+// nil when they have no words.
+func (p *searchParser) parseAnd() (*searchNode, error) {
+	var args []*searchNode
+	for !p.done() && !p.peek(itemRParen) {
+		if p.peek(itemAnd) {
+			p.pos++
+			if len(args) == 0 || p.done() || p.peek(itemRParen) || p.peek(itemAnd) || p.peek(itemOr) {
+				return nil, searchError("AND needs a term on both sides")
+			}
+			continue
+		}
+		if p.peek(itemOr) {
+			return nil, searchError("OR needs a term on both sides")
+		}
+		n, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if n != nil {
+			args = append(args, n)
+		}
+	}
+	return combine(searchAnd, args), nil
+}
+
+func (p *searchParser) parseOr() (*searchNode, error) {
+	n, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	args := []*searchNode{n}
+	for p.peek(itemOr) {
+		p.pos++
+		if p.done() || p.peek(itemRParen) || p.peek(itemAnd) || p.peek(itemOr) {
+			return nil, searchError("OR needs a term on both sides")
+		}
+		m, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, m)
+	}
+	var kept []*searchNode
+	for _, a := range args {
+		if a != nil {
+			kept = append(kept, a)
+		}
+	}
+	return combine(searchOr, kept), nil
+}
+
+func (p *searchParser) parseUnary() (*searchNode, error) {
+	if p.peek(itemNot) {
+		p.pos++
+		if p.done() || p.peek(itemRParen) || p.peek(itemAnd) || p.peek(itemOr) {
+			return nil, searchError("NOT needs a term")
+		}
+		n, err := p.parseUnary()
+		if err != nil || n == nil {
+			return nil, err
+		}
+		if n.op == searchNot {
+			return n.args[0], nil
+		}
+		return &searchNode{op: searchNot, args: []*searchNode{n}}, nil
+	}
+	item := p.items[p.pos]
+	p.pos++
+	switch item.kind {
+	case itemLParen:
+		n, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		if !p.peek(itemRParen) {
+			return nil, searchError("unbalanced (")
+		}
+		p.pos++
+		return n, nil
+	case itemPhrase:
+		return p.phrase(item.text, false), nil
+	default:
+		prefix := strings.HasSuffix(item.text, "*")
+		return p.phrase(strings.TrimRight(item.text, "*"), prefix), nil
+	}
+}
+
+// phrase turns the words of text into a phrase node, nil when it has none.
+// A prefix search applies to its last word.
+func (p *searchParser) phrase(text string, prefix bool) *searchNode {
+	toks := tokenize(text)
+	if len(toks) == 0 {
+		return nil
+	}
+	n := &searchNode{op: searchPhrase}
+	for _, t := range toks {
+		n.terms = append(n.terms, searchTerm{word: t.word})
+	}
+	n.terms[len(n.terms)-1].prefix = prefix
+	p.terms += len(n.terms)
+	return n
+}
+
+func combine(op searchOp, args []*searchNode) *searchNode {
+	switch len(args) {
+	case 0:
+		return nil
+	case 1:
+		return args[0]
+	}
+	return &searchNode{op: op, args: args}
+}
+
+// tsquery renders q for to_tsquery with the simple configuration.
+func (q *SearchQuery) tsquery() string {
+	return q.root.tsquery()
+}
+
+func (n *searchNode) tsquery() string {
+	var parts []string
+	switch n.op {
+	case searchPhrase:
+		for _, t := range n.terms {
+			lexeme := "'" + t.word + "'"
+			if t.prefix {
+				lexeme += ":*"
+			}
+			parts = append(parts, lexeme)
+		}
+		if len(parts) == 1 {
+			return parts[0]
+		}
+		return "(" + strings.Join(parts, " <-> ") + ")"
+	case searchNot:
+		return "!" + n.args[0].tsquery()
+	}
+	for _, a := range n.args {
+		parts = append(parts, a.tsquery())
+	}
+	sep := " & "
+	if n.op == searchOr {
+		sep = " | "
+	}
+	return "(" + strings.Join(parts, sep) + ")"
+}
+
+// phrases are the phrases a matching post contains, those not under a NOT.
+func (n *searchNode) phrases() []*searchNode {
+	switch n.op {
+	case searchPhrase:
+		return []*searchNode{n}
+	case searchNot:
+		return nil
+	}
+	var all []*searchNode
+	for _, a := range n.args {
+		all = append(all, a.phrases()...)
+	}
+	return all
+}
+
+// token is a word of a text and where it is.
+type token struct {
+	word       string
+	start, end int
+}
+
+func tokenize(text string) []token {
+	var toks []token
+	start := -1
+	for i, r := range text {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if start < 0 {
+				start = i
+			}
+			continue
+		}
+		if start >= 0 {
+			toks = append(toks, token{word: strings.ToLower(text[start:i]), start: start, end: i})
+			start = -1
+		}
+	}
+	if start >= 0 {
+		toks = append(toks, token{word: strings.ToLower(text[start:]), start: start, end: len(text)})
+	}
+	return toks
+}
+
+// textIndex maps the words of posts to where they are.
+type textIndex struct {
+	// postings are the positions of each word, by post id.
+	postings map[string]map[int][]int
+	// words are the distinct words of each post and lengths its number of
+	// words.
+	words   map[int][]string
+	lengths map[int]int
+}
+
+func newTextIndex() *textIndex {
+	return &textIndex{
+		postings: make(map[string]map[int][]int),
+		words:    make(map[int][]string),
+		lengths:  make(map[int]int),
+	}
+}
+
+// set indexes text as the text of post id.
+func (ix *textIndex) set(id int, text string) {
+	ix.remove(id)
+	toks := tokenize(text)
+	for i, t := range toks {
+		docs, ok := ix.postings[t.word]
+		if !ok {
+			docs = make(map[int][]int)
+			ix.postings[t.word] = docs
+		}
+		if len(docs[id]) == 0 {
+			ix.words[id] = append(ix.words[id], t.word)
+		}
+		docs[id] = append(docs[id], i)
+	}
+	ix.lengths[id] = len(toks)
+}
+
+func (ix *textIndex) remove(id int) {
+	for _, w := range ix.words[id] {
+		delete(ix.postings[w], id)
+		if len(ix.postings[w]) == 0 {
+			delete(ix.postings, w)
+		}
+	}
+	delete(ix.words, id)
+	delete(ix.lengths, id)
+}
+
+// docs are the posts with a word matching t.
+func (ix *textIndex) docs(t searchTerm) map[int]bool {
+	ids := map[int]bool{}
+	if !t.prefix {
+		for id := range ix.postings[t.word] {
+			ids[id] = true
+		}
+		return ids
+	}
+	for w, docs := range ix.postings {
+		if strings.HasPrefix(w, t.word) {
+			for id := range docs {
+				ids[id] = true
+			}
+		}
+	}
+	return ids
+}
+
+// positions are the sorted positions of the words of post id matching t.
+func (ix *textIndex) positions(t searchTerm, id int) []int {
+	if !t.prefix {
+		return ix.postings[t.word][id]
+	}
+	var pos []int
+	for _, w := range ix.words[id] {
+		if strings.HasPrefix(w, t.word) {
+			pos = append(pos, ix.postings[w][id]...)
+		}
+	}
+	sort.Ints(pos)
+	return pos
+}
+
+// phraseStarts are the positions in post id where the phrase n starts.
+func (ix *textIndex) phraseStarts(n *searchNode, id int) []int {
+	starts := ix.positions(n.terms[0], id)
+	for k := 1; k < len(n.terms) && len(starts) > 0; k++ {
+		next := ix.positions(n.terms[k], id)
+		var kept []int
+		for _, s := range starts {
+			i := sort.SearchInts(next, s+k)
+			if i < len(next) && next[i] == s+k {
+				kept = append(kept, s)
+			}
+		}
+		starts = kept
+	}
+	return starts
+}
+
+// eval returns the posts matching n.
+func (ix *textIndex) eval(n *searchNode) map[int]bool {
+	switch n.op {
+	case searchPhrase:
+		ids := ix.docs(n.terms[0])
+		for _, t := range n.terms[1:] {
+			other := ix.docs(t)
+			for id := range ids {
+				if !other[id] {
+					delete(ids, id)
+				}
+			}
+		}
+		if len(n.terms) > 1 {
+			for id := range ids {
+				if len(ix.phraseStarts(n, id)) == 0 {
+					delete(ids, id)
+				}
+			}
+		}
+		return ids
+	case searchNot:
+		excluded := ix.eval(n.args[0])
+		ids := map[int]bool{}
+		for id := range ix.lengths {
+			if !excluded[id] {
+				ids[id] = true
+			}
+		}
+		return ids
+	case searchOr:
+		ids := map[int]bool{}
+		for _, a := range n.args {
+			for id := range ix.eval(a) {
+				ids[id] = true
+			}
+		}
+		return ids
+	}
+	ids := ix.eval(n.args[0])
+	for _, a := range n.args[1:] {
+		if len(ids) == 0 {
+			break
+		}
+		other := ix.eval(a)
+		for id := range ids {
+			if !other[id] {
+				delete(ids, id)
+			}
+		}
+	}
+	return ids
+}
+
+// search returns the rank of every post matching q, by id.
+func (ix *textIndex) search(q *SearchQuery) map[int]float64 {
+	phrases := q.root.phrases()
+	ranks := map[int]float64{}
+	for id := range ix.eval(q.root) {
+		ranks[id] = ix.rank(phrases, id)
+	}
+	return ranks
+}
+
+// rank counts the occurrences of phrases in post id, scaled down for long
+// posts like ts_rank with normalization 1 does.
+func (ix *textIndex) rank(phrases []*searchNode, id int) float64 {
+	hits := 0
+	for _, n := range phrases {
+		hits += len(ix.phraseStarts(n, id))
+	}
+	length := ix.lengths[id]
+	if length < 1 {
+		length = 1
+	}
+	return float64(hits) / (1 + math.Log(float64(length)))
+}
+
+// Match reports whether text matches q.
+func (q *SearchQuery) Match(text string) bool {
+	ix := newTextIndex()
+	ix.set(0, text)
+	return ix.eval(q.root)[0]
+}
+
+// Snippet returns the words of text around the best matches of q, at most
+// words of them, as HTML with the matches in <mark> elements. Ellipses
+// stand for the text left out.
+func (q *SearchQuery) Snippet(text string, words int) string {
+	toks := tokenize(text)
+	ix := newTextIndex()
+	ix.set(0, text)
+	marked := make([]bool, len(toks))
+	for _, n := range q.root.phrases() {
+		for _, s := range ix.phraseStarts(n, 0) {
+			for k := range n.terms {
+				marked[s+k] = true
+			}
+		}
+	}
+
+	// the first window of words with the most matches, centered on them
+	from, to := 0, len(toks)
+	if len(toks) > words {
+		best, count := 0, 0
+		for i := 0; i < words; i++ {
+			if marked[i] {
+				count++
+			}
+		}
+		bestCount := count
+		for i := 1; i+words <= len(toks); i++ {
+			if marked[i-1] {
+				count--
+			}
+			if marked[i+words-1] {
+				count++
+			}
+			if count > bestCount {
+				best, bestCount = i, count
+			}
+		}
+		first, last := best, best+words-1
+		for first < last && !marked[first] {
+			first++
+		}
+		for last > first && !marked[last] {
+			last--
+		}
+		if bestCount > 0 {
+			best = (first+last)/2 - words/2
+			if best < 0 {
+				best = 0
+			}
+			if best > len(toks)-words {
+				best = len(toks) - words
+			}
+		}
+		from, to = best, best+words
+	}
+
+	var b strings.Builder
+	start, end := 0, len(text)
+	if from > 0 {
+		b.WriteString("… ")
+		start = toks[from].start
+	}
+	if to < len(toks) {
+		end = toks[to-1].end
+	}
+	pos := start
+	for i := from; i < to; i++ {
+		if !marked[i] {
+			continue
+		}
+		b.WriteString(html.EscapeString(text[pos:toks[i].start]))
+		b.WriteString("<mark>")
+		b.WriteString(html.EscapeString(text[toks[i].start:toks[i].end]))
+		b.WriteString("</mark>")
+		pos = toks[i].end
+	}
+	b.WriteString(html.EscapeString(text[pos:end]))
+	if to < len(toks) {
+		b.WriteString(" …")
+	}
+	return b.String()
+}
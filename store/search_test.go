@@ -0,0 +1,207 @@
+package taskstore
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTokenize(t *testing.T) {
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"", nil},
+		{"  ...  ", nil},
+		{"Deploy on Friday", []string{"deploy", "on", "friday"}},
+		// what the Postgres parser would keep whole
+		{"e-mail", []string{"e", "mail"}},
+		{"bob@example.com", []string{"bob", "example", "com"}},
+		{"see https://example.com/a_b?x=1", []string{"see", "https", "example", "com", "a", "b", "x", "1"}},
+		{"pi is 3.14", []string{"pi", "is", "3", "14"}},
+		{"v2 in 2026", []string{"v2", "in", "2026"}},
+		{"don't", []string{"don", "t"}},
+		{"Ünïcode ЖУРНАЛ 日本", []string{"ünïcode", "журнал", "日本"}},
+	}
+	for _, tt := range tests {
+		var got []string
+		for _, tok := range tokenize(tt.text) {
+			if tt.text[tok.start:tok.end] != tok.word && strings.ToLower(tt.text[tok.start:tok.end]) != tok.word {
+				t.Errorf("tokenize(%q) has %q at [%d:%d], which holds %q", tt.text, tok.word, tok.start, tok.end, tt.text[tok.start:tok.end])
+			}
+			got = append(got, tok.word)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("tokenize(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestParseSearch(t *testing.T) {
+	tests := []struct {
+		q    string
+		want string
+	}{
+		{"deploy", "'deploy'"},
+		{"Deploy Friday", "('deploy' & 'friday')"},
+		{`"deploy on friday"`, "('deploy' <-> 'on' <-> 'friday')"},
+		{"depl*", "'depl':*"},
+		{"e-mail*", "('e' <-> 'mail':*)"},
+		{"deploy OR release friday", "(('deploy' | 'release') & 'friday')"},
+		{"deploy AND release", "('deploy' & 'release')"},
+		{"-friday deploy", "(!'friday' & 'deploy')"},
+		{"NOT NOT friday", "'friday'"},
+		{"(deploy OR release) AND NOT friday", "(('deploy' | 'release') & !'friday')"},
+		{"bob@example.com", "('bob' <-> 'example' <-> 'com')"},
+		{"3.14", "('3' <-> '14')"},
+		// words without letters or digits are dropped
+		{"deploy ... release", "('deploy' & 'release')"},
+	}
+	for _, tt := range tests {
+		q, err := ParseSearch(tt.q)
+		if err != nil {
+			t.Errorf("ParseSearch(%q): %v", tt.q, err)
+			continue
+		}
+		if got := q.tsquery(); got != tt.want {
+			t.Errorf("ParseSearch(%q) = %s, want %s", tt.q, got, tt.want)
+		}
+	}
+
+	for _, q := range []string{
+		"",
+		"...",
+		`"deploy`,
+		"(deploy",
+		"deploy)",
+		"OR deploy",
+		"deploy OR",
+		"AND deploy",
+		"deploy AND",
+		"deploy AND OR release",
+		"NOT",
+		"deploy NOT",
+		"(NOT)",
+		strings.Repeat("word ", maxSearchTerms+1),
+	} {
+		if _, err := ParseSearch(q); !errors.Is(err, ErrValidation) {
+			t.Errorf("ParseSearch(%q) = %v, want a validation error", q, err)
+		}
+	}
+}
+
+func TestSearch(t *testing.T) {
+	posts := []struct {
+		name string
+		text string
+	}{
+		{"deploys", "Deploy deploy deploy today"},
+		{"deploy", "Deploy today or maybe later"},
+		{"long", "Deploy after the long meeting about the quarterly plan, the budget, the hiring and the offsite"},
+		{"mail", "Send the e-mail to bob@example.com"},
+		{"link", "Docs at https://example.com/search?q=1"},
+		{"pi", "Pi is 3.14 give or take"},
+		{"release", "Release on Friday"},
+	}
+	tests := []struct {
+		q    string
+		want []string
+	}{
+		{"deploy", []string{"deploys", "deploy", "long"}},
+		{"DEPLOY today", []string{"deploys", "deploy"}},
+		{"depl*", []string{"deploys", "deploy", "long"}},
+		{`"today deploy"`, nil},
+		{`"deploy today"`, []string{"deploys", "deploy"}},
+		{"deploy -today", []string{"long"}},
+		{"deploy OR release", []string{"deploys", "deploy", "long", "release"}},
+		{"(deploy OR release) AND NOT friday", []string{"deploys", "deploy", "long"}},
+		{"e-mail", []string{"mail"}},
+		{"mail", []string{"mail"}},
+		{"bob@example.com", []string{"mail"}},
+		{"example", []string{"mail", "link"}},
+		{"example.com", []string{"mail", "link"}},
+		{"3.14", []string{"pi"}},
+		{"14", []string{"pi"}},
+		{"search", []string{"link"}},
+	}
+
+	forEachStore(t, func(t *testing.T, s PostStoreManager) {
+		ctx := context.Background()
+		names := map[int]string{}
+		for _, p := range posts {
+			created, err := s.CreatePost(ctx, p.text, "alice", nil, time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC), false, "alice")
+			if err != nil {
+				t.Fatal(err)
+			}
+			names[created.ID] = p.name
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.q, func(t *testing.T) {
+				q, err := ParseSearch(tt.q)
+				if err != nil {
+					t.Fatal(err)
+				}
+				page, err := s.ListPosts(ctx, Filter{Search: q}, ListOptions{Sort: "-rank", Limit: 100})
+				if err != nil {
+					t.Fatal(err)
+				}
+				var got []string
+				for _, p := range page.Posts {
+					got = append(got, names[p.ID])
+				}
+				if tt.q == "deploy" {
+					// the more often and the shorter, the better the
+					// match; the ranks of other searches differ more
+					// between backends
+					if !reflect.DeepEqual(got, tt.want) {
+						t.Errorf("search %q ranks %v, want %v", tt.q, got, tt.want)
+					}
+				}
+				if got, want := sorted(got), sorted(append([]string(nil), tt.want...)); !reflect.DeepEqual(got, want) {
+					t.Errorf("search %q = %v, want %v", tt.q, got, want)
+				}
+				for _, p := range posts {
+					if want := contains(tt.want, p.name); q.Match(p.text) != want {
+						t.Errorf("Match(%q) = %v, want %v", p.text, !want, want)
+					}
+				}
+			})
+		}
+	})
+}
+
+func TestSnippet(t *testing.T) {
+	tests := []struct {
+		q, text string
+		words   int
+		want    string
+	}{
+		{"deploy", "Deploy on Friday", 30, "<mark>Deploy</mark> on Friday"},
+		{`"on friday"`, "Deploy on Friday & <b>", 30, "Deploy <mark>on</mark> <mark>Friday</mark> &amp; &lt;b&gt;"},
+		{"e-mail", "Send the e-mail now", 30, "Send the <mark>e</mark>-<mark>mail</mark> now"},
+		{"friday", "one two three four five six friday seven eight nine ten", 3, "… six <mark>friday</mark> seven …"},
+		{"nothing", "one two three four", 2, "one two …"},
+	}
+	for _, tt := range tests {
+		q, err := ParseSearch(tt.q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := q.Snippet(tt.text, tt.words); got != tt.want {
+			t.Errorf("Snippet(%q, %q, %d) = %q, want %q", tt.q, tt.text, tt.words, got, tt.want)
+		}
+	}
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -1,366 +1,80 @@
 package taskstore
 
 import (
-	"fmt"
-	"github.com/jackc/pgx"
-	"sync"
+	"context"
 	"time"
 )
 
 type Posts struct {
 	ID     int       `json:"id"`
-	Author string    `json:"author"'`
+	Author string    `json:"author"`
 	Text   string    `json:"text"`
 	Tags   []string  `json:"tags"`
 	Due    time.Time `json:"due"`
-}
-
-type PostStore struct {
-	mux    sync.Mutex
-	Post   map[int]Posts
-	nextID int
-}
-
+	// Private posts are only shown to their author and moderators.
+	Private bool `json:"private"`
+	// Version starts at 1 and is incremented by every update.
+	Version int `json:"version"`
+	// Created and Updated are set by the store.
+	Created time.Time `json:"created"`
+	Updated time.Time `json:"updated"`
+	// Deleted and DeletedBy are set while the post is in the trash.
+	Deleted   *time.Time `json:"deleted,omitempty"`
+	DeletedBy string     `json:"deleted_by,omitempty"`
+	// Rank is how well the post matches Filter.Search, set by ListPosts
+	// when searching. Ranks order the results of one backend, their scale
+	// differs between backends.
+	Rank float64 `json:"-"`
+}
+
+// PostStoreManager is the storage backend used by the HTTP handlers. Every
+// implementation must behave the same way so the API does not depend on the
+// backend selected at startup. Errors wrap ErrNotFound, ErrConflict,
+// ErrValidation, ErrPrecondition or ErrUnavailable.
+//
+// Every method but Changes and Close takes the context of the call it is
+// made for. It is handed to PgConfig.Observer with the statements run, and
+// does not cancel them.
+//
+// The store does not authorize anything: callers check with an Actor
+// first, and narrow their filters with Actor.Visible.
+//
+// UpdatePost, DeletePost and RestorePost take the version the caller
+// expects the post to be at and fail with ErrPrecondition when it has moved
+// on; version 0 matches any version.
+//
+// Deleting a post moves it to the trash, where GetPost, UpdatePost and
+// ListPosts no longer see it, unless ListPosts is asked for the trash.
+// RestorePost brings it back and PurgePost and PurgeTrash remove it for
+// good. Every move to and from the trash increments the version.
+//
+// The writes take who makes them, by, for the revision they record.
 type PostStoreManager interface {
-	AddPostToDb(author string, text string, tags []string, due time.Time) int
-	DeletePostFromDb(id int) error
-	DeleteAllPostsFromDb() error
-	GetAllPostsDb() []Posts
-	GetPostByIdDb(id int) (Posts, error)
-	GetPostsByTagDb(tag string) []Posts
-	CreatePost(tx string, author string, tags []string, due time.Time) int
-	GetPost(id int) (Posts, error)
-	DeletePost(id int) error
-	DeleteAllPost() error
-	GetAllPost() []Posts
-	GetPostByTags(tag string) []Posts
-	GetPostByDue(year int, mn time.Month, day int) []Posts
-}
-
-func New() *PostStore {
-	ts := &PostStore{}
-	ts.Post = make(map[int]Posts)
-	ts.nextID = 0
-	return ts
-}
-
-func (ps *PostStore) AddPostToDb(author, text string, tags []string, due time.Time) int {
-
-	//create config string
-	connStr := "user=anton password=123 dbname=postgres sslmode=disable"
-	//create connect config
-	conf, err := pgx.ParseConnectionString(connStr)
-	if err != nil {
-		fmt.Errorf("connection string is bad %s", err)
-
-	}
-	//connect to db
-	db, err := pgx.Connect(conf)
-	if err != nil {
-		fmt.Errorf("cant connect to db %s", err)
-	}
-	// close connection
-	defer db.Close()
-
-	var id int
-	err = db.QueryRow("INSERT INTO posts  VALUES ( nextval('postsseq'), $1, $2, $3, $4) returning id", author, text, tags, due).Scan(&id)
-
-	if err != nil {
-		fmt.Errorf("cant connect to db %s", err)
-	}
-	return id
-}
-
-func (ps *PostStore) DeletePostFromDb(id int) error {
-	//create config string
-	connStr := "user=anton password=123 dbname=postgres sslmode=disable"
-	//create connect config
-	conf, err := pgx.ParseConnectionString(connStr)
-
-	if err != nil {
-		fmt.Errorf("connection string is bad %s", err)
-	}
-	//connect to db
-	db, err := pgx.Connect(conf)
-	if err != nil {
-		fmt.Errorf("cant connect to db %s", err)
-	}
-
-	defer db.Close()
-
-	_, err = db.Exec("DELETE FROM posts WHERE id = $1", id)
-
-	if err != nil {
-		fmt.Errorf("cant connect to db %s with id %d", err, id)
-	}
-	return nil
-}
-
-func (ps *PostStore) DeleteAllPostsFromDb() error {
-	//create config string
-	connStr := "user=anton password=123 dbname=postgres sslmode=disable"
-	//create connect config
-	conf, err := pgx.ParseConnectionString(connStr)
-	if err != nil {
-		fmt.Errorf("connection string is bad %s", err)
-	}
-	//connect to db
-	db, err := pgx.Connect(conf)
-	if err != nil {
-		fmt.Errorf("cant connect to db %s", err)
-	}
-
-	defer db.Close()
-	//without answer
-	_, err = db.Exec("DELETE FROM posts ")
-
-	if err != nil {
-		fmt.Errorf("cant connect to db %s ", err)
-	}
-	return nil
-}
-
-func (ps *PostStore) GetPostsByAuthorDb(author string) []Posts {
-	// create slice
-	posts := []Posts{}
-	//create config string
-	connStr := "user=anton password=123 dbname=postgres sslmode=disable"
-	//create config
-	conf, err := pgx.ParseConnectionString(connStr)
-	if err != nil {
-		fmt.Errorf("connection string is bad %s", err)
-	}
-	//create connection
-	db, err := pgx.Connect(conf)
-
-	if err != nil {
-		fmt.Errorf("cant connect to db %s", err)
-	}
-
-	all, err := db.Query("SELECT * FROM posts WHERE author = $1", author)
-
-	defer func() {
-		all.Close()
-		db.Close()
-	}()
-
-	for all.Next() {
-		p := Posts{}
-		all.Scan(&p.ID, &p.Author, &p.Text, &p.Tags, &p.Due)
-		if err != nil {
-			fmt.Errorf("simthing go wrong")
-		}
-
-		posts = append(posts, p)
-	}
-	fmt.Println(posts)
-	return posts
-}
-
-func (ps *PostStore) GetAllPostsDb() []Posts {
-	//create slice
-	posts := []Posts{}
-	//create config string
-	connStr := "user=anton password=123 dbname=postgres sslmode=disable "
-	//create connect config
-	conf, err := pgx.ParseConnectionString(connStr)
-	if err != nil {
-		fmt.Errorf("connection string is bad %s", err)
-	}
-	//connect to db
-	db, err := pgx.Connect(conf)
-	if err != nil {
-		fmt.Errorf("cant connect to db %s", err)
-	}
-	//get rows
-	all, err := db.Query("SELECT * FROM posts")
-	if err != nil {
-		fmt.Errorf("cant connect to db %s", err)
-	}
-
-	defer all.Close()
-	//walk to posts
-	for all.Next() {
-		//create new object type Posts
-		p := Posts{}
-		// scanning values
-		err = all.Scan(&p.ID, &p.Author, &p.Text, &p.Tags, &p.Due)
-		if err != nil {
-			fmt.Errorf("simthing go wrong")
-		}
-		//fmt.Println(p)
-
-		posts = append(posts, p)
-	}
-
-	if all.Err() != nil {
-		fmt.Errorf("cant NULL")
-	}
-
-	return posts
-}
-
-func (ps *PostStore) GetPostByIdDb(id int) (Posts, error) {
-	//create config string
-	connStr := "user=anton password=123 dbname=postgres sslmode=disable"
-	//create connect config
-	conf, err := pgx.ParseConnectionString(connStr)
-	if err != nil {
-		fmt.Errorf("connection string is bad %s", err)
-	}
-	//connect to db
-	db, err := pgx.Connect(conf)
-	if err != nil {
-		fmt.Errorf("cant connect to db %s", err)
-	}
-
-	defer db.Close()
-	//one row
-	post := db.QueryRow("SELECT * FROM posts WHERE id = $1", id)
-	if err != nil {
-		fmt.Errorf("cant connect to db %s", err)
-	}
-	//defer post.Close()
-
-	p := Posts{}
-
-	err = post.Scan(&p.ID, &p.Author, &p.Text, &p.Tags, &p.Due)
-	if err != nil {
-		fmt.Errorf("simthing whet wrong")
-	}
-	fmt.Println(p)
-	return p, nil
-}
-
-func (ps *PostStore) GetPostsByTagDb(tag string) []Posts {
-	//create config string
-	connStr := "user=anton password=123 dbname=postgres sslmode=disable"
-	//create connect config
-	conf, err := pgx.ParseConnectionString(connStr)
-	if err != nil {
-		fmt.Errorf("connection string is bad %s", err)
-	}
-	//connect to db
-	db, err := pgx.Connect(conf)
-	if err != nil {
-		fmt.Errorf("cant connect to db %s", err)
-	}
-	//close connection
-	defer db.Close()
-
-	all, err := db.Query("SELECT * FROM posts WHERE tags[0] = $1 OR tags[1] = $1 OR tags[2] = $1 OR tags[3] = $1 OR tags[4] = $1 OR tags[5] = $1   ", tag)
-	if err != nil {
-		fmt.Errorf("cant connect to db %s", err)
-	}
-	//close rows
-	defer all.Close()
-
-	products := []Posts{}
-
-	for all.Next() {
-		p := Posts{}
-		err := all.Scan(&p.ID, &p.Author, &p.Text, &p.Tags, &p.Due)
-		if err != nil {
-			fmt.Println(err)
-			continue
-		}
-		products = append(products, p)
-	}
-	return products
-}
-
-func (p *PostStore) CreatePost(tx string, author string, tags []string, due time.Time) int {
-	p.mux.Lock()
-	defer p.mux.Unlock()
-
-	post := Posts{
-		ID:     p.nextID,
-		Author: author,
-		Text:   tx,
-		Due:    due,
-	}
-
-	post.Tags = make([]string, len(tags))
-	copy(post.Tags, tags)
-
-	p.Post[p.nextID] = post
-	p.nextID++
-	return post.ID
-}
-
-func (p *PostStore) GetPost(id int) (Posts, error) {
-	p.mux.Lock()
-	defer p.mux.Unlock()
-
-	t, ok := p.Post[id]
-	if ok {
-		return t, nil
-	} else {
-		return Posts{}, fmt.Errorf("Please change input id = %d, task not found", id)
-	}
-}
-
-func (p *PostStore) DeletePost(id int) error {
-	p.mux.Lock()
-	defer p.mux.Unlock()
-
-	if _, ok := p.Post[id]; !ok {
-		return fmt.Errorf("Please change input id = %d, task not found", id)
-
-	} else {
-
-		delete(p.Post, id)
-		return nil
-	}
-}
-
-func (p *PostStore) DeleteAllPost() error {
-	p.mux.Lock()
-	defer p.mux.Unlock()
-
-	p.Post = make(map[int]Posts)
-	return nil
-}
-
-func (p *PostStore) GetAllPost() []Posts {
-	p.mux.Lock()
-	defer p.mux.Unlock()
-
-	all := make([]Posts, 0, len(p.Post))
-	for _, task := range p.Post {
-		all = append(all, task)
-	}
-	return all
-}
-
-func (p *PostStore) GetPostByTags(tag string) []Posts {
-	p.mux.Lock()
-	defer p.mux.Unlock()
-
-	var posts []Posts
-
-	for _, task := range p.Post {
-		for _, tasktag := range task.Tags {
-			if tasktag == tag {
-				posts = append(posts, task)
-				break
-			}
-		}
-	}
-	return posts
-}
-
-func (p *PostStore) GetPostByDue(year int, mn time.Month, day int) []Posts {
-	p.mux.Lock()
-	defer p.mux.Unlock()
-
-	var posts []Posts
-
-	for _, post := range p.Post {
-		y, m, d := post.Due.Date()
-		if y == year && m == mn && d == day {
-			posts = append(posts, post)
-		}
-	}
-	return posts
+	CreatePost(ctx context.Context, text string, author string, tags []string, due time.Time, private bool, by string) (Posts, error)
+	GetPost(ctx context.Context, id int) (Posts, error)
+	// UpdatePost replaces every client supplied field of post id.
+	UpdatePost(ctx context.Context, id int, version int, text string, author string, tags []string, due time.Time, private bool, by string) (Posts, error)
+	// DeletePost and DeleteAllPosts move posts to the trash.
+	DeletePost(ctx context.Context, id int, version int, by string) error
+	DeleteAllPosts(ctx context.Context, by string) error
+	// GetTrashedPost returns post id if it is in the trash.
+	GetTrashedPost(ctx context.Context, id int) (Posts, error)
+	// RestorePost takes post id out of the trash.
+	RestorePost(ctx context.Context, id int, version int, by string) (Posts, error)
+	// PurgePost removes post id, which must be in the trash, for good.
+	PurgePost(ctx context.Context, id int) error
+	// PurgeTrash removes the posts moved to the trash before before for
+	// good, and returns how many there were.
+	PurgeTrash(ctx context.Context, before time.Time) (int, error)
+	// ListPosts returns one page of the posts matching f.
+	ListPosts(ctx context.Context, f Filter, opts ListOptions) (Page, error)
+	// Changes is the bus every committed write is published on.
+	Changes() *ChangeBus
+	RevisionStore
+	WebhookStore
+	KeyStore
+	// Ping fails with ErrUnavailable when the backend cannot serve calls.
+	Ping(ctx context.Context) error
+	// Close releases the resources held by the backend.
+	Close()
 }
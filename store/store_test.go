@@ -0,0 +1,134 @@
+package taskstore
+
+import (
+	"context"
+	"os"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+// testDSN names the Postgres database the shared tests also run against,
+// which they empty. Without it they only run against the memory store.
+const testDSN = "TEST_DATABASE_URL"
+
+// forEachStore runs test against every backend, each one empty.
+func forEachStore(t *testing.T, test func(t *testing.T, s PostStoreManager)) {
+	t.Run("memory", func(t *testing.T) {
+		test(t, New())
+	})
+	t.Run("postgres", func(t *testing.T) {
+		dsn := os.Getenv(testDSN)
+		if dsn == "" {
+			t.Skip(testDSN + " is not set")
+		}
+		s, err := NewPg(PgConfig{DSN: dsn, MaxConnections: 4})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer s.Close()
+		if _, err := s.MigrateUp(); err != nil {
+			t.Fatal(err)
+		}
+		empty := func() {
+			ctx := context.Background()
+			if err := s.DeleteAllPosts(ctx, "test"); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := s.PurgeTrash(ctx, time.Now().Add(time.Hour)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		empty()
+		defer empty()
+		test(t, s)
+	})
+}
+
+func TestTagFilters(t *testing.T) {
+	// more tags than the fixed slots posts once had room for
+	many := []string{"a", "b", "c", "d", "e", "f", "g", "h"}
+	posts := []struct {
+		name string
+		tags []string
+	}{
+		{"many", many},
+		{"one", []string{"a"}},
+		{"nil", nil},
+		{"empty", []string{}},
+		{"last", []string{"h", "i"}},
+	}
+	tests := []struct {
+		name string
+		f    Filter
+		want []string
+	}{
+		{"no tags", Filter{}, []string{"many", "one", "nil", "empty", "last"}},
+		{"empty tags", Filter{Tags: []string{}}, []string{"many", "one", "nil", "empty", "last"}},
+		{"empty tags all", Filter{Tags: []string{}, AllTags: true}, []string{"many", "one", "nil", "empty", "last"}},
+		{"past the slots", Filter{Tags: []string{"h"}}, []string{"many", "last"}},
+		{"any", Filter{Tags: []string{"a", "i"}}, []string{"many", "one", "last"}},
+		{"all", Filter{Tags: []string{"a", "i"}, AllTags: true}, nil},
+		{"all of many", Filter{Tags: many, AllTags: true}, []string{"many"}},
+		{"all of one", Filter{Tags: []string{"h", "i"}, AllTags: true}, []string{"last"}},
+		{"unknown", Filter{Tags: []string{"z"}}, nil},
+	}
+
+	forEachStore(t, func(t *testing.T, s PostStoreManager) {
+		ctx := context.Background()
+		names := map[int]string{}
+		stored := map[string]Posts{}
+		for _, p := range posts {
+			created, err := s.CreatePost(ctx, p.name, "alice", p.tags, time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC), false, "alice")
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := s.GetPost(ctx, created.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Tags == nil || (len(p.tags) > 0 && !reflect.DeepEqual(got.Tags, p.tags)) || (len(p.tags) == 0 && len(got.Tags) != 0) {
+				t.Errorf("post %s has tags %#v, want %#v as a non-nil list", p.name, got.Tags, p.tags)
+			}
+			names[created.ID] = p.name
+			stored[p.name] = got
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				page, err := s.ListPosts(ctx, tt.f, ListOptions{Limit: 100})
+				if err != nil {
+					t.Fatal(err)
+				}
+				var got []string
+				for _, p := range page.Posts {
+					got = append(got, names[p.ID])
+				}
+				sorted(got)
+				want := sorted(append([]string(nil), tt.want...))
+				if !reflect.DeepEqual(got, want) {
+					t.Errorf("ListPosts = %v, want %v", got, want)
+				}
+				// the changes are matched by Match, which must agree
+				var matched []string
+				for _, p := range posts {
+					if tt.f.Match(stored[p.name]) {
+						matched = append(matched, p.name)
+					}
+				}
+				if matched = sorted(matched); !reflect.DeepEqual(matched, want) {
+					t.Errorf("Match selects %v, want %v", matched, want)
+				}
+			})
+		}
+	})
+}
+
+func sorted(s []string) []string {
+	sort.Strings(s)
+	if len(s) == 0 {
+		return nil
+	}
+	return s
+}
@@ -0,0 +1,147 @@
+package taskstore
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Delivery statuses.
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryDead      = "dead"
+)
+
+// Webhook subscribes a URL to the changes of the posts matching its filter.
+type Webhook struct {
+	ID  int    `json:"id"`
+	URL string `json:"url"`
+	// Events are the change types delivered: created, updated, deleted.
+	Events []string `json:"events"`
+	// Author and Tags filter posts like Filter does, empty matches all.
+	Author string   `json:"author,omitempty"`
+	Tags   []string `json:"tags,omitempty"`
+	// Secret keys the HMAC signature of every delivery.
+	Secret  string    `json:"-"`
+	Created time.Time `json:"created"`
+}
+
+// Match reports whether a change of type typ to p is delivered to h.
+func (h Webhook) Match(typ string, p Posts) bool {
+	return hasTag(h.Events, typ) && Filter{Author: h.Author, Tags: h.Tags}.Match(p)
+}
+
+// Delivery is one change queued for one webhook, and the outcome of its
+// latest attempt.
+type Delivery struct {
+	ID          int64     `json:"id"`
+	Webhook     int       `json:"webhook"`
+	Event       string    `json:"event"`
+	Post        Posts     `json:"post"`
+	Status      string    `json:"status"`
+	Attempts    int       `json:"attempts"`
+	NextAttempt time.Time `json:"next_attempt"`
+	// LastStatus is the HTTP status of the latest attempt, 0 when no
+	// response came back.
+	LastStatus int    `json:"last_status,omitempty"`
+	LastError  string `json:"last_error,omitempty"`
+	// Traceparent is the W3C traceparent of the write that queued the
+	// delivery, empty when it was not traced.
+	Traceparent string    `json:"traceparent,omitempty"`
+	Created     time.Time `json:"created"`
+	Updated     time.Time `json:"updated"`
+}
+
+type traceparentKey struct{}
+
+// WithTraceparent returns a copy of ctx whose writes queue their deliveries
+// with traceparent, so that sending them can join the trace of the write.
+func WithTraceparent(ctx context.Context, traceparent string) context.Context {
+	return context.WithValue(ctx, traceparentKey{}, traceparent)
+}
+
+func traceparentFrom(ctx context.Context) string {
+	tp, _ := ctx.Value(traceparentKey{}).(string)
+	return tp
+}
+
+// Attempt is the outcome of sending a claimed delivery.
+type Attempt struct {
+	Delivered bool
+	Status    int
+	Error     string
+	// RetryAt schedules the next attempt of an undelivered delivery, zero
+	// gives up on it and moves it to the dead letters.
+	RetryAt time.Time
+}
+
+// WebhookStore keeps webhooks and their outbox of deliveries. A delivery is
+// queued for every matching webhook atomically with the write of the post,
+// so a crash before it is sent loses nothing.
+type WebhookStore interface {
+	CreateWebhook(ctx context.Context, h Webhook) (Webhook, error)
+	GetWebhook(ctx context.Context, id int) (Webhook, error)
+	ListWebhooks(ctx context.Context) ([]Webhook, error)
+	// DeleteWebhook deletes the webhook and its deliveries.
+	DeleteWebhook(ctx context.Context, id int) error
+	// ClaimDeliveries returns up to limit pending deliveries that are due,
+	// oldest first, and hides them from other claims for lease. One that
+	// is not finished within lease is claimed again.
+	ClaimDeliveries(ctx context.Context, limit int, lease time.Duration) ([]Delivery, error)
+	// FinishDelivery records an attempt of a claimed delivery.
+	FinishDelivery(ctx context.Context, id int64, a Attempt) error
+	// ListDeliveries returns deliveries newest first: those of webhook (0
+	// for every webhook) in status (empty for any) with an id below before
+	// (0 for no bound).
+	ListDeliveries(ctx context.Context, webhook int, status string, before int64, limit int) ([]Delivery, error)
+	// RetryDelivery queues a dead delivery again, it fails with ErrConflict
+	// for a delivery in any other status.
+	RetryDelivery(ctx context.Context, id int64) (Delivery, error)
+}
+
+var changeTypes = []string{Created, Updated, Deleted}
+
+// validateWebhook checks the fields a client supplies when creating a
+// webhook.
+func validateWebhook(h Webhook) error {
+	u, err := url.Parse(h.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%w: url must be an absolute http or https URL", ErrValidation)
+	}
+	if len(h.Events) == 0 {
+		return fmt.Errorf("%w: events are required", ErrValidation)
+	}
+	for _, e := range h.Events {
+		if !hasTag(changeTypes, e) {
+			return fmt.Errorf("%w: unknown event %q, expect %s", ErrValidation, e, strings.Join(changeTypes, ", "))
+		}
+	}
+	for i, tag := range h.Tags {
+		if strings.TrimSpace(tag) == "" {
+			return fmt.Errorf("%w: tag %d is empty", ErrValidation, i)
+		}
+	}
+	if h.Secret == "" {
+		return fmt.Errorf("%w: secret is required", ErrValidation)
+	}
+	return nil
+}
+
+func webhookNotFound(id int) error {
+	return fmt.Errorf("webhook %d %w", id, ErrNotFound)
+}
+
+func deliveryNotFound(id int64) error {
+	return fmt.Errorf("delivery %d %w", id, ErrNotFound)
+}
+
+func checkDeliveryStatus(status string) error {
+	switch status {
+	case "", DeliveryPending, DeliveryDelivered, DeliveryDead:
+		return nil
+	}
+	return fmt.Errorf("%w: unknown delivery status %q", ErrValidation, status)
+}
@@ -0,0 +1,536 @@
+package main
+
+import (
+	poststore "SimpleRest/store"
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Spans are recorded for every HTTP request, the store calls made for it
+// and the statements they run, and for every webhook delivery attempt. A
+// request joins the trace of a W3C traceparent header when it has one, and
+// a delivery joins that of the write that queued it and sends its own.
+// Finished spans are exported in batches, as JSON lines to a file or as
+// OTLP/HTTP JSON to a collector.
+
+const (
+	// traceBatch is the most spans exported at once.
+	traceBatch = 256
+	// traceFlush is how long a finished span may wait for its batch.
+	traceFlush = 2 * time.Second
+	// traceQueue is how many finished spans wait for export before more
+	// are dropped.
+	traceQueue = 4096
+	// traceService names the spans of this process to the collector.
+	traceService = "SimpleRest"
+)
+
+type spanKind int
+
+// span kinds, numbered like OTLP's
+const (
+	spanInternal spanKind = 1
+	spanServer   spanKind = 2
+	spanClient   spanKind = 3
+)
+
+var spanKindNames = map[spanKind]string{spanInternal: "internal", spanServer: "server", spanClient: "client"}
+
+type traceID [16]byte
+type spanID [8]byte
+
+// span is one timed operation. A nil span records nothing, so tracing can
+// be off without checks at every call.
+type span struct {
+	tracer  *tracer
+	trace   traceID
+	id      spanID
+	parent  spanID
+	sampled bool
+	kind    spanKind
+	start   time.Time
+
+	mu    sync.Mutex
+	name  string
+	end   time.Time
+	attrs []spanAttr
+	err   string
+}
+
+type spanAttr struct {
+	key   string
+	value interface{}
+}
+
+func (s *span) SetName(name string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.name = name
+}
+
+// SetAttr records a string, integer, float or boolean attribute.
+func (s *span) SetAttr(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.attrs = append(s.attrs, spanAttr{key, value})
+}
+
+// SetError marks the span failed, when err is not nil.
+func (s *span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.err = err.Error()
+}
+
+func (s *span) End() {
+	s.EndAt(time.Now())
+}
+
+// EndAt finishes the span at t and exports it if sampled.
+func (s *span) EndAt(t time.Time) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.end = t
+	s.mu.Unlock()
+	if s.sampled {
+		s.tracer.enqueue(s)
+	}
+}
+
+// traceparent renders the span as a W3C traceparent header.
+func (s *span) traceparent() string {
+	flags := "00"
+	if s.sampled {
+		flags = "01"
+	}
+	return "00-" + hex.EncodeToString(s.trace[:]) + "-" + hex.EncodeToString(s.id[:]) + "-" + flags
+}
+
+// parseTraceparent reads a version 00 traceparent header, or a later
+// version's first four fields.
+func parseTraceparent(h string) (trace traceID, parent spanID, sampled bool, ok bool) {
+	parts := strings.Split(strings.TrimSpace(h), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || (parts[0] == "00" && len(parts) != 4) {
+		return trace, parent, false, false
+	}
+	if len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return trace, parent, false, false
+	}
+	if _, err := hex.Decode(trace[:], []byte(parts[1])); err != nil || trace == (traceID{}) {
+		return trace, parent, false, false
+	}
+	if _, err := hex.Decode(parent[:], []byte(parts[2])); err != nil || parent == (spanID{}) {
+		return trace, parent, false, false
+	}
+	flags, err := strconv.ParseUint(parts[3], 16, 8)
+	if err != nil {
+		return trace, parent, false, false
+	}
+	return trace, parent, flags&1 == 1, true
+}
+
+type spanKey struct{}
+
+func spanFrom(ctx context.Context) *span {
+	s, _ := ctx.Value(spanKey{}).(*span)
+	return s
+}
+
+// tracer records spans and exports them in the background.
+type tracer struct {
+	exporter spanExporter
+	queue    chan *span
+	done     chan struct{}
+
+	mu      sync.Mutex
+	dropped int
+	closed  bool
+}
+
+// tracing is the tracer of the process, nil when tracing is off.
+var tracing *tracer
+
+func newTracer(exporter spanExporter) *tracer {
+	t := &tracer{exporter: exporter, queue: make(chan *span, traceQueue), done: make(chan struct{})}
+	go t.run()
+	return t
+}
+
+// startSpan starts a span as a child of the one in ctx, or of a new trace,
+// and returns a context carrying it.
+func (t *tracer) startSpan(ctx context.Context, name string, kind spanKind) (context.Context, *span) {
+	if t == nil {
+		return ctx, nil
+	}
+	s := &span{tracer: t, name: name, kind: kind, start: time.Now(), sampled: true}
+	if parent := spanFrom(ctx); parent != nil {
+		s.trace, s.parent, s.sampled = parent.trace, parent.id, parent.sampled
+	} else {
+		rand.Read(s.trace[:])
+	}
+	rand.Read(s.id[:])
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+// startChild is startSpan for operations only worth tracing as part of a
+// trace, which starts no new trace when ctx has none.
+func (t *tracer) startChild(ctx context.Context, name string, kind spanKind) (context.Context, *span) {
+	if t == nil || spanFrom(ctx) == nil {
+		return ctx, nil
+	}
+	return t.startSpan(ctx, name, kind)
+}
+
+// startRemote starts a server span continuing the trace of traceparent
+// header h, or a new trace when h is missing or malformed.
+func (t *tracer) startRemote(ctx context.Context, h string, name string) (context.Context, *span) {
+	return t.startFrom(ctx, h, name, spanServer)
+}
+
+// startFrom starts a span as a child of the one traceparent h names, or of
+// a new trace when h is missing or malformed.
+func (t *tracer) startFrom(ctx context.Context, h string, name string, kind spanKind) (context.Context, *span) {
+	if t == nil {
+		return ctx, nil
+	}
+	ctx, s := t.startSpan(ctx, name, kind)
+	if trace, parent, sampled, ok := parseTraceparent(h); ok {
+		s.trace, s.parent, s.sampled = trace, parent, sampled
+	}
+	return ctx, s
+}
+
+func (t *tracer) enqueue(s *span) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return
+	}
+	select {
+	case t.queue <- s:
+	default:
+		t.dropped++
+	}
+}
+
+func (t *tracer) run() {
+	defer close(t.done)
+	tick := time.NewTicker(traceFlush)
+	defer tick.Stop()
+	batch := make([]*span, 0, traceBatch)
+	flush := func() {
+		t.mu.Lock()
+		dropped := t.dropped
+		t.dropped = 0
+		t.mu.Unlock()
+		if dropped > 0 {
+			logs.Warn("trace queue is full, dropped spans", "spans", dropped)
+		}
+		if len(batch) == 0 {
+			return
+		}
+		if err := t.exporter.export(batch); err != nil {
+			logs.Warn("cannot export spans", "spans", len(batch), "error", err)
+		}
+		batch = batch[:0]
+	}
+	for {
+		select {
+		case s, ok := <-t.queue:
+			if !ok {
+				flush()
+				return
+			}
+			batch = append(batch, s)
+			if len(batch) == traceBatch {
+				flush()
+			}
+		case <-tick.C:
+			flush()
+		}
+	}
+}
+
+// close exports the spans still queued. Spans ending later are dropped.
+func (t *tracer) close() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	t.closed = true
+	close(t.queue)
+	t.mu.Unlock()
+	<-t.done
+	t.exporter.close()
+}
+
+// traceRequests records a server span for every request, continuing the
+// trace of its traceparent header. The span is named after the route
+// template recorded by logRoute, and its trace id is logged with the
+// request.
+func traceRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		ctx, s := tracing.startRemote(req.Context(), req.Header.Get("traceparent"), "HTTP "+req.Method)
+		if s == nil {
+			next.ServeHTTP(w, req)
+			return
+		}
+		rl := requestLogFrom(ctx)
+		rl.traceID = hex.EncodeToString(s.trace[:])
+		sw := &statusWriter{ResponseWriter: w}
+		next.ServeHTTP(sw, req.WithContext(ctx))
+
+		if sw.status == 0 {
+			sw.status = http.StatusOK
+		}
+		if rl.route != "" {
+			s.SetName("HTTP " + req.Method + " " + rl.route)
+			s.SetAttr("http.route", rl.route)
+		}
+		s.SetAttr("http.method", req.Method)
+		s.SetAttr("http.target", req.URL.RequestURI())
+		s.SetAttr("http.status_code", sw.status)
+		s.SetAttr("http.request_id", rl.id)
+		if rl.principal != "" {
+			s.SetAttr("enduser.id", rl.principal)
+		}
+		if sw.status >= 500 {
+			s.SetError(fmt.Errorf("%d %s", sw.status, http.StatusText(sw.status)))
+		}
+		s.End()
+	})
+}
+
+// traceQuery records a statement run by the Postgres store as a span of
+// the store call that ran it.
+func traceQuery(ctx context.Context, q poststore.Query) {
+	name := q.Name
+	if name == "" {
+		name = strings.ToUpper(strings.SplitN(strings.TrimSpace(q.SQL), " ", 2)[0])
+	}
+	_, s := tracing.startChild(ctx, "SQL "+name, spanClient)
+	if s == nil {
+		return
+	}
+	s.start = q.Start
+	s.SetAttr("db.system", "postgresql")
+	s.SetAttr("db.statement", q.SQL)
+	s.SetAttr("db.rows", q.Rows)
+	s.SetError(q.Err)
+	s.EndAt(q.Start.Add(q.Duration))
+}
+
+// spanExporter sends finished spans somewhere.
+type spanExporter interface {
+	export(spans []*span) error
+	close()
+}
+
+// spanJSON is a span as written by fileExporter.
+type spanJSON struct {
+	TraceID    string                 `json:"trace_id"`
+	SpanID     string                 `json:"span_id"`
+	ParentID   string                 `json:"parent_span_id,omitempty"`
+	Name       string                 `json:"name"`
+	Kind       string                 `json:"kind"`
+	Start      time.Time              `json:"start"`
+	End        time.Time              `json:"end"`
+	DurationMS float64                `json:"duration_ms"`
+	Attributes map[string]interface{} `json:"attributes,omitempty"`
+	Error      string                 `json:"error,omitempty"`
+}
+
+// fileExporter writes spans as JSON lines.
+type fileExporter struct {
+	f *os.File
+	w *bufio.Writer
+}
+
+// newFileExporter appends to the file at path, or writes to stdout for -.
+func newFileExporter(path string) (*fileExporter, error) {
+	if path == "-" {
+		return &fileExporter{f: os.Stdout, w: bufio.NewWriter(os.Stdout)}, nil
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &fileExporter{f: f, w: bufio.NewWriter(f)}, nil
+}
+
+func (e *fileExporter) export(spans []*span) error {
+	enc := json.NewEncoder(e.w)
+	for _, s := range spans {
+		s.mu.Lock()
+		js := spanJSON{
+			TraceID:    hex.EncodeToString(s.trace[:]),
+			SpanID:     hex.EncodeToString(s.id[:]),
+			Name:       s.name,
+			Kind:       spanKindNames[s.kind],
+			Start:      s.start.UTC(),
+			End:        s.end.UTC(),
+			DurationMS: float64(s.end.Sub(s.start).Microseconds()) / 1000,
+			Error:      s.err,
+		}
+		if s.parent != (spanID{}) {
+			js.ParentID = hex.EncodeToString(s.parent[:])
+		}
+		if len(s.attrs) > 0 {
+			js.Attributes = make(map[string]interface{}, len(s.attrs))
+			for _, a := range s.attrs {
+				js.Attributes[a.key] = a.value
+			}
+		}
+		s.mu.Unlock()
+		if err := enc.Encode(js); err != nil {
+			return err
+		}
+	}
+	return e.w.Flush()
+}
+
+func (e *fileExporter) close() {
+	e.w.Flush()
+	if e.f != os.Stdout {
+		e.f.Close()
+	}
+}
+
+// otlpExporter POSTs spans to an OTLP/HTTP collector in its JSON encoding.
+type otlpExporter struct {
+	url    string
+	client *http.Client
+}
+
+func newOTLPExporter(url string) *otlpExporter {
+	return &otlpExporter{url: url, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource struct {
+		Attributes []otlpAttr `json:"attributes"`
+	} `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpScopeSpans struct {
+	Scope struct {
+		Name string `json:"name"`
+	} `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpSpan struct {
+	TraceID      string     `json:"traceId"`
+	SpanID       string     `json:"spanId"`
+	ParentSpanID string     `json:"parentSpanId,omitempty"`
+	Name         string     `json:"name"`
+	Kind         spanKind   `json:"kind"`
+	Start        string     `json:"startTimeUnixNano"`
+	End          string     `json:"endTimeUnixNano"`
+	Attributes   []otlpAttr `json:"attributes,omitempty"`
+	Status       struct {
+		Code    int    `json:"code,omitempty"`
+		Message string `json:"message,omitempty"`
+	} `json:"status"`
+}
+
+type otlpAttr struct {
+	Key   string                 `json:"key"`
+	Value map[string]interface{} `json:"value"`
+}
+
+// otlpValue is v as an OTLP AnyValue. 64-bit integers are strings in the
+// JSON encoding.
+func otlpValue(v interface{}) map[string]interface{} {
+	switch x := v.(type) {
+	case string:
+		return map[string]interface{}{"stringValue": x}
+	case bool:
+		return map[string]interface{}{"boolValue": x}
+	case int:
+		return map[string]interface{}{"intValue": strconv.FormatInt(int64(x), 10)}
+	case int64:
+		return map[string]interface{}{"intValue": strconv.FormatInt(x, 10)}
+	case float64:
+		return map[string]interface{}{"doubleValue": x}
+	}
+	return map[string]interface{}{"stringValue": fmt.Sprint(v)}
+}
+
+func (e *otlpExporter) export(spans []*span) error {
+	var scope otlpScopeSpans
+	scope.Scope.Name = traceService
+	for _, s := range spans {
+		s.mu.Lock()
+		o := otlpSpan{
+			TraceID: hex.EncodeToString(s.trace[:]),
+			SpanID:  hex.EncodeToString(s.id[:]),
+			Name:    s.name,
+			Kind:    s.kind,
+			Start:   strconv.FormatInt(s.start.UnixNano(), 10),
+			End:     strconv.FormatInt(s.end.UnixNano(), 10),
+		}
+		if s.parent != (spanID{}) {
+			o.ParentSpanID = hex.EncodeToString(s.parent[:])
+		}
+		for _, a := range s.attrs {
+			o.Attributes = append(o.Attributes, otlpAttr{Key: a.key, Value: otlpValue(a.value)})
+		}
+		if s.err != "" {
+			// STATUS_CODE_ERROR
+			o.Status.Code = 2
+			o.Status.Message = s.err
+		}
+		s.mu.Unlock()
+		scope.Spans = append(scope.Spans, o)
+	}
+	var rs otlpResourceSpans
+	rs.Resource.Attributes = []otlpAttr{{Key: "service.name", Value: otlpValue(traceService)}}
+	rs.ScopeSpans = []otlpScopeSpans{scope}
+	body, err := json.Marshal(otlpRequest{ResourceSpans: []otlpResourceSpans{rs}})
+	if err != nil {
+		return err
+	}
+
+	resp, err := e.client.Post(e.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("collector answered %s", resp.Status)
+	}
+	return nil
+}
+
+func (e *otlpExporter) close() {}
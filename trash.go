@@ -0,0 +1,160 @@
+package main
+
+import (
+	poststore "SimpleRest/store"
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// trashHandler answers GET /trash/ with the deleted posts the caller may
+// restore, most recently deleted first unless sorted otherwise. It takes
+// the filter and paging parameters of GET /post/.
+func (ps *postStore) trashHandler(w http.ResponseWriter, req *http.Request) {
+	f, err := parseFilter(req.URL.Query())
+	if err != nil {
+		renderError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if f, err = actorFrom(req).Trash(f); err != nil {
+		renderStoreError(w, err)
+		return
+	}
+	if page, ok := ps.listPage(w, req, f, "-deleted"); ok {
+		renderJSON(w, page.Posts)
+	}
+}
+
+// getTrashedPostHandler answers GET /trash/{id}/ to those who may restore
+// the post.
+func (ps *postStore) getTrashedPostHandler(w http.ResponseWriter, req *http.Request) {
+	id, _ := strconv.Atoi(mux.Vars(req)["id"])
+
+	post, err := ps.store.GetTrashedPost(req.Context(), id)
+	if err == nil {
+		err = checkChange(actorFrom(req), post, post.Author)
+	}
+	if err != nil {
+		renderStoreError(w, err)
+		return
+	}
+	if notModified(w, req, post) {
+		return
+	}
+	w.Header().Set("ETag", etag(post))
+	renderJSON(w, post)
+}
+
+// restorePostHandler answers POST /post/{id}/restore by taking the post out
+// of the trash, for those who could have deleted it.
+func (ps *postStore) restorePostHandler(w http.ResponseWriter, req *http.Request) {
+	id, _ := strconv.Atoi(mux.Vars(req)["id"])
+
+	version, ok := ps.expectedVersionOf(w, req, id, ps.store.GetTrashedPost)
+	if !ok {
+		return
+	}
+	actor := actorFrom(req)
+	post, ok := ps.writePost(w, req, id, version, ps.store.GetTrashedPost, func(current poststore.Posts) (poststore.Posts, error) {
+		if err := checkChange(actor, current, current.Author); err != nil {
+			return poststore.Posts{}, err
+		}
+		return ps.store.RestorePost(req.Context(), id, current.Version, actor.Name)
+	})
+	if !ok {
+		return
+	}
+	w.Header().Set("ETag", etag(post))
+	renderJSON(w, post)
+}
+
+// purgePostHandler answers DELETE /trash/{id}/ by removing the post for
+// good.
+func (ps *postStore) purgePostHandler(w http.ResponseWriter, req *http.Request) {
+	id, _ := strconv.Atoi(mux.Vars(req)["id"])
+
+	if err := ps.store.PurgePost(req.Context(), id); err != nil {
+		renderStoreError(w, err)
+	}
+}
+
+// purgeTrashHandler answers DELETE /trash/?confirm=all by emptying the
+// trash.
+func (ps *postStore) purgeTrashHandler(w http.ResponseWriter, req *http.Request) {
+	// like DELETE /post/, a stray request must not empty it
+	if req.URL.Query().Get("confirm") != "all" {
+		renderError(w, http.StatusBadRequest, "emptying the trash needs ?confirm=all")
+		return
+	}
+	n, err := ps.store.PurgeTrash(req.Context(), time.Now())
+	if err != nil {
+		renderStoreError(w, err)
+		return
+	}
+	renderJSON(w, struct {
+		Purged int `json:"purged"`
+	}{n})
+}
+
+// maxSweepInterval bounds the time between two sweeps of the trash.
+const maxSweepInterval = time.Hour
+
+// sweeper purges the posts that have been in the trash longer than the
+// retention.
+type sweeper struct {
+	store     poststore.PostStoreManager
+	retention time.Duration
+	stop      chan struct{}
+	done      chan struct{}
+}
+
+// newSweeper starts sweeping the trash of store, at once and then every
+// retention or maxSweepInterval, whichever is shorter.
+func newSweeper(store poststore.PostStoreManager, retention time.Duration) *sweeper {
+	s := &sweeper{
+		store:     store,
+		retention: retention,
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	go s.run()
+	return s
+}
+
+// close stops sweeping, waiting for a sweep under way.
+func (s *sweeper) close() {
+	close(s.stop)
+	<-s.done
+}
+
+func (s *sweeper) run() {
+	defer close(s.done)
+	interval := s.retention
+	if interval > maxSweepInterval {
+		interval = maxSweepInterval
+	}
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+	for {
+		s.sweep()
+		select {
+		case <-s.stop:
+			return
+		case <-tick.C:
+		}
+	}
+}
+
+func (s *sweeper) sweep() {
+	before := time.Now().Add(-s.retention)
+	n, err := s.store.PurgeTrash(context.Background(), before)
+	switch {
+	case err != nil:
+		logs.Warn("cannot purge the trash", "error", err)
+	case n > 0:
+		logs.Info("purged the trash", "posts", n, "deleted_before", before.Format(time.RFC3339))
+	}
+}
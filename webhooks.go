@@ -0,0 +1,385 @@
+package main
+
+import (
+	poststore "SimpleRest/store"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Webhooks get every change of the posts they match POSTed as JSON, signed
+// with their secret. The store queues a delivery per webhook with the write
+// of the post; the dispatcher sends them, retrying failures with
+// exponential backoff until maxAttempts, after which they are dead letters
+// that can be retried by hand.
+
+const (
+	// webhookBatch is how many deliveries are claimed and sent at once.
+	webhookBatch = 20
+	// webhookPoll is how often the outbox is checked for due retries and
+	// deliveries queued by other processes.
+	webhookPoll = 5 * time.Second
+	// webhookBackoff is the delay before the first retry, doubled for each
+	// further one up to webhookMaxBackoff.
+	webhookBackoff    = 10 * time.Second
+	webhookMaxBackoff = time.Hour
+)
+
+// requestWebhook is the JSON body of webhook create requests.
+type requestWebhook struct {
+	URL    string   `json:"url"`
+	Events []string `json:"events"`
+	Author string   `json:"author"`
+	Tags   []string `json:"tags"`
+	Secret string   `json:"secret"`
+}
+
+// createdWebhook shows the secret, which is only rendered on creation.
+type createdWebhook struct {
+	poststore.Webhook
+	Secret string `json:"secret"`
+}
+
+// webhookPayload is the body POSTed to a webhook.
+type webhookPayload struct {
+	Delivery int64           `json:"delivery"`
+	Event    string          `json:"event"`
+	Time     time.Time       `json:"time"`
+	Post     poststore.Posts `json:"post"`
+}
+
+func (ps *postStore) createWebhookHandler(w http.ResponseWriter, req *http.Request) {
+	if _, ok := requireMediaType(w, req, "application/json"); !ok {
+		return
+	}
+	dec := json.NewDecoder(req.Body)
+	dec.DisallowUnknownFields()
+	var rw requestWebhook
+	if err := dec.Decode(&rw); err != nil {
+		renderError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if len(rw.Events) == 0 {
+		rw.Events = []string{poststore.Created, poststore.Updated, poststore.Deleted}
+	}
+	if rw.Secret == "" {
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			renderError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		rw.Secret = hex.EncodeToString(secret)
+	}
+
+	h, err := ps.store.CreateWebhook(req.Context(), poststore.Webhook{
+		URL:    rw.URL,
+		Events: rw.Events,
+		Author: rw.Author,
+		Tags:   rw.Tags,
+		Secret: rw.Secret,
+	})
+	if err != nil {
+		renderStoreError(w, err)
+		return
+	}
+	renderJSON(w, createdWebhook{Webhook: h, Secret: h.Secret})
+}
+
+func (ps *postStore) listWebhooksHandler(w http.ResponseWriter, req *http.Request) {
+	hooks, err := ps.store.ListWebhooks(req.Context())
+	if err != nil {
+		renderStoreError(w, err)
+		return
+	}
+	renderJSON(w, hooks)
+}
+
+func (ps *postStore) getWebhookHandler(w http.ResponseWriter, req *http.Request) {
+	id, _ := strconv.Atoi(mux.Vars(req)["id"])
+	h, err := ps.store.GetWebhook(req.Context(), id)
+	if err != nil {
+		renderStoreError(w, err)
+		return
+	}
+	renderJSON(w, h)
+}
+
+func (ps *postStore) deleteWebhookHandler(w http.ResponseWriter, req *http.Request) {
+	id, _ := strconv.Atoi(mux.Vars(req)["id"])
+	if err := ps.store.DeleteWebhook(req.Context(), id); err != nil {
+		renderStoreError(w, err)
+	}
+}
+
+// webhookDeliveriesHandler is the delivery log of a webhook, newest first,
+// optionally narrowed to a status.
+func (ps *postStore) webhookDeliveriesHandler(w http.ResponseWriter, req *http.Request) {
+	id, _ := strconv.Atoi(mux.Vars(req)["id"])
+	if _, err := ps.store.GetWebhook(req.Context(), id); err != nil {
+		renderStoreError(w, err)
+		return
+	}
+	ps.listDeliveries(w, req, id, req.URL.Query().Get("status"))
+}
+
+// deadLettersHandler lists the deliveries every webhook gave up on.
+func (ps *postStore) deadLettersHandler(w http.ResponseWriter, req *http.Request) {
+	ps.listDeliveries(w, req, 0, poststore.DeliveryDead)
+}
+
+// listDeliveries renders a page of deliveries chosen by the limit and
+// before query parameters, with a Link to the next page.
+func (ps *postStore) listDeliveries(w http.ResponseWriter, req *http.Request, webhook int, status string) {
+	q := req.URL.Query()
+	limit, ok := ps.pageLimit(w, q)
+	if !ok {
+		return
+	}
+	var before int64
+	if v := q.Get("before"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n <= 0 {
+			renderError(w, http.StatusBadRequest, fmt.Sprintf("before must be a delivery id, got %q", v))
+			return
+		}
+		before = n
+	}
+
+	list, err := ps.store.ListDeliveries(req.Context(), webhook, status, before, limit)
+	if err != nil {
+		renderStoreError(w, err)
+		return
+	}
+	if len(list) == limit {
+		u := *req.URL
+		q.Set("before", strconv.FormatInt(list[len(list)-1].ID, 10))
+		u.RawQuery = q.Encode()
+		w.Header().Set("Link", fmt.Sprintf(`<%s>; rel="next"`, u.RequestURI()))
+	}
+	renderJSON(w, list)
+}
+
+func (ps *postStore) retryDeliveryHandler(w http.ResponseWriter, req *http.Request) {
+	id, _ := strconv.ParseInt(mux.Vars(req)["id"], 10, 64)
+	d, err := ps.store.RetryDelivery(req.Context(), id)
+	if err != nil {
+		renderStoreError(w, err)
+		return
+	}
+	ps.webhooks.wakeUp()
+	renderJSON(w, d)
+}
+
+// dispatcher sends the deliveries queued in the store.
+type dispatcher struct {
+	store       poststore.PostStoreManager
+	client      *http.Client
+	maxAttempts int
+	// lease hides a claimed delivery from other dispatchers for longer
+	// than sending it can take.
+	lease time.Duration
+
+	wake chan struct{}
+	stop chan struct{}
+	done chan struct{}
+}
+
+// newDispatcher starts sending the deliveries of store, giving each attempt
+// timeout and giving up after maxAttempts.
+func newDispatcher(store poststore.PostStoreManager, timeout time.Duration, maxAttempts int) *dispatcher {
+	d := &dispatcher{
+		store: store,
+		client: &http.Client{
+			Timeout: timeout,
+			// a redirect is an error to fix in the webhook, following it
+			// would turn the POST into a GET
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		maxAttempts: maxAttempts,
+		lease:       timeout + time.Minute,
+		wake:        make(chan struct{}, 1),
+		stop:        make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+
+	// a change is committed with its deliveries, so it is worth a look;
+	// wakeUp coalesces a burst of them into one
+	stopListening := store.Changes().Listen(func(poststore.Change) { d.wakeUp() })
+	go func() {
+		defer close(d.done)
+		defer stopListening()
+		d.run()
+	}()
+	return d
+}
+
+func (d *dispatcher) wakeUp() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// close stops claiming deliveries and waits for those being sent.
+func (d *dispatcher) close() {
+	close(d.stop)
+	<-d.done
+}
+
+func (d *dispatcher) run() {
+	poll := time.NewTicker(webhookPoll)
+	defer poll.Stop()
+	for {
+		// a full batch suggests more are due
+		for d.sendBatch() == webhookBatch {
+			select {
+			case <-d.stop:
+				return
+			default:
+			}
+		}
+		select {
+		case <-d.stop:
+			return
+		case <-d.wake:
+		case <-poll.C:
+		}
+	}
+}
+
+// sendBatch sends one batch of due deliveries concurrently and returns its
+// size.
+func (d *dispatcher) sendBatch() int {
+	batch, err := d.store.ClaimDeliveries(context.Background(), webhookBatch, d.lease)
+	if err != nil {
+		logs.Error("cannot claim webhook deliveries", "error", err)
+		return 0
+	}
+	var wg sync.WaitGroup
+	for _, delivery := range batch {
+		wg.Add(1)
+		go func(delivery poststore.Delivery) {
+			defer wg.Done()
+			d.send(delivery)
+		}(delivery)
+	}
+	wg.Wait()
+	return len(batch)
+}
+
+// send makes one attempt of delivery and records its outcome, in the trace
+// of the write that queued it, or one of its own, which the receiver is
+// asked to join.
+func (d *dispatcher) send(delivery poststore.Delivery) {
+	ctx, span := tracing.startFrom(context.Background(), delivery.Traceparent, "webhook.deliver", spanClient)
+	defer span.End()
+	span.SetAttr("webhook.id", delivery.Webhook)
+	span.SetAttr("webhook.delivery", delivery.ID)
+	span.SetAttr("webhook.event", delivery.Event)
+	span.SetAttr("webhook.attempt", delivery.Attempts+1)
+
+	h, err := d.store.GetWebhook(ctx, delivery.Webhook)
+	if errors.Is(err, poststore.ErrNotFound) {
+		// deleted with its deliveries since the claim
+		return
+	}
+	if err != nil {
+		// the lease runs out and the delivery is claimed again
+		logs.Warn("cannot send delivery", "delivery", delivery.ID, "error", err)
+		return
+	}
+
+	attempt := d.post(ctx, h, delivery)
+	if attempt.Status != 0 {
+		span.SetAttr("http.status_code", attempt.Status)
+	}
+	if !attempt.Delivered {
+		span.SetError(errors.New(attempt.Error))
+	}
+	if !attempt.Delivered && delivery.Attempts+1 < d.maxAttempts {
+		attempt.RetryAt = time.Now().Add(retryDelay(delivery.Attempts + 1))
+	}
+	if err := d.store.FinishDelivery(ctx, delivery.ID, attempt); err != nil {
+		logs.Error("cannot record delivery", "delivery", delivery.ID, "error", err)
+	}
+}
+
+// post POSTs the payload of delivery to h. Any 2xx response delivers it.
+func (d *dispatcher) post(ctx context.Context, h poststore.Webhook, delivery poststore.Delivery) poststore.Attempt {
+	body, err := json.Marshal(webhookPayload{
+		Delivery: delivery.ID,
+		Event:    delivery.Event,
+		Time:     delivery.Created,
+		Post:     delivery.Post,
+	})
+	if err != nil {
+		return poststore.Attempt{Error: err.Error()}
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", h.URL, bytes.NewReader(body))
+	if err != nil {
+		return poststore.Attempt{Error: err.Error()}
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "SimpleRest-Webhooks")
+	req.Header.Set("X-SimpleRest-Event", delivery.Event)
+	req.Header.Set("X-SimpleRest-Delivery", strconv.FormatInt(delivery.ID, 10))
+	req.Header.Set("X-SimpleRest-Signature", signature(h.Secret, time.Now(), body))
+	if span := spanFrom(ctx); span != nil {
+		req.Header.Set("traceparent", span.traceparent())
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return poststore.Attempt{Error: err.Error()}
+	}
+	defer resp.Body.Close()
+	// drain some of the body so that the connection can be reused
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	attempt := poststore.Attempt{Status: resp.StatusCode}
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		attempt.Delivered = true
+	} else {
+		attempt.Error = resp.Status
+	}
+	return attempt
+}
+
+// signature is the X-SimpleRest-Signature header of body sent at t:
+// t=<unix seconds>,v1=<hex HMAC-SHA256 of "<unix seconds>.<body>">.
+// Receivers recompute it and reject old timestamps to stop replays.
+func signature(secret string, t time.Time, body []byte) string {
+	ts := strconv.FormatInt(t.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(ts + "."))
+	mac.Write(body)
+	return "t=" + ts + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// retryDelay is the wait after the given number of failed attempts.
+func retryDelay(attempts int) time.Duration {
+	delay := webhookBackoff
+	for i := 1; i < attempts && delay < webhookMaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > webhookMaxBackoff {
+		delay = webhookMaxBackoff
+	}
+	return delay
+}